@@ -0,0 +1,318 @@
+// Package dataexport builds the archive of everything stored about a user,
+// for users to download their personal data. The archive is a zip file with
+// a JSON file per kind of record and, for lists, a CSV file as well.
+package dataexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Parjun2000/task-manager/utils"
+)
+
+// pageSize is the number of tasks read per query.
+const pageSize = 500
+
+// Account is the user's account and profile, as exported.
+type Account struct {
+	ID          int        `json:"id"`
+	Username    string     `json:"username"`
+	Role        string     `json:"role"`
+	HasPassword bool       `json:"has_password"`
+	DisabledAt  *time.Time `json:"disabled_at"`
+	utils.Profile
+}
+
+// TwoFactor is the state of the user's two-factor authentication, without
+// its secret.
+type TwoFactor struct {
+	Enabled           bool       `json:"enabled"`
+	ConfirmedAt       *time.Time `json:"confirmed_at"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// Session is a login of the user, kept alive by the refresh tokens of one
+// family. Which device a session is on is not recorded, so it is not exported.
+type Session struct {
+	StartedAt time.Time `json:"started_at"`
+	// RefreshedAt is when the latest refresh token of the session was issued.
+	RefreshedAt time.Time  `json:"refreshed_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+// Build returns the archive of the user's data, including the sessions of
+// the user and the admin audit log entries about them. Secrets such as
+// password hashes, token hashes and the TOTP secret are left out.
+func Build(ctx context.Context, db utils.Storage, userID int) ([]byte, error) {
+	user, err := db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := db.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := allTasks(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := db.GetTags(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := db.GetPersonalAccessTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := db.GetExternalIdentities(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	refreshTokens, err := db.GetRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := sessionsOf(refreshTokens)
+	auditLog, err := db.GetAuditLogForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	var twoFactor TwoFactor
+	credential, err := db.GetTOTP(ctx, userID)
+	switch {
+	case err == nil:
+		if credential.ConfirmedAt != nil {
+			twoFactor = TwoFactor{Enabled: true, ConfirmedAt: credential.ConfirmedAt, RecoveryCodesLeft: credential.RecoveryCodesLeft}
+		}
+	case !errors.Is(err, utils.ErrTOTPNotFound):
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := []struct {
+		name  string
+		write func(*zip.Writer, string) error
+	}{
+		{"account.json", jsonFile(Account{
+			ID:          user.ID,
+			Username:    user.Username,
+			Role:        user.Role,
+			HasPassword: user.Password != "",
+			DisabledAt:  user.DisabledAt,
+			Profile:     profile,
+		})},
+		{"tasks.json", jsonFile(tasks)},
+		{"tasks.csv", csvFile(taskRecords(tasks))},
+		{"tags.json", jsonFile(tags)},
+		{"tags.csv", csvFile(tagRecords(tags))},
+		{"personal_access_tokens.json", jsonFile(tokens)},
+		{"personal_access_tokens.csv", csvFile(tokenRecords(tokens))},
+		{"external_identities.json", jsonFile(identities)},
+		{"external_identities.csv", csvFile(identityRecords(identities))},
+		{"two_factor.json", jsonFile(twoFactor)},
+		{"sessions.json", jsonFile(sessions)},
+		{"sessions.csv", csvFile(sessionRecords(sessions))},
+		{"audit_log.json", jsonFile(auditLog)},
+		{"audit_log.csv", csvFile(auditRecords(auditLog))},
+	}
+	for _, file := range files {
+		if err := file.write(archive, file.name); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// allTasks reads every task of the user, oldest first. Pages follow a
+// cursor, so tasks added or deleted while the export runs neither shift
+// other tasks out of it nor into it twice.
+func allTasks(ctx context.Context, db utils.Storage, userID int) ([]utils.Task, error) {
+	tasks := make([]utils.Task, 0)
+	var cursor *utils.TaskCursor
+	for {
+		batch, err := db.GetTasksByCursor(ctx, userID, cursor, pageSize, "created_at", "asc", utils.TaskFilter{})
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, batch...)
+		if len(batch) < pageSize {
+			return tasks, nil
+		}
+		next := utils.TaskCursorAt(batch[len(batch)-1], "created_at", false)
+		cursor = &next
+	}
+}
+
+// sessionsOf groups refresh tokens, oldest first, into the sessions of their
+// families, in the order the sessions started.
+func sessionsOf(tokens []utils.RefreshToken) []Session {
+	sessions := make([]Session, 0)
+	index := make(map[string]int)
+	for _, token := range tokens {
+		i, ok := index[token.FamilyID]
+		if !ok {
+			i = len(sessions)
+			index[token.FamilyID] = i
+			sessions = append(sessions, Session{StartedAt: token.CreatedAt})
+		}
+		session := &sessions[i]
+		session.RefreshedAt = token.CreatedAt
+		session.ExpiresAt = token.ExpiresAt
+		if session.RevokedAt == nil {
+			session.RevokedAt = token.RevokedAt
+		}
+	}
+	return sessions
+}
+
+// jsonFile writes v as an indented JSON file.
+func jsonFile(v interface{}) func(*zip.Writer, string) error {
+	return func(archive *zip.Writer, name string) error {
+		w, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+}
+
+// csvFile writes records, the first being the header, as a CSV file.
+func csvFile(records [][]string) func(*zip.Writer, string) error {
+	return func(archive *zip.Writer, name string) error {
+		w, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		writer := csv.NewWriter(w)
+		for _, record := range records {
+			for i, field := range record {
+				record[i] = escapeFormula(field)
+			}
+		}
+		if err := writer.WriteAll(records); err != nil {
+			return err
+		}
+		return writer.Error()
+	}
+}
+
+// escapeFormula keeps spreadsheets from running user text that looks like a
+// formula, by prefixing it with a quote.
+func escapeFormula(field string) string {
+	if field != "" && strings.ContainsRune("=+-@\t\r", rune(field[0])) {
+		return "'" + field
+	}
+	return field
+}
+
+func taskRecords(tasks []utils.Task) [][]string {
+	records := [][]string{{"id", "title", "description", "status", "priority", "created_at", "due_at", "due_timezone", "parent_id", "recurrence", "tags", "blocked_by"}}
+	for _, task := range tasks {
+		blockedBy := make([]string, 0, len(task.BlockedBy))
+		for _, id := range task.BlockedBy {
+			blockedBy = append(blockedBy, strconv.Itoa(id))
+		}
+		records = append(records, []string{
+			strconv.Itoa(task.ID),
+			task.Title,
+			task.Description,
+			task.Status,
+			task.Priority,
+			formatTime(&task.CreatedAt),
+			formatTime(task.DueAt),
+			task.DueTimezone,
+			formatID(task.ParentID),
+			task.Recurrence,
+			strings.Join(task.Tags, ";"),
+			strings.Join(blockedBy, ";"),
+		})
+	}
+	return records
+}
+
+func tagRecords(tags []utils.Tag) [][]string {
+	records := [][]string{{"id", "name"}}
+	for _, tag := range tags {
+		records = append(records, []string{strconv.Itoa(tag.ID), tag.Name})
+	}
+	return records
+}
+
+func tokenRecords(tokens []utils.PersonalAccessToken) [][]string {
+	records := [][]string{{"id", "name", "scope", "created_at", "expires_at", "last_used_at"}}
+	for _, token := range tokens {
+		records = append(records, []string{
+			strconv.Itoa(token.ID),
+			token.Name,
+			token.Scope,
+			formatTime(&token.CreatedAt),
+			formatTime(token.ExpiresAt),
+			formatTime(token.LastUsedAt),
+		})
+	}
+	return records
+}
+
+func identityRecords(identities []utils.ExternalIdentity) [][]string {
+	records := [][]string{{"id", "issuer", "subject", "created_at"}}
+	for _, identity := range identities {
+		records = append(records, []string{strconv.Itoa(identity.ID), identity.Issuer, identity.Subject, formatTime(&identity.CreatedAt)})
+	}
+	return records
+}
+
+func sessionRecords(sessions []Session) [][]string {
+	records := [][]string{{"started_at", "refreshed_at", "expires_at", "revoked_at"}}
+	for _, session := range sessions {
+		records = append(records, []string{
+			formatTime(&session.StartedAt),
+			formatTime(&session.RefreshedAt),
+			formatTime(&session.ExpiresAt),
+			formatTime(session.RevokedAt),
+		})
+	}
+	return records
+}
+
+func auditRecords(entries []utils.AuditEntry) [][]string {
+	records := [][]string{{"id", "actor_id", "action", "status_code", "created_at"}}
+	for _, entry := range entries {
+		records = append(records, []string{
+			strconv.Itoa(entry.ID),
+			strconv.Itoa(entry.ActorID),
+			entry.Action,
+			strconv.Itoa(entry.StatusCode),
+			formatTime(&entry.CreatedAt),
+		})
+	}
+	return records
+}
+
+// formatTime writes times as RFC 3339, and missing ones as an empty field.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
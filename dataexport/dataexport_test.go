@@ -0,0 +1,153 @@
+package dataexport
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	ctx := context.Background()
+	db := utils.NewMemoryDB()
+	alice, err := db.CreateUser(ctx, utils.User{Username: "alice", Password: "$2a$10$hashedpasswordhashedpassword"})
+	require.NoError(t, err)
+	bob, err := db.CreateUser(ctx, utils.User{Username: "bob", Password: "$2a$10$hashedpasswordhashedpassword"})
+	require.NoError(t, err)
+	require.NoError(t, db.UpdateProfile(ctx, alice, utils.Profile{DisplayName: "Alice", Email: "alice@example.com", Timezone: "UTC", DefaultSortBy: "title", DefaultSortOrder: "asc"}))
+	for i := 0; i < pageSize+1; i++ {
+		_, err := db.CreateTask(ctx, utils.Task{Title: "task", Status: "todo", UserID: alice})
+		require.NoError(t, err)
+	}
+	_, err = db.CreateTask(ctx, utils.Task{Title: "=HYPERLINK(\"http://evil.example.com\")", Description: "line one\nline two", Status: "todo", UserID: alice, Tags: []string{"home", "work"}})
+	require.NoError(t, err)
+	_, err = db.CreateTask(ctx, utils.Task{Title: "bob's task", Status: "todo", UserID: bob})
+	require.NoError(t, err)
+	require.NoError(t, db.SetTOTPSecret(ctx, alice, "JBSWY3DPEHPK3PXP"))
+	login := utils.RefreshToken{UserID: alice, FamilyID: "family-a", TokenHash: "hash-a1", AccessJTI: "jti-a1", ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, db.CreateRefreshToken(ctx, login))
+	_, err = db.RotateRefreshToken(ctx, login.TokenHash, utils.RefreshToken{TokenHash: "hash-a2", AccessJTI: "jti-a2", ExpiresAt: time.Now().Add(2 * time.Hour)})
+	require.NoError(t, err)
+	revoked := utils.RefreshToken{UserID: alice, FamilyID: "family-b", TokenHash: "hash-b1", AccessJTI: "jti-b1", ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, db.CreateRefreshToken(ctx, revoked))
+	require.NoError(t, db.RevokeRefreshTokenFamily(ctx, revoked.TokenHash))
+	require.NoError(t, db.CreateRefreshToken(ctx, utils.RefreshToken{UserID: bob, FamilyID: "family-c", TokenHash: "hash-c1", AccessJTI: "jti-c1", ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, db.CreateAuditEntry(ctx, utils.AuditEntry{ActorID: bob, Action: "PUT /api/v1/admin/users/:id/disable", TargetUserID: &alice, StatusCode: 200}))
+	require.NoError(t, db.CreateAuditEntry(ctx, utils.AuditEntry{ActorID: bob, Action: "GET /api/v1/admin/users", StatusCode: 200}))
+
+	data, err := Build(ctx, db, alice)
+	require.NoError(t, err)
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := make(map[string][]byte)
+	for _, file := range archive.File {
+		r, err := file.Open()
+		require.NoError(t, err)
+		files[file.Name], err = io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+	}
+	assert.Len(t, files, 14)
+
+	var account Account
+	require.NoError(t, json.Unmarshal(files["account.json"], &account))
+	assert.Equal(t, "alice", account.Username)
+	assert.Equal(t, "alice@example.com", account.Email)
+	assert.True(t, account.HasPassword)
+	assert.NotContains(t, string(files["account.json"]), "hashedpassword")
+
+	// Every task of the user, over several pages, and only theirs
+	var tasks []utils.Task
+	require.NoError(t, json.Unmarshal(files["tasks.json"], &tasks))
+	assert.Len(t, tasks, pageSize+2)
+	records, err := csv.NewReader(bytes.NewReader(files["tasks.csv"])).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, pageSize+3)
+	last := records[len(records)-1]
+	assert.Equal(t, `'=HYPERLINK("http://evil.example.com")`, last[1])
+	assert.Equal(t, "line one\nline two", last[2])
+	assert.Equal(t, "home;work", last[10])
+	assert.NotContains(t, string(files["tasks.json"]), "bob's task")
+
+	// Pending two-factor setups are not enabled, and the secret never leaves
+	assert.JSONEq(t, `{"enabled":false,"confirmed_at":null,"recovery_codes_left":0}`, string(files["two_factor.json"]))
+	for name, content := range files {
+		assert.NotContains(t, string(content), "JBSWY3DPEHPK3PXP", name)
+	}
+
+	// Sessions, one per token family, and what admins did to the user
+	var sessions []Session
+	require.NoError(t, json.Unmarshal(files["sessions.json"], &sessions))
+	require.Len(t, sessions, 2)
+	assert.True(t, sessions[0].ExpiresAt.After(time.Now().Add(time.Hour)))
+	assert.Nil(t, sessions[0].RevokedAt)
+	assert.NotNil(t, sessions[1].RevokedAt)
+	records, err = csv.NewReader(bytes.NewReader(files["sessions.csv"])).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+	for name, content := range files {
+		assert.NotContains(t, string(content), "hash-a", name)
+		assert.NotContains(t, string(content), "jti-c1", name)
+	}
+	var auditLog []utils.AuditEntry
+	require.NoError(t, json.Unmarshal(files["audit_log.json"], &auditLog))
+	require.Len(t, auditLog, 1)
+	assert.Equal(t, "PUT /api/v1/admin/users/:id/disable", auditLog[0].Action)
+
+	_, err = Build(ctx, db, 999)
+	assert.ErrorIs(t, err, utils.ErrUserNotFound)
+}
+
+// deletingStore deletes the user's oldest task after the first page is read,
+// as a user could while their export runs.
+type deletingStore struct {
+	*utils.MemoryDB
+	deleted bool
+}
+
+func (s *deletingStore) GetTasksByCursor(ctx context.Context, userId int, cursor *utils.TaskCursor, limit int, sortBy, order string, filter utils.TaskFilter) ([]utils.Task, error) {
+	tasks, err := s.MemoryDB.GetTasksByCursor(ctx, userId, cursor, limit, sortBy, order, filter)
+	return s.deleteFirst(ctx, userId, tasks, err)
+}
+
+func (s *deletingStore) GetTasksWithParams(ctx context.Context, userId, page, limit int, sortBy, order string, filter utils.TaskFilter) ([]utils.Task, error) {
+	tasks, err := s.MemoryDB.GetTasksWithParams(ctx, userId, page, limit, sortBy, order, filter)
+	return s.deleteFirst(ctx, userId, tasks, err)
+}
+
+func (s *deletingStore) deleteFirst(ctx context.Context, userId int, tasks []utils.Task, err error) ([]utils.Task, error) {
+	if err == nil && !s.deleted && len(tasks) > 0 {
+		s.deleted = true
+		err = s.MemoryDB.DeleteTask(ctx, userId, tasks[0].ID, 0, utils.DetachChildren)
+	}
+	return tasks, err
+}
+
+func TestAllTasksWhileDeleting(t *testing.T) {
+	ctx := context.Background()
+	db := &deletingStore{MemoryDB: utils.NewMemoryDB()}
+	alice, err := db.CreateUser(ctx, utils.User{Username: "alice", Password: "$2a$10$hashedpasswordhashedpassword"})
+	require.NoError(t, err)
+	for i := 0; i < 2*pageSize+1; i++ {
+		_, err := db.CreateTask(ctx, utils.Task{Title: "task", Status: "todo", UserID: alice})
+		require.NoError(t, err)
+	}
+
+	// No task of a later page is skipped for the one deleted before it
+	tasks, err := allTasks(ctx, db, alice)
+	require.NoError(t, err)
+	assert.Len(t, tasks, 2*pageSize+1)
+	seen := make(map[int]bool)
+	for _, task := range tasks {
+		assert.False(t, seen[task.ID], task.ID)
+		seen[task.ID] = true
+	}
+}
@@ -0,0 +1,401 @@
+// Package filterexpr parses the filter expressions of task lists, such as
+//
+//	status in (todo, "in progress") and created_at > 2026-01-01 and title ~ "invoice"
+//
+// into a syntax tree whose fields and values are checked against the fields
+// a list can be filtered by.
+//
+// Comparisons are joined with and, or and not, in that order of precedence
+// from loosest to tightest, and grouped with parentheses. They compare a
+// field with = and !=, times also with <, <=, > and >=, text with ~ for
+// containing a value regardless of case, and text and lists with in or
+// not in for any of several values. Values are bare words or double quoted
+// strings with backslash escapes. Time values are RFC 3339 times or dates,
+// which are taken as midnight UTC. Fields that can be empty compare with
+// null using = and !=. Keywords are not case sensitive.
+package filterexpr
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxDepth bounds the nesting of an expression.
+const MaxDepth = 32
+
+// Kind is the type of a field.
+type Kind int
+
+const (
+	// Text fields hold a string.
+	Text Kind = iota + 1
+	// Time fields hold a time.
+	Time
+	// List fields hold several strings and match when any of them does.
+	List
+)
+
+// Field describes a field expressions may use.
+type Field struct {
+	Kind Kind
+	// Nullable fields can be compared with null.
+	Nullable bool
+}
+
+// Op is a comparison operator.
+type Op string
+
+const (
+	Equal        Op = "="
+	NotEqual     Op = "!="
+	Less         Op = "<"
+	LessEqual    Op = "<="
+	Greater      Op = ">"
+	GreaterEqual Op = ">="
+	Contains     Op = "~"
+	In           Op = "in"
+)
+
+// operators lists the operators each kind of field takes.
+var operators = map[Kind][]Op{
+	Text: {Equal, NotEqual, Contains, In},
+	Time: {Equal, NotEqual, Less, LessEqual, Greater, GreaterEqual},
+	List: {Equal, NotEqual, Contains, In},
+}
+
+// Expr is a node of the syntax tree: And, Or, Not or Comparison.
+type Expr interface {
+	expr()
+}
+
+// And matches when both sides do.
+type And struct {
+	Left, Right Expr
+}
+
+// Or matches when either side does.
+type Or struct {
+	Left, Right Expr
+}
+
+// Not matches when Expr does not.
+type Not struct {
+	Expr Expr
+}
+
+// Comparison compares a field with a value, or with any of several for In.
+type Comparison struct {
+	Field string
+	Kind  Kind
+	Op    Op
+	// Values holds the value compared with, or the values of In.
+	Values []Value
+}
+
+// Value is a value of a comparison: Text for text and list fields, Time
+// for time fields, or Null.
+type Value struct {
+	Text string
+	Time time.Time
+	Null bool
+}
+
+func (And) expr()        {}
+func (Or) expr()         {}
+func (Not) expr()        {}
+func (Comparison) expr() {}
+
+// Error is a syntax error or an invalid field or value in an expression.
+type Error struct {
+	// Pos is the byte offset in the expression at which the error was found.
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Parse parses an expression using the given fields.
+func Parse(s string, fields map[string]Field) (Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, fields: fields}
+	expr, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+	}
+	return expr, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenLeft
+	tokenRight
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEnd:
+		return "end of filter"
+	case tokenString:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+// keyword reports whether the token is the given keyword.
+func (t token) keyword(name string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, name)
+}
+
+// lex splits an expression into tokens.
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLeft, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRight, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case c == '"':
+			var b strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i >= len(s) {
+					return nil, &Error{Pos: start, Msg: "unterminated string"}
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				if s[i] == '\\' {
+					i++
+					if i >= len(s) || (s[i] != '"' && s[i] != '\\') {
+						return nil, &Error{Pos: i - 1, Msg: "invalid escape"}
+					}
+				}
+				b.WriteByte(s[i])
+			}
+			tokens = append(tokens, token{tokenString, b.String(), start})
+		case strings.IndexByte("=!<>~", c) >= 0:
+			op := string(c)
+			if i+1 < len(s) && s[i+1] == '=' && c != '=' && c != '~' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &Error{Pos: i, Msg: "unexpected '!'"}
+			}
+			tokens = append(tokens, token{tokenOp, op, i})
+			i += len(op)
+		default:
+			start := i
+			for i < len(s) && !unicode.IsSpace(rune(s[i])) && strings.IndexByte("()\",=!<>~", s[i]) < 0 {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, s[start:i], start})
+		}
+	}
+	return append(tokens, token{tokenEnd, "", len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	fields map[string]Field
+}
+
+func (p *parser) peek() token {
+	return p.tokens[0]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[0]
+	if t.kind != tokenEnd {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+// or parses comparisons joined by and, or and not.
+func (p *parser) or(depth int) (Expr, error) {
+	left, err := p.and(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().keyword("or") {
+		p.next()
+		right, err := p.and(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// and parses comparisons joined by and and not.
+func (p *parser) and(depth int) (Expr, error) {
+	left, err := p.unary(depth)
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().keyword("and") {
+		p.next()
+		right, err := p.unary(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// unary parses a negation, a group or a comparison.
+func (p *parser) unary(depth int) (Expr, error) {
+	t := p.peek()
+	if depth >= MaxDepth {
+		return nil, &Error{Pos: t.pos, Msg: "filter is nested too deeply"}
+	}
+	switch {
+	case t.keyword("not"):
+		p.next()
+		expr, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: expr}, nil
+	case t.kind == tokenLeft:
+		p.next()
+		expr, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRight {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected ')' instead of %s", t)}
+		}
+		return expr, nil
+	}
+	return p.comparison()
+}
+
+// comparison parses a field compared with a value or a list of values.
+func (p *parser) comparison() (Expr, error) {
+	name := p.next()
+	if name.kind != tokenWord {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("expected a field instead of %s", name)}
+	}
+	field, ok := p.fields[strings.ToLower(name.text)]
+	if !ok {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("unknown field %q", name.text)}
+	}
+	comparison := Comparison{Field: strings.ToLower(name.text), Kind: field.Kind}
+
+	negated := false
+	t := p.next()
+	switch {
+	case t.kind == tokenOp:
+		comparison.Op = Op(t.text)
+	case t.keyword("not") && p.peek().keyword("in"):
+		p.next()
+		negated = true
+		comparison.Op = In
+	case t.keyword("in"):
+		comparison.Op = In
+	default:
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected an operator instead of %s", t)}
+	}
+	if !allowed(field.Kind, comparison.Op) {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("%s cannot be compared with %s", comparison.Field, comparison.Op)}
+	}
+
+	if comparison.Op != In {
+		value, err := p.value(comparison, field)
+		if err != nil {
+			return nil, err
+		}
+		comparison.Values = []Value{value}
+		return comparison, nil
+	}
+	if t := p.next(); t.kind != tokenLeft {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected '(' instead of %s", t)}
+	}
+	for {
+		value, err := p.value(comparison, field)
+		if err != nil {
+			return nil, err
+		}
+		comparison.Values = append(comparison.Values, value)
+		t := p.next()
+		if t.kind == tokenRight {
+			break
+		}
+		if t.kind != tokenComma {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected ',' or ')' instead of %s", t)}
+		}
+	}
+	if negated {
+		return Not{Expr: comparison}, nil
+	}
+	return comparison, nil
+}
+
+// value parses a value of the field compared.
+func (p *parser) value(comparison Comparison, field Field) (Value, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenWord && strings.EqualFold(t.text, "null"):
+		if !field.Nullable || (comparison.Op != Equal && comparison.Op != NotEqual) {
+			return Value{}, &Error{Pos: t.pos, Msg: fmt.Sprintf("%s cannot be compared with null", comparison.Field)}
+		}
+		return Value{Null: true}, nil
+	case t.kind != tokenWord && t.kind != tokenString:
+		return Value{}, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected a value instead of %s", t)}
+	case field.Kind == Time:
+		if at, err := time.Parse(time.RFC3339, t.text); err == nil {
+			return Value{Time: at}, nil
+		}
+		at, err := time.Parse("2006-01-02", t.text)
+		if err != nil {
+			return Value{}, &Error{Pos: t.pos, Msg: fmt.Sprintf("invalid time %s", t)}
+		}
+		return Value{Time: at}, nil
+	}
+	return Value{Text: t.text}, nil
+}
+
+// allowed reports whether fields of a kind take an operator.
+func allowed(kind Kind, op Op) bool {
+	for _, allowed := range operators[kind] {
+		if allowed == op {
+			return true
+		}
+	}
+	return false
+}
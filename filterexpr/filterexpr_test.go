@@ -0,0 +1,89 @@
+package filterexpr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testFields = map[string]Field{
+	"title":      {Kind: Text},
+	"status":     {Kind: Text},
+	"tags":       {Kind: List},
+	"created_at": {Kind: Time},
+	"due_at":     {Kind: Time, Nullable: true},
+}
+
+func TestParse(t *testing.T) {
+	expr, err := Parse(`status in (todo,"in progress") and created_at > 2026-01-01 and title ~ "invoice"`, testFields)
+	require.NoError(t, err)
+	assert.Equal(t, And{
+		Left: And{
+			Left:  Comparison{Field: "status", Kind: Text, Op: In, Values: []Value{{Text: "todo"}, {Text: "in progress"}}},
+			Right: Comparison{Field: "created_at", Kind: Time, Op: Greater, Values: []Value{{Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+		},
+		Right: Comparison{Field: "title", Kind: Text, Op: Contains, Values: []Value{{Text: "invoice"}}},
+	}, expr)
+
+	// and binds tighter than or, not tighter than and
+	expr, err = Parse(`NOT title = a OR title = b And tags != c`, testFields)
+	require.NoError(t, err)
+	assert.Equal(t, Or{
+		Left: Not{Expr: Comparison{Field: "title", Kind: Text, Op: Equal, Values: []Value{{Text: "a"}}}},
+		Right: And{
+			Left:  Comparison{Field: "title", Kind: Text, Op: Equal, Values: []Value{{Text: "b"}}},
+			Right: Comparison{Field: "tags", Kind: List, Op: NotEqual, Values: []Value{{Text: "c"}}},
+		},
+	}, expr)
+
+	expr, err = Parse(`not (title="say \"hi\"" or due_at=null) and tags not in (x) and due_at<=2026-03-01T09:00:00+01:00`, testFields)
+	require.NoError(t, err)
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.FixedZone("", 3600))
+	assert.Equal(t, And{
+		Left: And{
+			Left: Not{Expr: Or{
+				Left:  Comparison{Field: "title", Kind: Text, Op: Equal, Values: []Value{{Text: `say "hi"`}}},
+				Right: Comparison{Field: "due_at", Kind: Time, Op: Equal, Values: []Value{{Null: true}}},
+			}},
+			Right: Not{Expr: Comparison{Field: "tags", Kind: List, Op: In, Values: []Value{{Text: "x"}}}},
+		},
+		Right: Comparison{Field: "due_at", Kind: Time, Op: LessEqual, Values: []Value{{Time: due}}},
+	}, expr)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		filter, err string
+	}{
+		{``, "expected a field instead of end of filter at position 0"},
+		{`owner = me`, `unknown field "owner" at position 0`},
+		{`title < b`, "title cannot be compared with < at position 6"},
+		{`created_at ~ 2026`, "created_at cannot be compared with ~ at position 11"},
+		{`created_at > yesterday`, "invalid time 'yesterday' at position 13"},
+		{`created_at = null`, "created_at cannot be compared with null at position 13"},
+		{`due_at > null`, "due_at cannot be compared with null at position 9"},
+		{`title = "open`, "unterminated string at position 8"},
+		{`title = "\n"`, "invalid escape at position 9"},
+		{`title ! a`, "unexpected '!' at position 6"},
+		{`title = a b`, "unexpected 'b' at position 10"},
+		{`title = a and`, "expected a field instead of end of filter at position 13"},
+		{`(title = a`, "expected ')' instead of end of filter at position 10"},
+		{`status in todo`, "expected '(' instead of 'todo' at position 10"},
+		{`status in (a b)`, "expected ',' or ')' instead of 'b' at position 13"},
+		{`status in ()`, "expected a value instead of ')' at position 11"},
+		{`status is null`, "expected an operator instead of 'is' at position 7"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.filter, testFields)
+		assert.EqualError(t, err, tt.err, tt.filter)
+	}
+
+	deep := ""
+	for i := 0; i < MaxDepth; i++ {
+		deep += "("
+	}
+	_, err := Parse(deep+"title = a", testFields)
+	assert.EqualError(t, err, "filter is nested too deeply at position 32")
+}
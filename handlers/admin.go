@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/models"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// extractPageParams reads the page and limit query parameters, the limit at
+// most maxPageLimit.
+func extractPageParams(c *gin.Context) (int, int, error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page <= 0 {
+		return 0, 0, errors.New("invalid page number")
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		return 0, 0, errors.New("invalid limit")
+	}
+	if limit > maxPageLimit {
+		return 0, 0, fmt.Errorf("limit must be at most %d", maxPageLimit)
+	}
+	return page, limit, nil
+}
+
+// @Summary		List users
+// @Description	List every user with their role and whether their account is disabled
+// @Tags			Admin
+// @Produce		application/json
+// @Security		JWT
+// @Param			page	query		int	false	"Page number"
+// @Param			limit	query		int	false	"Items per page, at most 100"
+// @Success		200		{array}		utils.User
+// @Failure		400		{object}	object{error=string}	"Error Message"
+// @Failure		403		{object}	object{error=string}	"Forbidden: Insufficient role"
+// @Failure		500		{object}	object{error=string}	"Internal Server Error"
+// @Failure		503		{object}	object{error=string}	"Request cancelled"
+// @Failure		504		{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/admin/users [get]
+func GetUsers(c *gin.Context) {
+	page, limit, err := extractPageParams(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	users, err := db.GetUsers(c.Request.Context(), page, limit)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
+	}
+	c.JSON(200, users)
+}
+
+// @Summary		Change a user's role
+// @Description	Make a user an admin or a regular user. Admins cannot change their own role
+// @Tags			Admin
+// @Accept			application/json
+// @Security		JWT
+// @Param			id			path		int						true	"User ID"
+// @Param			RoleChange	body		models.RoleChange		true	"New role"
+// @Success		200			{object}	object{message=string}	"Role updated successfully"
+// @Failure		400			{object}	object{error=string}	"Invalid JSON"
+// @Failure		400			{object}	object{error=string}	"Invalid User Id"
+// @Failure		400			{object}	object{error=string}	"Validation error"
+// @Failure		403			{object}	object{error=string}	"Forbidden: Insufficient role"
+// @Failure		404			{object}	object{error=string}	"User not found"
+// @Failure		409			{object}	object{error=string}	"Admins cannot change their own role"
+// @Failure		500			{object}	object{error=string}	"Internal Server Error"
+// @Failure		503			{object}	object{error=string}	"Request cancelled"
+// @Failure		504			{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/admin/users/{id}/role [put]
+func SetUserRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid User Id"})
+		return
+	}
+	var details models.RoleChange
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	// Keeps the last admin from locking everyone out
+	if id == userId.(int) {
+		c.JSON(409, gin.H{"error": "Admins cannot change their own role"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.SetUserRole(c.Request.Context(), id, details.Role); err != nil {
+		helpers.RespondStorageError(c, err, 404, "User not found")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Role updated successfully"})
+}
+
+// @Summary		Disable a user
+// @Description	Disable a user's account. The user is signed out everywhere and can no longer log in. Admins cannot disable their own account
+// @Tags			Admin
+// @Security		JWT
+// @Param			id	path		int						true	"User ID"
+// @Success		200	{object}	object{message=string}	"User disabled successfully"
+// @Failure		400	{object}	object{error=string}	"Invalid User Id"
+// @Failure		403	{object}	object{error=string}	"Forbidden: Insufficient role"
+// @Failure		404	{object}	object{error=string}	"User not found"
+// @Failure		409	{object}	object{error=string}	"Admins cannot disable their own account"
+// @Failure		500	{object}	object{error=string}	"Internal Server Error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/admin/users/{id}/disable [put]
+func DisableUser(c *gin.Context) {
+	setUserDisabled(c, true)
+}
+
+// @Summary		Enable a user
+// @Description	Enable a disabled user's account so the user can log in again
+// @Tags			Admin
+// @Security		JWT
+// @Param			id	path		int						true	"User ID"
+// @Success		200	{object}	object{message=string}	"User enabled successfully"
+// @Failure		400	{object}	object{error=string}	"Invalid User Id"
+// @Failure		403	{object}	object{error=string}	"Forbidden: Insufficient role"
+// @Failure		404	{object}	object{error=string}	"User not found"
+// @Failure		500	{object}	object{error=string}	"Internal Server Error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/admin/users/{id}/enable [put]
+func EnableUser(c *gin.Context) {
+	setUserDisabled(c, false)
+}
+
+// setUserDisabled handles DisableUser and EnableUser.
+func setUserDisabled(c *gin.Context, disabled bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid User Id"})
+		return
+	}
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	if disabled && id == userId.(int) {
+		c.JSON(409, gin.H{"error": "Admins cannot disable their own account"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.SetUserDisabled(c.Request.Context(), id, disabled); err != nil {
+		helpers.RespondStorageError(c, err, 404, "User not found")
+		return
+	}
+	if disabled {
+		c.JSON(200, gin.H{"message": "User disabled successfully"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "User enabled successfully"})
+}
+
+// @Summary		Get a user's tasks
+// @Description	Get any user's tasks with the pagination, sorting and filters of the tasks list
+// @Tags			Admin
+// @Produce		application/json
+// @Security		JWT
+// @Param			id			path		int			true	"User ID"
+// @Param			cursor		query		string		false	"Cursor of the page to read, empty for the first page"
+// @Param			page		query		int			false	"Page number, when reading without a cursor"
+// @Param			limit		query		int			false	"Items per page, at most 100"
+// @Param			sort_by		query		string		false	"Sort by title/status/description/created_at/due_at/priority"
+// @Param			order		query		string		false	"Sort order: asc/desc"
+// @Param			status		query		string		false	"Filter by task status"
+// @Param			priority	query		string		false	"Filter by task priority: low/medium/high/urgent"
+// @Param			overdue		query		bool		false	"Filter tasks past their due date that are not done"
+// @Param			due_before	query		string		false	"Filter tasks due before an RFC 3339 time or YYYY-MM-DD date"
+// @Param			due_after	query		string		false	"Filter tasks due after an RFC 3339 time or YYYY-MM-DD date"
+// @Param			tag			query		[]string	false	"Filter by tag name, repeatable"	collectionFormat(multi)
+// @Param			tag_mode		query		string		false	"Match any (default) or all of the given tags"
+// @Param			filter		query		string		false	"Filter expression, e.g. status in (todo, \"in progress\") and title ~ invoice"
+// @Param			Prefer			header		string		false	"envelope, to get pages read by number in a TaskPage"
+// @Param			If-None-Match	header		string		false	"ETag of a cached response"
+// @Success		200			{array}		utils.Task
+// @Success		200			{object}	TaskPage	"Page read with a cursor, or with Prefer: envelope"
+// @Header			200			{string}	ETag	"Entity tag of the response"
+// @Header			200			{string}	Link	"Links to the first, previous, next and last pages"
+// @Success		304			"Not modified"
+// @Failure		400			{object}	object{error=string}	"Invalid User Id"
+// @Failure		400			{object}	object{error=string}	"Error Message"
+// @Failure		403			{object}	object{error=string}	"Forbidden: Insufficient role"
+// @Failure		404			{object}	object{error=string}	"User not found"
+// @Failure		500			{object}	object{error=string}	"Internal Server Error"
+// @Failure		503			{object}	object{error=string}	"Request cancelled"
+// @Failure		504			{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/admin/users/{id}/tasks [get]
+func GetUserTasks(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid User Id"})
+		return
+	}
+	page, limit, sortBy, order, filter, err := extractPaginationParams(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	cursor, withCursor, err := extractCursor(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if cursor != nil {
+		sortBy, order = cursor.SortBy, cursor.Order
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if _, err := db.GetUserByID(c.Request.Context(), id); err != nil {
+		helpers.RespondStorageError(c, err, 404, "User not found")
+		return
+	}
+	if withCursor {
+		respondTaskPage(c, db, id, cursor, limit, sortBy, order, filter)
+		return
+	}
+	tasks, err := db.GetTasksWithParams(c.Request.Context(), id, page, limit, sortBy, order, filter)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
+	}
+	respondTaskList(c, db, id, tasks, page, limit, filter)
+}
+
+// @Summary		Get the audit log
+// @Description	Get the requests made to the admin API, newest first
+// @Tags			Admin
+// @Produce		application/json
+// @Security		JWT
+// @Param			page	query		int	false	"Page number"
+// @Param			limit	query		int	false	"Items per page, at most 100"
+// @Success		200		{array}		utils.AuditEntry
+// @Failure		400		{object}	object{error=string}	"Error Message"
+// @Failure		403		{object}	object{error=string}	"Forbidden: Insufficient role"
+// @Failure		500		{object}	object{error=string}	"Internal Server Error"
+// @Failure		503		{object}	object{error=string}	"Request cancelled"
+// @Failure		504		{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/admin/audit [get]
+func GetAuditLog(c *gin.Context) {
+	page, limit, err := extractPageParams(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	entries, err := db.GetAuditLog(c.Request.Context(), page, limit)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
+	}
+	c.JSON(200, entries)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAdmin(t *testing.T) {
+	store := newTestStore()
+	require.NoError(t, store.SetUserRole(context.Background(), 1, utils.RoleAdmin))
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password2"), bcrypt.MinCost)
+	require.NoError(t, err)
+	_, err = store.CreateUser(context.Background(), utils.User{Username: "user2", Password: string(hashedPassword)})
+	require.NoError(t, err)
+	require.NoError(t, store.CreateAuditEntry(context.Background(), utils.AuditEntry{ActorID: 1, Action: "GET /api/v1/admin/users", StatusCode: 200}))
+
+	router := newUserRouter(store, 1)
+	router.POST("/auth/login", Login)
+	router.GET("/admin/users", GetUsers)
+	router.PUT("/admin/users/:id/role", SetUserRole)
+	router.PUT("/admin/users/:id/disable", DisableUser)
+	router.PUT("/admin/users/:id/enable", EnableUser)
+	router.GET("/admin/users/:id/tasks", GetUserTasks)
+	router.GET("/admin/audit", GetAuditLog)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve("GET", "/admin/users", "")
+	assert.Equal(t, 200, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "password")
+	var users []utils.User
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &users))
+	require.Len(t, users, 2)
+	assert.Equal(t, utils.RoleAdmin, users[0].Role)
+	assert.Equal(t, utils.RoleUser, users[1].Role)
+	assert.Equal(t, 400, serve("GET", "/admin/users?limit=0", "").Code)
+	assert.Equal(t, 400, serve("GET", "/admin/users?limit=101", "").Code)
+
+	// Roles
+	assert.Equal(t, 409, serve("PUT", "/admin/users/1/role", `{"role":"user"}`).Code)
+	assert.Equal(t, 400, serve("PUT", "/admin/users/2/role", `{"role":"superuser"}`).Code)
+	assert.Equal(t, 400, serve("PUT", "/admin/users/two/role", `{"role":"admin"}`).Code)
+	assert.Equal(t, 404, serve("PUT", "/admin/users/99/role", `{"role":"admin"}`).Code)
+	assert.Equal(t, 200, serve("PUT", "/admin/users/2/role", `{"role":"admin"}`).Code)
+	user, err := store.GetUserByID(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, utils.RoleAdmin, user.Role)
+
+	// Disabled users cannot log in until they are enabled again
+	assert.Equal(t, 409, serve("PUT", "/admin/users/1/disable", "").Code)
+	assert.Equal(t, 404, serve("PUT", "/admin/users/99/disable", "").Code)
+	assert.Equal(t, 200, serve("PUT", "/admin/users/2/disable", "").Code)
+	recorder = serve("POST", "/auth/login", `{"username":"user2","password":"password2"}`)
+	assert.Equal(t, 403, recorder.Code)
+	assert.JSONEq(t, `{"error":"Account disabled"}`, recorder.Body.String())
+	assert.Equal(t, 200, serve("PUT", "/admin/users/2/enable", "").Code)
+	assert.Equal(t, 200, serve("POST", "/auth/login", `{"username":"user2","password":"password2"}`).Code)
+
+	// Any user's tasks
+	recorder = serve("GET", "/admin/users/1/tasks?status=todo", "")
+	assert.Equal(t, 200, recorder.Code)
+	var tasks []utils.Task
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &tasks))
+	require.Len(t, tasks, 1)
+	assert.Equal(t, "title1", tasks[0].Title)
+	recorder = serve("GET", "/admin/users/2/tasks", "")
+	assert.Equal(t, 200, recorder.Code)
+	assert.JSONEq(t, `[]`, recorder.Body.String())
+	assert.Equal(t, 404, serve("GET", "/admin/users/99/tasks", "").Code)
+	assert.Equal(t, 400, serve("GET", "/admin/users/1/tasks?order=sideways", "").Code)
+	recorder = serve("GET", "/admin/users/1/tasks?cursor=", "")
+	assert.Equal(t, 200, recorder.Code)
+	var page TaskPage
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	assert.Empty(t, page.NextCursor)
+
+	recorder = serve("GET", "/admin/audit", "")
+	assert.Equal(t, 200, recorder.Code)
+	var entries []utils.AuditEntry
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "GET /api/v1/admin/users", entries[0].Action)
+	assert.Equal(t, 400, serve("GET", "/admin/audit?limit=1000", "").Code)
+}
@@ -1,6 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
 	"github.com/Parjun2000/task-manager/models"
 	"github.com/Parjun2000/task-manager/utils"
 
@@ -16,6 +21,15 @@ type Credentials struct {
 	Password string `json:"password"`
 }
 
+// RefreshDetails
+type RefreshDetails struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// dummyPasswordHash is checked against when the user does not exist, so that
+// logging in as an unknown user takes as long as with a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
 // @Summary		Register a new user
 // @Description	Register a new user with username and password
 // @Tags			Register & Login
@@ -23,7 +37,10 @@ type Credentials struct {
 // @Param			Credentials	body		Credentials				true	"Username & Password"
 // @Success		200			{object}	object{message=string}	"User registered successfully"
 // @Failure		400			{object}	object{error=string}	"Invalid JSON"
+// @Failure		409			{object}	object{error=string}	"Username already exists"
 // @Failure		500			{object}	object{error=string}	"Internal server error"
+// @Failure		503			{object}	object{error=string}	"Request cancelled"
+// @Failure		504			{object}	object{error=string}	"Request timed out"
 // @Router			/api/v1/auth/register [post]
 func Register(c *gin.Context) {
 	var user models.User
@@ -42,24 +59,32 @@ func Register(c *gin.Context) {
 	s, _ := c.Get("db")
 	db := s.(utils.Storage)
 
-	_, err = db.CreateUser(utils.User{Username: user.Username, Password: user.Password})
+	_, err = db.CreateUser(c.Request.Context(), utils.User{Username: user.Username, Password: user.Password})
+	if errors.Is(err, utils.ErrUsernameExists) {
+		c.JSON(409, gin.H{"error": "Username already exists"})
+		return
+	}
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Internal server error"})
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
 		return
 	}
 	c.JSON(200, gin.H{"message": "User registered successfully"})
 }
 
 // @Summary		Login user
-// @Description	Login user with username and password
+// @Description	Login user with username and password. Returns a short-lived access token and a refresh token to get new ones. Users with two-factor authentication get an mfa_token instead, to exchange with a code at /api/v1/auth/login/mfa. Repeated failures for a username or from an IP lock further logins for a while
 // @Tags			Register & Login
 // @Accept			application/json
-// @Param			Credentials	body		Credentials				true	"Username & Password"
-// @Success		200			{object}	object{token=string}	"User logged in successfully"
-// @Failure		400			{object}	object{error=string}	"Invalid JSON"
-// @Failure		401			{object}	object{error=string}	"Invalid credentials"
-// @Failure		404			{object}	object{error=string}	"User not found"
-// @Failure		500			{object}	object{error=string}	"Internal server error"
+// @Param			Credentials	body		Credentials													true	"Username & Password"
+// @Success		200			{object}	object{token=string,refresh_token=string,expires_in=int}	"User logged in successfully"
+// @Success		200			{object}	object{mfa_required=bool,mfa_token=string,expires_in=int}	"Two-factor authentication required"
+// @Failure		400			{object}	object{error=string}										"Invalid JSON"
+// @Failure		401			{object}	object{error=string}										"Invalid credentials"
+// @Failure		403			{object}	object{error=string}										"Account disabled"
+// @Failure		429			{object}	object{error=string}										"Too many failed login attempts"
+// @Failure		500			{object}	object{error=string}										"Internal server error"
+// @Failure		503			{object}	object{error=string}										"Request cancelled"
+// @Failure		504			{object}	object{error=string}										"Request timed out"
 // @Router			/api/v1/auth/login [post]
 func Login(c *gin.Context) {
 	var user models.User
@@ -70,23 +95,274 @@ func Login(c *gin.Context) {
 
 	s, _ := c.Get("db")
 	db := s.(utils.Storage)
-	storedUser, err := db.GetUserByUsername(user.Username) // Function to get user details from PostgreSQL
+	if locked, err := loginLockedFor(c, db, user.Username); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	} else if locked > 0 {
+		respondLoginLocked(c, locked)
+		return
+	}
+
+	storedUser, err := db.GetUserByUsername(c.Request.Context(), user.Username) // Function to get user details from PostgreSQL
+	if err != nil && !errors.Is(err, utils.ErrUserNotFound) {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	// Unknown users and wrong passwords get the same response after the same
+	// work, and so do users of the identity provider, who have no password
+	passwordHash := dummyPasswordHash
+	if err == nil && storedUser.Password != "" {
+		passwordHash = []byte(storedUser.Password)
+	}
+	if bcrypt.CompareHashAndPassword(passwordHash, []byte(user.Password)) != nil || err != nil || storedUser.Password == "" {
+		failLogin(c, db, user.Username, "Invalid credentials")
+		return
+	}
+	if storedUser.DisabledAt != nil {
+		c.JSON(403, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	startSession(c, db, storedUser)
+}
+
+// startSession logs in an authenticated user. Users with two-factor
+// authentication get a challenge instead of tokens.
+func startSession(c *gin.Context, db utils.Storage, user utils.User) {
+	credential, err := db.GetTOTP(c.Request.Context(), user.ID)
+	if err != nil && !errors.Is(err, utils.ErrTOTPNotFound) {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if err == nil && credential.ConfirmedAt != nil {
+		mfaToken, mfaHash, err := helpers.GenerateOpaqueToken()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+		err = db.CreateMFAChallenge(c.Request.Context(), utils.MFAChallenge{
+			UserID:    user.ID,
+			TokenHash: mfaHash,
+			ExpiresAt: time.Now().Add(helpers.MFAChallengeTTL),
+		})
+		if err != nil {
+			helpers.RespondStorageError(c, err, 500, "Internal server error")
+			return
+		}
+		c.JSON(200, gin.H{"mfa_required": true, "mfa_token": mfaToken, "expires_in": int(helpers.MFAChallengeTTL.Seconds())})
+		return
+	}
+
+	issueTokens(c, db, user)
+}
+
+// loginLockedFor returns how long logins for the username from the client's
+// IP stay locked after earlier failures.
+func loginLockedFor(c *gin.Context, db utils.Storage, username string) (time.Duration, error) {
+	userKey, ipKey := loginFailureKeys(c, username)
+	return throttledFor(c, db, map[string]int{
+		userKey: helpers.LoginMaxFailures,
+		ipKey:   helpers.LoginIPMaxFailures,
+	})
+}
+
+// throttledFor returns how much longer requests are locked out by the
+// failures counted under the keys, each allowed up to its number of failures.
+func throttledFor(c *gin.Context, db utils.Storage, maxFailures map[string]int) (time.Duration, error) {
+	keys := make([]string, 0, len(maxFailures))
+	for key := range maxFailures {
+		keys = append(keys, key)
+	}
+	failures, err := db.GetLoginFailures(c.Request.Context(), keys, time.Now().Add(-helpers.LoginFailureWindow))
+	if err != nil {
+		return 0, err
+	}
+	var locked time.Duration
+	for _, failure := range failures {
+		lockedUntil := failure.LastFailureAt.Add(helpers.LoginLockoutAfter(failure.Failures, maxFailures[failure.Key]))
+		if remaining := time.Until(lockedUntil); remaining > locked {
+			locked = remaining
+		}
+	}
+	return locked, nil
+}
+
+// failLogin counts a failed login for the username and the client's IP and
+// responds with 401 and the given message.
+func failLogin(c *gin.Context, db utils.Storage, username, message string) {
+	since := time.Now().Add(-helpers.LoginFailureWindow)
+	userKey, ipKey := loginFailureKeys(c, username)
+	for _, key := range []string{userKey, ipKey} {
+		if _, err := db.RecordLoginFailure(c.Request.Context(), key, since); err != nil {
+			helpers.RespondStorageError(c, err, 500, "Internal server error")
+			return
+		}
+	}
+	c.JSON(401, gin.H{"error": message})
+}
+
+// loginFailureKeys returns the keys failed logins are counted under.
+func loginFailureKeys(c *gin.Context, username string) (userKey, ipKey string) {
+	return "user:" + username, "ip:" + c.ClientIP()
+}
+
+// respondLoginLocked rejects a login while it is locked, telling the client
+// when to try again.
+func respondLoginLocked(c *gin.Context, locked time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.Seconds()))))
+	c.JSON(429, gin.H{"error": "Too many failed login attempts"})
+}
+
+// issueTokens starts a new session for the user and responds with its
+// access and refresh tokens. Failed logins for the username are forgotten.
+func issueTokens(c *gin.Context, db utils.Storage, user utils.User) {
+	userKey, _ := loginFailureKeys(c, user.Username)
+	if err := db.ClearLoginFailures(c.Request.Context(), userKey); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+
+	familyID, err := helpers.NewTokenID()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	jti, refreshToken, refreshHash, err := newTokenIDs()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	err = db.CreateRefreshToken(c.Request.Context(), utils.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: refreshHash,
+		AccessJTI: jti,
+		ExpiresAt: time.Now().Add(helpers.RefreshTokenTTL),
+	})
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+
+	token, err := helpers.GenerateJWT(user.Username, user.Role, jti, time.Now())
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(200, tokenResponse(token, refreshToken))
+}
+
+// newTokenIDs returns the jti of a new access token together with a new
+// refresh token and its hash.
+func newTokenIDs() (jti, refreshToken, refreshHash string, err error) {
+	if jti, err = helpers.NewTokenID(); err != nil {
+		return "", "", "", err
+	}
+	if refreshToken, refreshHash, err = helpers.GenerateOpaqueToken(); err != nil {
+		return "", "", "", err
+	}
+	return jti, refreshToken, refreshHash, nil
+}
+
+// tokenResponse is the body returned whenever new tokens are issued.
+func tokenResponse(token, refreshToken string) gin.H {
+	return gin.H{"token": token, "refresh_token": refreshToken, "expires_in": int(helpers.AccessTokenTTL.Seconds())}
+}
+
+// @Summary		Refresh tokens
+// @Description	Exchange a refresh token for a new access token and refresh token. Each refresh token can be used once; using it again revokes every token issued since login
+// @Tags			Register & Login
+// @Accept			application/json
+// @Param			RefreshDetails	body		RefreshDetails											true	"Refresh token"
+// @Success		200				{object}	object{token=string,refresh_token=string,expires_in=int}	"Tokens refreshed successfully"
+// @Failure		400				{object}	object{error=string}									"Invalid JSON"
+// @Failure		401				{object}	object{error=string}									"Invalid refresh token"
+// @Failure		401				{object}	object{error=string}									"Refresh token reuse detected"
+// @Failure		403				{object}	object{error=string}									"Account disabled"
+// @Failure		500				{object}	object{error=string}									"Internal server error"
+// @Failure		503				{object}	object{error=string}									"Request cancelled"
+// @Failure		504				{object}	object{error=string}									"Request timed out"
+// @Router			/api/v1/auth/refresh [post]
+func Refresh(c *gin.Context) {
+	var details RefreshDetails
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	jti, refreshToken, refreshHash, err := newTokenIDs()
 	if err != nil {
-		c.JSON(404, gin.H{"error": "User not found"})
+		c.JSON(500, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte(user.Password))
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	rotated, err := db.RotateRefreshToken(c.Request.Context(), helpers.HashToken(details.RefreshToken), utils.RefreshToken{
+		TokenHash: refreshHash,
+		AccessJTI: jti,
+		ExpiresAt: time.Now().Add(helpers.RefreshTokenTTL),
+	})
 	if err != nil {
-		c.JSON(401, gin.H{"error": "Invalid credentials"})
+		switch {
+		case errors.Is(err, utils.ErrRefreshTokenInvalid):
+			c.JSON(401, gin.H{"error": "Invalid refresh token"})
+		case errors.Is(err, utils.ErrRefreshTokenReused):
+			c.JSON(401, gin.H{"error": "Refresh token reuse detected"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Internal server error")
+		}
 		return
 	}
 
-	token, err := helpers.GenerateJWT(user.Username)
+	user, err := db.GetUserByID(c.Request.Context(), rotated.UserID)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if user.DisabledAt != nil {
+		c.JSON(403, gin.H{"error": "Account disabled"})
+		return
+	}
+	// A refresh is not a login, so the token carries no auth_time
+	token, err := helpers.GenerateJWT(user.Username, user.Role, jti, time.Time{})
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	c.JSON(200, gin.H{"token": token})
+	c.JSON(200, tokenResponse(token, refreshToken))
+}
+
+// @Summary		Logout user
+// @Description	Revoke a refresh token together with every access and refresh token issued since the login it came from
+// @Tags			Register & Login
+// @Accept			application/json
+// @Param			RefreshDetails	body		RefreshDetails			true	"Refresh token"
+// @Success		200				{object}	object{message=string}	"Logged out successfully"
+// @Failure		400				{object}	object{error=string}	"Invalid JSON"
+// @Failure		401				{object}	object{error=string}	"Invalid refresh token"
+// @Failure		500				{object}	object{error=string}	"Internal server error"
+// @Failure		503				{object}	object{error=string}	"Request cancelled"
+// @Failure		504				{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/auth/logout [post]
+func Logout(c *gin.Context) {
+	var details RefreshDetails
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.RevokeRefreshTokenFamily(c.Request.Context(), helpers.HashToken(details.RefreshToken)); err != nil {
+		if errors.Is(err, utils.ErrRefreshTokenInvalid) {
+			c.JSON(401, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Logged out successfully"})
 }
@@ -2,17 +2,25 @@ package handlers
 
 import (
 	"bytes"
-
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRegister(t *testing.T) {
-	// Create a POST route
-	testRouter.POST("/auth/register", Register)
+	// Create a POST route, on a store kept across requests so a username
+	// registered once is taken afterwards
+	router := newUserRouter(utils.NewMemoryDB(), 0)
+	router.POST("/auth/register", Register)
 
 	// Perform a POST request to the endpoint without body
 	req, err := http.NewRequest("POST", "/auth/register", nil)
@@ -20,7 +28,7 @@ func TestRegister(t *testing.T) {
 		t.Fatal(err)
 	}
 	recorder := httptest.NewRecorder()
-	testRouter.ServeHTTP(recorder, req)
+	router.ServeHTTP(recorder, req)
 
 	// Assert response status code
 	assert.Equal(t, 400, recorder.Code)
@@ -33,10 +41,24 @@ func TestRegister(t *testing.T) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	recorder = httptest.NewRecorder()
-	testRouter.ServeHTTP(recorder, req)
+	router.ServeHTTP(recorder, req)
 
 	// Assert response status code
 	assert.Equal(t, 200, recorder.Code)
+
+	// Perform a POST request with a username that is already taken
+	body = []byte(`{"password":"pass1","username":"user1"}`)
+	req, err = http.NewRequest("POST", "/auth/register", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	// Assert response status code
+	assert.Equal(t, 409, recorder.Code)
+	assert.JSONEq(t, `{"error":"Username already exists"}`, recorder.Body.String())
 }
 
 func TestLogin(t *testing.T) {
@@ -67,3 +89,105 @@ func TestLogin(t *testing.T) {
 	// Assert response status code
 	assert.Equal(t, 401, recorder.Code)
 }
+
+func TestLoginLockout(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.POST("/auth/login", Login)
+
+	serve := func(ip, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/auth/login", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = ip + ":1234"
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Unknown users and wrong passwords look the same
+	unknown := serve("192.0.2.1", `{"username":"nobody","password":"password1"}`)
+	wrong := serve("192.0.2.1", `{"username":"user1","password":"wrong"}`)
+	assert.Equal(t, 401, unknown.Code)
+	assert.Equal(t, unknown.Code, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+
+	for i := 2; i <= helpers.LoginMaxFailures; i++ {
+		assert.Equal(t, 401, serve("192.0.2.1", `{"username":"user1","password":"wrong"}`).Code)
+	}
+
+	// The username is locked from every IP, even with the right password
+	recorder := serve("198.51.100.1", `{"username":"user1","password":"password1"}`)
+	assert.Equal(t, 429, recorder.Code)
+	assert.Equal(t, "30", recorder.Header().Get("Retry-After"))
+
+	// The lockout doubles with every failure once it has passed
+	failures, err := store.GetLoginFailures(context.Background(), []string{"user:user1"}, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, failures, 1)
+	assert.Equal(t, helpers.LoginMaxFailures, failures[0].Failures)
+	assert.Equal(t, 60*time.Second, helpers.LoginLockoutAfter(failures[0].Failures+1, helpers.LoginMaxFailures))
+	assert.Equal(t, helpers.LoginLockoutMax, helpers.LoginLockoutAfter(100, helpers.LoginMaxFailures))
+
+	// A successful login clears the username but not the IP, which has
+	// failed once more for the unknown user
+	require.NoError(t, store.ClearLoginFailures(context.Background(), "user:user1"))
+	assert.Equal(t, 200, serve("192.0.2.1", `{"username":"user1","password":"password1"}`).Code)
+	for i := helpers.LoginMaxFailures + 1; i < helpers.LoginIPMaxFailures; i++ {
+		assert.Equal(t, 401, serve("192.0.2.1", fmt.Sprintf(`{"username":"user%d","password":"wrong"}`, i)).Code)
+	}
+	assert.Equal(t, 429, serve("192.0.2.1", `{"username":"user1","password":"password1"}`).Code)
+	assert.Equal(t, 200, serve("198.51.100.1", `{"username":"user1","password":"password1"}`).Code)
+}
+
+func TestRefreshTokens(t *testing.T) {
+	router := newUserRouter(newTestStore(), 1)
+	router.POST("/auth/login", Login)
+	router.POST("/auth/refresh", Refresh)
+	router.POST("/auth/logout", Logout)
+
+	serve := func(url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	issued := func(recorder *httptest.ResponseRecorder) tokens {
+		assert.Equal(t, 200, recorder.Code)
+		var body tokens
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		assert.NotEmpty(t, body.Token)
+		assert.NotEmpty(t, body.RefreshToken)
+		return body
+	}
+	refreshBody := func(token string) string {
+		return `{"refresh_token":"` + token + `"}`
+	}
+
+	login := issued(serve("/auth/login", `{"username":"user1","password":"password1"}`))
+	assert.Equal(t, 15*60, login.ExpiresIn)
+	refreshed := issued(serve("/auth/refresh", refreshBody(login.RefreshToken)))
+	assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+
+	// Reusing the first refresh token revokes the one it was rotated into
+	recorder := serve("/auth/refresh", refreshBody(login.RefreshToken))
+	assert.Equal(t, 401, recorder.Code)
+	assert.JSONEq(t, `{"error":"Refresh token reuse detected"}`, recorder.Body.String())
+	assert.Equal(t, 401, serve("/auth/refresh", refreshBody(refreshed.RefreshToken)).Code)
+
+	// After logout the refresh token no longer works
+	login = issued(serve("/auth/login", `{"username":"user1","password":"password1"}`))
+	assert.Equal(t, 200, serve("/auth/logout", refreshBody(login.RefreshToken)).Code)
+	assert.Equal(t, 401, serve("/auth/refresh", refreshBody(login.RefreshToken)).Code)
+	assert.Equal(t, 401, serve("/auth/logout", refreshBody("unknown")).Code)
+	assert.Equal(t, 400, serve("/auth/refresh", "").Code)
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/jsonpatch"
+	"github.com/Parjun2000/task-manager/models"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBulkOperations bounds the operations of one bulk request.
+const maxBulkOperations = 100
+
+// BulkOperation is one operation of a bulk request. Creates take the new
+// task, updates a merge patch of the fields to change and deletes only the
+// task ID. Updates and deletes with a version fail unless the task is still
+// at that version.
+type BulkOperation struct {
+	Op      string          `json:"op" enums:"create,update,delete"`
+	ID      int             `json:"id,omitempty"`
+	Version int             `json:"version,omitempty"`
+	Task    json.RawMessage `json:"task,omitempty" swaggertype:"object"`
+}
+
+// BulkRequest lists the operations to run, in order.
+type BulkRequest struct {
+	Operations []BulkOperation `json:"operations"`
+}
+
+// BulkResult is the outcome of one operation. When any operation fails, it
+// is failed with the reason and every other one not_applied.
+type BulkResult struct {
+	Op        string `json:"op"`
+	ID        int    `json:"id,omitempty"`
+	Status    string `json:"status" enums:"created,updated,deleted,failed,not_applied"`
+	Error     string `json:"error,omitempty"`
+	BlockedBy []int  `json:"blocked_by,omitempty"`
+}
+
+// BulkResponse lists the outcome of each operation, in the order of the
+// request.
+type BulkResponse struct {
+	DryRun  bool         `json:"dry_run"`
+	Results []BulkResult `json:"results"`
+}
+
+// bulkStatuses are the statuses of operations that were applied.
+var bulkStatuses = map[string]string{
+	utils.TaskCreate: "created",
+	utils.TaskUpdate: "updated",
+	utils.TaskDelete: "deleted",
+}
+
+// bulkOperation checks an operation and returns the task operation to run.
+// current is the task an update applies to.
+func bulkOperation(op BulkOperation, current utils.Task) (utils.TaskOperation, error) {
+	switch op.Op {
+	case utils.TaskCreate:
+		var task models.Task
+		if err := json.Unmarshal(op.Task, &task); err != nil {
+			return utils.TaskOperation{}, errors.New("Invalid JSON")
+		}
+		if err := task.Validate(); err != nil {
+			return utils.TaskOperation{}, err
+		}
+		newTask := utils.Task{
+			Title:       task.Title,
+			Description: task.Description,
+			Status:      task.Status,
+			DueAt:       task.DueAt,
+			DueTimezone: task.DueTimezone,
+			Priority:    task.Priority,
+			Tags:        task.Tags,
+			ParentID:    task.ParentID,
+			Recurrence:  task.Recurrence,
+		}
+		return utils.TaskOperation{Kind: utils.TaskCreate, Task: newTask}, nil
+	case utils.TaskUpdate:
+		if op.Version != 0 && op.Version != current.Version {
+			return utils.TaskOperation{}, utils.ErrVersionMismatch
+		}
+		details, fields, err := patchTaskDetails(current, jsonpatch.MergePatch, op.Task)
+		if err != nil {
+			return utils.TaskOperation{}, errors.New("Invalid patch: " + err.Error())
+		}
+		updatedTask := details.model()
+		if err := updatedTask.Validate(); err != nil {
+			return utils.TaskOperation{}, err
+		}
+		// The patch was made against this version of the task
+		task := details.task()
+		task.Version = current.Version
+		return utils.TaskOperation{Kind: utils.TaskUpdate, ID: op.ID, Task: task, Fields: fields}, nil
+	default:
+		return utils.TaskOperation{Kind: utils.TaskDelete, ID: op.ID, Version: op.Version, Children: SubtaskDeletePolicy}, nil
+	}
+}
+
+// bulkFailure fills in why an operation failed, or returns false for errors
+// that are not about the operation.
+func bulkFailure(result *BulkResult, err error) bool {
+	var blocked *utils.BlockedError
+	result.Status = "failed"
+	switch {
+	case errors.As(err, &blocked):
+		result.Error = "Task is blocked"
+		result.BlockedBy = blocked.Blockers
+	case errors.Is(err, utils.ErrTaskNotFound) && result.Op == utils.TaskCreate:
+		result.Error = "Parent task not found"
+	case errors.Is(err, utils.ErrTaskNotFound):
+		result.Error = "Task or parent task not found"
+	case errors.Is(err, utils.ErrTaskCycle):
+		result.Error = "Task cannot be moved under itself or its subtasks"
+	case errors.Is(err, utils.ErrTaskHasChildren):
+		result.Error = "Task has subtasks"
+	case errors.Is(err, utils.ErrVersionMismatch):
+		result.Error = "Task was changed by another request"
+	default:
+		return false
+	}
+	return true
+}
+
+// @Summary		Run bulk task operations
+// @Description	Create, update and delete many tasks at once. Operations run in order in one transaction: either all of them are applied, or none is and the response tells which operation failed and why. Creates take the task like POST /api/v1/tasks, updates a merge patch of the fields to change like PATCH /api/v1/tasks/{id}, and deletes handle subtasks like DELETE /api/v1/tasks/{id}. A task can only be updated or deleted by one operation of a request. With dry_run the operations are checked, against the database as well, without applying them
+// @Tags			Tasks
+// @Accept			application/json
+// @Produce		application/json
+// @Security		JWT
+// @Param			dry_run		query		bool										false	"Check the operations without applying them"
+// @Param			operations	body		BulkRequest									true	"Operations to run"
+// @Success		200			{object}	BulkResponse
+// @Failure		400			{object}	object{error=string}						"Invalid JSON"
+// @Failure		400			{object}	object{error=string}						"Too many operations"
+// @Failure		400			{object}	object{error=string,results=[]BulkResult}	"Invalid operations"
+// @Failure		409			{object}	object{error=string,results=[]BulkResult}	"No operations were applied"
+// @Failure		500			{object}	object{error=string}						"Internal Server Error"
+// @Failure		500			{object}	object{error=string}						"Failed to apply operations"
+// @Failure		503			{object}	object{error=string}						"Request cancelled"
+// @Failure		504			{object}	object{error=string}						"Request timed out"
+// @Router			/api/v1/tasks/bulk [post]
+func BulkTasks(c *gin.Context) {
+	dryRun := false
+	if value := c.Query("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid dry_run flag"})
+			return
+		}
+	}
+	var request BulkRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if len(request.Operations) > maxBulkOperations {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Too many operations, at most %d can be run at once", maxBulkOperations)})
+		return
+	}
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+
+	// Every operation is checked before any is run
+	results := make([]BulkResult, len(request.Operations))
+	operations := make([]utils.TaskOperation, len(request.Operations))
+	seen := make(map[int]bool)
+	invalid := false
+	for i, op := range request.Operations {
+		results[i] = BulkResult{Op: op.Op, ID: op.ID, Status: "not_applied"}
+		fail := func(message string) {
+			results[i].Status = "failed"
+			results[i].Error = message
+			invalid = true
+		}
+		var current utils.Task
+		switch op.Op {
+		case utils.TaskCreate:
+		case utils.TaskUpdate, utils.TaskDelete:
+			if op.ID < 1 {
+				fail("Invalid Task Id")
+				continue
+			}
+			if seen[op.ID] {
+				fail("Task is changed by another operation")
+				continue
+			}
+			seen[op.ID] = true
+			if op.Op == utils.TaskDelete {
+				break
+			}
+			task, err := db.GetTaskByID(c.Request.Context(), userId.(int), op.ID)
+			if errors.Is(err, utils.ErrTaskNotFound) {
+				fail("Task not found")
+				continue
+			}
+			if err != nil {
+				helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+				return
+			}
+			current = task
+		default:
+			fail("Unknown operation")
+			continue
+		}
+		operation, err := bulkOperation(op, current)
+		if err != nil {
+			if !bulkFailure(&results[i], err) {
+				fail(err.Error())
+			}
+			invalid = true
+			continue
+		}
+		if operation.Kind != utils.TaskDelete {
+			if operation.Fields, err = defaultDueTimezone(c.Request.Context(), db, userId.(int), &operation.Task, operation.Fields); err != nil {
+				helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+				return
+			}
+		}
+		operations[i] = operation
+	}
+	if invalid {
+		c.JSON(400, gin.H{"error": "Invalid operations", "results": results})
+		return
+	}
+
+	ids, err := db.RunTaskBatch(c.Request.Context(), userId.(int), operations, dryRun)
+	if err != nil {
+		var batchErr *utils.BatchError
+		if errors.As(err, &batchErr) && bulkFailure(&results[batchErr.Index], batchErr.Err) {
+			c.JSON(409, gin.H{"error": "No operations were applied", "results": results})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Failed to apply operations")
+		return
+	}
+	for i := range results {
+		results[i].ID = ids[i]
+		results[i].Status = bulkStatuses[results[i].Op]
+	}
+	c.JSON(200, BulkResponse{DryRun: dryRun, Results: results})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkTasks(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.POST("/tasks/bulk", BulkTasks)
+
+	serve := func(url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	results := func(recorder *httptest.ResponseRecorder) []BulkResult {
+		var response BulkResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return response.Results
+	}
+	exists := func(id int) bool {
+		_, err := store.GetTaskByID(context.Background(), 1, id)
+		return err == nil
+	}
+
+	operations := `{"operations":[
+		{"op":"create","task":{"title":"title2","description":"description2","status":"todo"}},
+		{"op":"update","id":1,"task":{"status":"in progress"}}
+	]}`
+
+	// A dry run checks the operations without applying them
+	recorder := serve("/tasks/bulk?dry_run=true", operations)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, []BulkResult{{Op: "create", Status: "created"}, {Op: "update", ID: 1, Status: "updated"}}, results(recorder))
+	assert.False(t, exists(2))
+	task, _ := store.GetTaskByID(context.Background(), 1, 1)
+	assert.Equal(t, "todo", task.Status)
+
+	recorder = serve("/tasks/bulk", operations)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, []BulkResult{{Op: "create", ID: 2, Status: "created"}, {Op: "update", ID: 1, Status: "updated"}}, results(recorder))
+	task, _ = store.GetTaskByID(context.Background(), 1, 1)
+	assert.Equal(t, "in progress", task.Status)
+	assert.Equal(t, "description1", task.Description)
+
+	// Invalid operations are reported before anything runs
+	recorder = serve("/tasks/bulk", `{"operations":[
+		{"op":"delete","id":2},
+		{"op":"create","task":{"title":""}},
+		{"op":"update","id":7,"task":{"title":"renamed"}},
+		{"op":"rename","id":1}
+	]}`)
+	assert.Equal(t, 400, recorder.Code)
+	got := results(recorder)
+	assert.Equal(t, []string{"not_applied", "failed", "failed", "failed"},
+		[]string{got[0].Status, got[1].Status, got[2].Status, got[3].Status})
+	assert.True(t, exists(2))
+
+	// A task can be changed by one operation only
+	recorder = serve("/tasks/bulk", `{"operations":[{"op":"delete","id":2},{"op":"update","id":2,"task":{"title":"renamed"}}]}`)
+	assert.Equal(t, 400, recorder.Code)
+
+	// When an operation fails, none is applied
+	recorder = serve("/tasks/bulk", `{"operations":[{"op":"delete","id":2},{"op":"delete","id":9}]}`)
+	assert.Equal(t, 409, recorder.Code)
+	got = results(recorder)
+	assert.Equal(t, "not_applied", got[0].Status)
+	assert.Equal(t, "failed", got[1].Status)
+	assert.True(t, exists(2))
+
+	// Versions are checked
+	assert.Equal(t, 400, serve("/tasks/bulk", `{"operations":[{"op":"update","id":1,"version":1,"task":{"title":"renamed"}}]}`).Code)
+	assert.Equal(t, 409, serve("/tasks/bulk", `{"operations":[{"op":"delete","id":2,"version":5}]}`).Code)
+	recorder = serve("/tasks/bulk", `{"operations":[{"op":"delete","id":2,"version":1}]}`)
+	assert.Equal(t, 200, recorder.Code)
+	assert.False(t, exists(2))
+
+	// Blocked tasks cannot be completed
+	blockerID, err := store.CreateTask(context.Background(), utils.Task{Title: "blocker", Status: "todo", UserID: 1})
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddTaskBlocker(context.Background(), 1, 1, blockerID))
+	recorder = serve("/tasks/bulk", `{"operations":[{"op":"update","id":1,"task":{"status":"done"}}]}`)
+	assert.Equal(t, 409, recorder.Code)
+	assert.Equal(t, []int{blockerID}, results(recorder)[0].BlockedBy)
+
+	// Requests are bounded
+	ops := make([]string, maxBulkOperations+1)
+	for i := range ops {
+		ops[i] = fmt.Sprintf(`{"op":"create","task":{"title":"task%d","status":"todo"}}`, i)
+	}
+	assert.Equal(t, 400, serve("/tasks/bulk", `{"operations":[`+strings.Join(ops, ",")+`]}`).Code)
+	assert.Equal(t, 400, serve("/tasks/bulk?dry_run=maybe", operations).Code)
+	assert.Equal(t, 400, serve("/tasks/bulk", `{"operations":`).Code)
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Parjun2000/task-manager/dataexport"
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DataExportTTL is how long a finished data export can be downloaded.
+var DataExportTTL = 24 * time.Hour
+
+// DataExportTimeout limits how long building a data export may take. Exports
+// still pending after that are taken as failed.
+var DataExportTimeout = 10 * time.Minute
+
+// DataExportLinkTTL is how long a download link of a ready export works.
+// Links carry their credential in the URL, where logs and proxies may keep
+// it, so they are short-lived.
+var DataExportLinkTTL = 5 * time.Minute
+
+// DataExportDownloadPath is where exports are downloaded.
+const DataExportDownloadPath = "/api/v1/exports/download"
+
+// DataExportTokenHeader carries the download token of an export.
+const DataExportTokenHeader = "X-Download-Token"
+
+// DataExportResponse is a data export with what it is downloaded with: the
+// download token, only returned when the export is requested, or a
+// short-lived download link once it is ready.
+type DataExportResponse struct {
+	utils.DataExport
+	DownloadToken string `json:"download_token,omitempty"`
+	DownloadURL   string `json:"download_url,omitempty"`
+}
+
+// dataExportLink is what a download link carries, signed like page cursors.
+type dataExportLink struct {
+	TokenHash string `json:"h"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// @Summary		Request a data export
+// @Description	Start building an archive of everything stored about the logged in user: the profile, tasks, tags, personal access tokens, linked identities, two-factor status, login sessions and the admin audit log entries about the user, as JSON and CSV files in a zip. Poll the export until it is ready, then download it with download_token, which is only shown in this response and works until the export expires, in the X-Download-Token header instead of the Authorization header
+// @Tags			Profile
+// @Produce		application/json
+// @Security		JWT
+// @Success		202	{object}	DataExportResponse
+// @Failure		409	{object}	object{error=string}	"Export already in progress"
+// @Failure		500	{object}	object{error=string}	"Internal server error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/me/exports [post]
+func CreateDataExport(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	token, tokenHash, err := helpers.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	export, err := db.CreateDataExport(c.Request.Context(), utils.DataExport{UserID: userId.(int), TokenHash: tokenHash}, time.Now().Add(-DataExportTimeout))
+	if err != nil {
+		if errors.Is(err, utils.ErrDataExportInProgress) {
+			c.JSON(409, gin.H{"error": "Export already in progress"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+
+	// Large accounts take a while, so the archive is built after responding
+	go buildDataExport(db, export)
+
+	c.Header("Location", fmt.Sprint("/api/v1/me/exports/", export.ID))
+	c.JSON(202, DataExportResponse{DataExport: export, DownloadToken: token})
+}
+
+// buildDataExport builds the archive of a pending export and stores it, or
+// marks the export failed.
+func buildDataExport(db utils.Storage, export utils.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), DataExportTimeout)
+	defer cancel()
+
+	archive, err := dataexport.Build(ctx, db, export.UserID)
+	if err == nil {
+		err = db.CompleteDataExport(ctx, export.ID, archive, time.Now().Add(DataExportTTL))
+	}
+	if err != nil {
+		log.Printf("Data export %d failed: %v", export.ID, err)
+		// The build may have run out of time, failing is quick
+		failCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.FailDataExport(failCtx, export.ID); err != nil && !errors.Is(err, utils.ErrDataExportNotFound) {
+			log.Printf("Data export %d could not be marked failed: %v", export.ID, err)
+		}
+	}
+}
+
+// @Summary		Get a data export
+// @Description	Get the status of one of the logged in user's data exports: pending while it is built, then ready or failed. A ready export has a download_url that works for a few minutes without the Authorization header, for browsers
+// @Tags			Profile
+// @Produce		application/json
+// @Security		JWT
+// @Param			id	path		int	true	"Export ID"
+// @Success		200	{object}	DataExportResponse
+// @Failure		400	{object}	object{error=string}	"Invalid export ID"
+// @Failure		404	{object}	object{error=string}	"Export not found"
+// @Failure		500	{object}	object{error=string}	"Internal server error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/me/exports/{id} [get]
+func GetDataExport(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	export, err := db.GetDataExport(c.Request.Context(), userId.(int), id)
+	if err != nil {
+		if errors.Is(err, utils.ErrDataExportNotFound) {
+			c.JSON(404, gin.H{"error": "Export not found"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	// A pending export that ran out of time has lost its builder
+	if export.Status == utils.DataExportPending && time.Since(export.CreatedAt) > DataExportTimeout {
+		export.Status = utils.DataExportFailed
+	}
+	response := DataExportResponse{DataExport: export}
+	if export.Status == utils.DataExportReady {
+		link, err := helpers.SignCursor(dataExportLink{TokenHash: export.TokenHash, ExpiresAt: time.Now().Add(DataExportLinkTTL).Unix()})
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+		response.DownloadURL = DataExportDownloadPath + "?link=" + url.QueryEscape(link)
+	}
+	c.JSON(200, response)
+}
+
+// @Summary		Download a data export
+// @Description	Download a ready data export as a zip archive, with the download token of the export in the X-Download-Token header, or by following the download link of the ready export before it runs out. No Authorization header is needed
+// @Tags			Profile
+// @Produce		application/zip
+// @Param			X-Download-Token	header		string	false	"Download token"
+// @Param			link				query		string	false	"Signed download link"
+// @Success		200					{file}		file					"Export archive"
+// @Failure		404					{object}	object{error=string}	"Export not found or expired"
+// @Failure		500					{object}	object{error=string}	"Internal server error"
+// @Failure		503					{object}	object{error=string}	"Request cancelled"
+// @Failure		504					{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/exports/download [get]
+func DownloadDataExport(c *gin.Context) {
+	var tokenHash string
+	if token := c.GetHeader(DataExportTokenHeader); token != "" {
+		tokenHash = helpers.HashToken(token)
+	} else if value := c.Query("link"); value != "" {
+		var link dataExportLink
+		if err := helpers.ParseCursor(value, &link); err == nil && time.Now().Unix() < link.ExpiresAt {
+			tokenHash = link.TokenHash
+		}
+	}
+	if tokenHash == "" {
+		c.JSON(404, gin.H{"error": "Export not found or expired"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	export, archive, err := db.GetDataExportArchive(c.Request.Context(), tokenHash)
+	if err != nil {
+		if errors.Is(err, utils.ErrDataExportNotFound) {
+			c.JSON(404, gin.H{"error": "Export not found or expired"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="task-manager-export-%d.zip"`, export.ID))
+	c.Data(200, "application/zip", archive)
+}
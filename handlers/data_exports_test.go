@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataExport(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.POST("/me/exports", CreateDataExport)
+	router.GET("/me/exports/:id", GetDataExport)
+	router.GET("/api/v1/exports/download", DownloadDataExport)
+	otherRouter := newUserRouter(store, 2)
+	otherRouter.GET("/me/exports/:id", GetDataExport)
+
+	serve := func(router http.Handler, method, url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	request := func() DataExportResponse {
+		recorder := serve(router, "POST", "/me/exports")
+		require.Equal(t, 202, recorder.Code)
+		var export DataExportResponse
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &export))
+		assert.Equal(t, fmt.Sprint("/api/v1/me/exports/", export.ID), recorder.Header().Get("Location"))
+		return export
+	}
+	get := func(id int) DataExportResponse {
+		recorder := serve(router, "GET", fmt.Sprint("/me/exports/", id))
+		require.Equal(t, 200, recorder.Code)
+		var export DataExportResponse
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &export))
+		return export
+	}
+	status := func(id int) string {
+		return get(id).Status
+	}
+	download := func(token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", DataExportDownloadPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(DataExportTokenHeader, token)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// One export at a time
+	pending, err := store.CreateDataExport(context.Background(), utils.DataExport{UserID: 1, TokenHash: fmt.Sprintf("%064d", 0)}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 409, serve(router, "POST", "/me/exports").Code)
+	require.NoError(t, store.FailDataExport(context.Background(), pending.ID))
+
+	export := request()
+	assert.Equal(t, utils.DataExportPending, export.Status)
+	require.NotEmpty(t, export.DownloadToken)
+	assert.Empty(t, export.DownloadURL)
+	assert.Empty(t, get(export.ID).DownloadURL)
+	require.Eventually(t, func() bool { return status(export.ID) == utils.DataExportReady }, 5*time.Second, 10*time.Millisecond)
+
+	recorder := download(export.DownloadToken)
+	require.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "application/zip", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Header().Get("Content-Disposition"), "attachment")
+	archive, err := zip.NewReader(bytes.NewReader(recorder.Body.Bytes()), int64(recorder.Body.Len()))
+	require.NoError(t, err)
+	names := make([]string, 0, len(archive.File))
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	assert.Contains(t, names, "tasks.csv")
+	assert.Contains(t, names, "account.json")
+
+	assert.Equal(t, 404, download("unknown").Code)
+	assert.Equal(t, 404, serve(router, "GET", "/api/v1/exports/download").Code)
+	// The token does not go in the URL, where logs would keep it
+	assert.Equal(t, 404, serve(router, "GET", "/api/v1/exports/download?token="+export.DownloadToken).Code)
+
+	// Ready exports have a short-lived link for browsers
+	link := get(export.ID).DownloadURL
+	require.NotEmpty(t, link)
+	assert.NotContains(t, link, export.DownloadToken)
+	assert.Equal(t, 200, serve(router, "GET", link).Code)
+	assert.Equal(t, 404, serve(router, "GET", link+"x").Code)
+	defer func(ttl time.Duration) { DataExportLinkTTL = ttl }(DataExportLinkTTL)
+	DataExportLinkTTL = -time.Second
+	assert.Equal(t, 404, serve(router, "GET", get(export.ID).DownloadURL).Code)
+	assert.Equal(t, 404, serve(otherRouter, "GET", fmt.Sprint("/me/exports/", export.ID)).Code)
+	assert.Equal(t, 404, serve(router, "GET", "/me/exports/999").Code)
+	assert.Equal(t, 400, serve(router, "GET", "/me/exports/abc").Code)
+
+	// Links stop working when the export expires
+	defer func(ttl time.Duration) { DataExportTTL = ttl }(DataExportTTL)
+	DataExportTTL = -time.Second
+	expired := request()
+	require.Eventually(t, func() bool { return status(expired.ID) == utils.DataExportReady }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 404, download(expired.DownloadToken).Code)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BlockerDetails
+type BlockerDetails struct {
+	// BlockerID is the task that has to be done first
+	BlockerID int `json:"blocker_id"`
+}
+
+// @Summary		Add a blocker
+// @Description	Mark a task as blocked by another task. A blocked task cannot be marked done until all its blockers are done
+// @Tags			Tasks
+// @Accept			application/json
+// @Security		JWT
+// @Param			id				path		int						true	"Task ID"
+// @Param			BlockerDetails	body		BlockerDetails			true	"Blocking task"
+// @Success		200				{object}	object{message=string}	"Blocker added successfully"
+// @Failure		400				{object}	object{error=string}	"Invalid JSON"
+// @Failure		400				{object}	object{error=string}	"Invalid Task Id"
+// @Failure		404				{object}	object{error=string}	"Task not found"
+// @Failure		409				{object}	object{error=string}	"Task cannot be blocked by itself or a task it blocks"
+// @Failure		500				{object}	object{error=string}	"Failed to add blocker"
+// @Failure		503				{object}	object{error=string}	"Request cancelled"
+// @Failure		504				{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/tasks/{id}/blockers [post]
+func AddTaskBlocker(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Task Id"})
+		return
+	}
+	var details BlockerDetails
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if details.BlockerID < 1 {
+		c.JSON(400, gin.H{"error": "Invalid Task Id"})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.AddTaskBlocker(c.Request.Context(), userId.(int), id, details.BlockerID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrTaskNotFound):
+			c.JSON(404, gin.H{"error": "Task not found"})
+		case errors.Is(err, utils.ErrDependencyCycle):
+			c.JSON(409, gin.H{"error": "Task cannot be blocked by itself or a task it blocks"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Failed to add blocker")
+		}
+		return
+	}
+	c.JSON(200, gin.H{"message": "Blocker added successfully"})
+}
+
+// @Summary		Remove a blocker
+// @Description	Remove a blocker from a task
+// @Tags			Tasks
+// @Security		JWT
+// @Param			id			path		int						true	"Task ID"
+// @Param			blocker_id	path		int						true	"Blocking task ID"
+// @Success		200			{object}	object{message=string}	"Blocker removed successfully"
+// @Failure		400			{object}	object{error=string}	"Invalid Task Id"
+// @Failure		404			{object}	object{error=string}	"Task not found"
+// @Failure		404			{object}	object{error=string}	"Blocker not found"
+// @Failure		500			{object}	object{error=string}	"Failed to remove blocker"
+// @Failure		503			{object}	object{error=string}	"Request cancelled"
+// @Failure		504			{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/tasks/{id}/blockers/{blocker_id} [delete]
+func RemoveTaskBlocker(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Task Id"})
+		return
+	}
+	blockerId, err := strconv.Atoi(c.Param("blocker_id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Task Id"})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.RemoveTaskBlocker(c.Request.Context(), userId.(int), id, blockerId); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrTaskNotFound):
+			c.JSON(404, gin.H{"error": "Task not found"})
+		case errors.Is(err, utils.ErrDependencyNotFound):
+			c.JSON(404, gin.H{"error": "Blocker not found"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Failed to remove blocker")
+		}
+		return
+	}
+	c.JSON(200, gin.H{"message": "Blocker removed successfully"})
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencies(t *testing.T) {
+	// Create task routes for user1, whose task 1 is title1
+	router := newUserRouter(newTestStore(), 1)
+	router.POST("/tasks", CreateTask)
+	router.GET("/tasks/:id", GetTaskByID)
+	router.PUT("/tasks/:id", UpdateTask)
+	router.PUT("/tasks/mark-done", MarkTasksDone)
+	router.POST("/tasks/:id/blockers", AddTaskBlocker)
+	router.DELETE("/tasks/:id/blockers/:blocker_id", RemoveTaskBlocker)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, 200, serve("POST", "/tasks", `{"title":"blocked","description":"d","status":"todo"}`).Code)
+	assert.Equal(t, 200, serve("POST", "/tasks/2/blockers", `{"blocker_id":1}`).Code)
+	assert.Equal(t, 409, serve("POST", "/tasks/1/blockers", `{"blocker_id":2}`).Code)
+	assert.Equal(t, 409, serve("POST", "/tasks/1/blockers", `{"blocker_id":1}`).Code)
+	assert.Equal(t, 404, serve("POST", "/tasks/2/blockers", `{"blocker_id":99}`).Code)
+	assert.Equal(t, 400, serve("POST", "/tasks/2/blockers", `{"blocker_id":0}`).Code)
+
+	recorder := serve("GET", "/tasks/2", "")
+	assert.Equal(t, 200, recorder.Code)
+	var task utils.Task
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &task))
+	assert.Equal(t, []int{1}, task.BlockedBy)
+
+	recorder = serve("PUT", "/tasks/2", `{"title":"blocked","description":"d","status":"done"}`)
+	assert.Equal(t, 409, recorder.Code)
+	assert.JSONEq(t, `{"error":"Task is blocked","blocked_by":[1]}`, recorder.Body.String())
+
+	recorder = serve("PUT", "/tasks/mark-done", `["2"]`)
+	assert.Equal(t, 200, recorder.Code)
+	assert.JSONEq(t, `{"updated":0,"results":[{"id":"2","status":"blocked","blocked_by":[1]}]}`, recorder.Body.String())
+
+	assert.Equal(t, 404, serve("DELETE", "/tasks/2/blockers/99", "").Code)
+	assert.Equal(t, 200, serve("DELETE", "/tasks/2/blockers/1", "").Code)
+	assert.Equal(t, 200, serve("PUT", "/tasks/2", `{"title":"blocked","description":"d","status":"done"}`).Code)
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/Parjun2000/task-manager/signing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jwksMaxAge is how long clients may cache the key set, well below
+// signing.ActivationDelay so they see new keys before tokens use them.
+const jwksMaxAge = 60
+
+// @Summary		Get the token signing keys
+// @Description	Public keys that verify access tokens, as a JSON Web Key Set (RFC 7517). Tokens name their key in the kid header and are signed with RS256 or EdDSA. Keys rotate on schedule, so fetch the set again when a token names an unknown key
+// @Tags			Register & Login
+// @Produce		application/json
+// @Success		200	{object}	signing.JWKS	"JSON Web Key Set"
+// @Router			/.well-known/jwks.json [get]
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	c.JSON(200, signing.Keys.JWKS())
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"os"
 	"testing"
 
+	"github.com/Parjun2000/task-manager/signing"
 	"github.com/Parjun2000/task-manager/utils"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var testRouter = gin.Default()
@@ -19,6 +22,14 @@ func TestMain(m *testing.M) {
 
 func setup() {
 	testRouter.Use(mockDB())
+
+	// Sign test tokens with a fresh key
+	key, err := signing.GenerateKey(signing.EdDSA)
+	if err != nil {
+		panic(err)
+	}
+	signing.Keys.Set(key)
+	TOTPSecretKey = []byte("test secret")
 }
 
 func teardown() {
@@ -27,9 +38,39 @@ func teardown() {
 
 func mockDB() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Passing MockDatabase in context
-		s := utils.NewMockDB()
+		// Passing a freshly seeded in-memory database in context
+		s := newTestStore()
 		c.Set("db", s)
 		c.Next()
 	}
 }
+
+// newTestStore returns an in-memory database holding user1 (password "password1")
+// and a single task owned by that user.
+func newTestStore() *utils.MemoryDB {
+	s := utils.NewMemoryDB()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password1"), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	userID, err := s.CreateUser(context.Background(), utils.User{Username: "user1", Password: string(hashedPassword)})
+	if err != nil {
+		panic(err)
+	}
+	if _, err := s.CreateTask(context.Background(), utils.Task{Title: "title1", Description: "description1", Status: "todo", UserID: userID}); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// newUserRouter returns a router that serves every request from the given
+// store, authenticated as the given user.
+func newUserRouter(s utils.Storage, userID int) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("db", s)
+		c.Set("user_id", userID)
+		c.Next()
+	})
+	return router
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/models"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Me is the logged in user with their profile.
+type Me struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	utils.Profile
+}
+
+// @Summary		Get the logged in user
+// @Description	Get the logged in user's account and profile, including the default sort of task lists
+// @Tags			Profile
+// @Produce		application/json
+// @Security		JWT
+// @Success		200	{object}	Me
+// @Failure		500	{object}	object{error=string}	"Internal server error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/me [get]
+func GetMe(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	me, err := getMe(c, db, userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	c.JSON(200, me)
+}
+
+// getMe reads a user's account and profile.
+func getMe(c *gin.Context, db utils.Storage, userID int) (Me, error) {
+	user, err := db.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		return Me{}, err
+	}
+	profile, err := db.GetProfile(c.Request.Context(), userID)
+	if err != nil {
+		return Me{}, err
+	}
+	return Me{ID: user.ID, Username: user.Username, Role: user.Role, Profile: profile}, nil
+}
+
+// @Summary		Update the logged in user's profile
+// @Description	Change the display name, email, timezone or default sort of task lists. Fields left out keep their value
+// @Tags			Profile
+// @Accept			application/json
+// @Produce		application/json
+// @Security		JWT
+// @Param			ProfileUpdate	body		models.ProfileUpdate	true	"Profile fields to change"
+// @Success		200				{object}	Me
+// @Failure		400				{object}	object{error=string}	"Invalid JSON"
+// @Failure		400				{object}	object{error=string}	"Validation error"
+// @Failure		500				{object}	object{error=string}	"Internal server error"
+// @Failure		503				{object}	object{error=string}	"Request cancelled"
+// @Failure		504				{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/me [patch]
+func UpdateMe(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var details models.ProfileUpdate
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	profile, err := db.GetProfile(c.Request.Context(), userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if details.DisplayName != nil {
+		profile.DisplayName = *details.DisplayName
+	}
+	if details.Email != nil {
+		profile.Email = *details.Email
+	}
+	if details.Timezone != nil {
+		profile.Timezone = *details.Timezone
+	}
+	if details.DefaultSortBy != nil {
+		profile.DefaultSortBy = *details.DefaultSortBy
+	}
+	if details.DefaultSortOrder != nil {
+		profile.DefaultSortOrder = *details.DefaultSortOrder
+	}
+	if err := db.UpdateProfile(c.Request.Context(), userId.(int), profile); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+
+	me, err := getMe(c, db, userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	c.JSON(200, me)
+}
+
+// @Summary		Delete the logged in user
+// @Description	Delete the logged in user's account with all of their tasks, tags and tokens, after confirming the password, and a code when two-factor authentication is enabled. Users of the identity provider have no password and confirm with the code alone, or else by having logged in within the last few minutes. This cannot be undone
+// @Tags			Profile
+// @Accept			application/json
+// @Produce		application/json
+// @Security		JWT
+// @Param			AccountDeletion	body		models.AccountDeletion	true	"Current password and/or code"
+// @Success		200				{object}	object{message=string}	"Account deleted successfully"
+// @Failure		400				{object}	object{error=string}	"Invalid JSON"
+// @Failure		400				{object}	object{error=string}	"Validation error"
+// @Failure		400				{object}	object{error=string}	"Password is required"
+// @Failure		400				{object}	object{error=string}	"Code is required"
+// @Failure		401				{object}	object{error=string}	"Invalid password"
+// @Failure		401				{object}	object{error=string}	"Invalid code"
+// @Failure		401				{object}	object{error=string}	"Log in again to confirm"
+// @Failure		500				{object}	object{error=string}	"Internal server error"
+// @Failure		503				{object}	object{error=string}	"Request cancelled"
+// @Failure		504				{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/me [delete]
+func DeleteMe(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var details models.AccountDeletion
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	user, err := db.GetUserByID(c.Request.Context(), userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if user.Password != "" {
+		if details.Password == "" {
+			c.JSON(400, gin.H{"error": "Password is required"})
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(details.Password)); err != nil {
+			c.JSON(401, gin.H{"error": "Invalid password"})
+			return
+		}
+		// A stolen password alone is not enough when a second factor is set up
+		if _, ok := confirmSecondFactor(c, db, user, details.Code); !ok {
+			return
+		}
+	} else if !confirmWithoutPassword(c, db, user, details.Code) {
+		return
+	}
+
+	if err := db.DeleteUser(c.Request.Context(), user.ID); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Account deleted successfully"})
+}
+
+// confirmWithoutPassword checks that a user of the identity provider, who
+// has no password, is the one asking: with a code when two-factor
+// authentication is enabled, or else with a login less than
+// helpers.RecentLogin ago. It responds and returns false otherwise.
+func confirmWithoutPassword(c *gin.Context, db utils.Storage, user utils.User, code string) bool {
+	enabled, ok := confirmSecondFactor(c, db, user, code)
+	if !ok {
+		return false
+	}
+	if enabled {
+		return true
+	}
+
+	// Tokens issued by a refresh or personal access tokens have no auth_time
+	authTime, ok := c.Get("auth_time")
+	if !ok || time.Since(authTime.(time.Time)) > helpers.RecentLogin {
+		c.JSON(401, gin.H{"error": "Log in again to confirm"})
+		return false
+	}
+	return true
+}
+
+// confirmSecondFactor checks the code when the user has two-factor
+// authentication enabled, and returns whether it is. It responds and returns
+// false for ok when the code is missing or invalid.
+func confirmSecondFactor(c *gin.Context, db utils.Storage, user utils.User, code string) (enabled, ok bool) {
+	credential, err := db.GetTOTP(c.Request.Context(), user.ID)
+	if err != nil && !errors.Is(err, utils.ErrTOTPNotFound) {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return false, false
+	}
+	if err != nil || credential.ConfirmedAt == nil {
+		return false, true
+	}
+	if code == "" {
+		c.JSON(400, gin.H{"error": "Code is required"})
+		return true, false
+	}
+	valid, err := verifySecondFactor(c, db, credential, code)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return true, false
+	}
+	if !valid {
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return true, false
+	}
+	return true, true
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMe(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.GET("/me", GetMe)
+	router.PATCH("/me", UpdateMe)
+	router.DELETE("/me", DeleteMe)
+	router.GET("/tasks", GetTasks)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	titles := func(url string) []string {
+		recorder := serve("GET", url, "")
+		require.Equal(t, 200, recorder.Code)
+		var tasks []utils.Task
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &tasks))
+		titles := make([]string, 0, len(tasks))
+		for _, task := range tasks {
+			titles = append(titles, task.Title)
+		}
+		return titles
+	}
+
+	assert.JSONEq(t, `{"id":1,"username":"user1","role":"user","display_name":"","email":"","timezone":"UTC","default_sort_by":"created_at","default_sort_order":"desc"}`,
+		serve("GET", "/me", "").Body.String())
+
+	recorder := serve("PATCH", "/me", `{"display_name":"User One","email":"user1@example.com","timezone":"Europe/Berlin"}`)
+	assert.Equal(t, 200, recorder.Code)
+	assert.JSONEq(t, `{"id":1,"username":"user1","role":"user","display_name":"User One","email":"user1@example.com","timezone":"Europe/Berlin","default_sort_by":"created_at","default_sort_order":"desc"}`,
+		recorder.Body.String())
+
+	// Fields left out keep their value
+	recorder = serve("PATCH", "/me", `{"email":""}`)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"display_name":"User One"`)
+	assert.Contains(t, recorder.Body.String(), `"email":""`)
+
+	for _, body := range []string{`{"email":"not-an-email"}`, `{"timezone":"Mars/Olympus"}`, `{"timezone":""}`, `{"default_sort_by":"user_id"}`, `{"default_sort_order":"up"}`, `not json`} {
+		assert.Equal(t, 400, serve("PATCH", "/me", body).Code, body)
+	}
+
+	// Task lists follow the preferred sort unless they ask for another
+	for _, title := range []string{"b", "c", "a"} {
+		_, err := store.CreateTask(context.Background(), utils.Task{Title: title, Status: "todo", UserID: 1})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"a", "c", "b", "title1"}, titles("/tasks"))
+	assert.Equal(t, 200, serve("PATCH", "/me", `{"default_sort_by":"title","default_sort_order":"asc"}`).Code)
+	assert.Equal(t, []string{"a", "b", "c", "title1"}, titles("/tasks"))
+	assert.Equal(t, []string{"title1", "c", "b", "a"}, titles("/tasks?order=desc"))
+	assert.Equal(t, []string{"title1", "b", "c", "a"}, titles("/tasks?sort_by=created_at"))
+
+	// Deleting the account needs the password
+	assert.Equal(t, 400, serve("DELETE", "/me", `{}`).Code)
+	assert.Equal(t, 401, serve("DELETE", "/me", `{"password":"wrong"}`).Code)
+	assert.Equal(t, 200, serve("DELETE", "/me", `{"password":"password1"}`).Code)
+	_, err := store.GetUserByUsername(context.Background(), "user1")
+	assert.ErrorIs(t, err, utils.ErrUserNotFound)
+	_, err = store.GetTaskByID(context.Background(), 1, 1)
+	assert.ErrorIs(t, err, utils.ErrTaskNotFound)
+}
+
+func TestDueTimezoneDefault(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.PATCH("/me", UpdateMe)
+	router.POST("/tasks", CreateTask)
+	router.PUT("/tasks/:id", UpdateTask)
+	router.PATCH("/tasks/:id", PatchTask)
+	router.POST("/tasks/bulk", BulkTasks)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	timezone := func(id int) string {
+		task, err := store.GetTaskByID(context.Background(), 1, id)
+		require.NoError(t, err)
+		return task.DueTimezone
+	}
+
+	// Tasks due without a timezone take the user's
+	require.Equal(t, 200, serve("PATCH", "/me", `{"timezone":"America/New_York"}`).Code)
+	require.Equal(t, 200, serve("POST", "/tasks", `{"title":"due","description":"d","status":"todo","due_at":"2026-03-10T14:00:00Z"}`).Code)
+	task, err := store.GetTaskByID(context.Background(), 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "America/New_York", task.DueTimezone)
+	assert.Equal(t, "2026-03-10T10:00:00-04:00", task.DueAt.Format(time.RFC3339))
+
+	// A timezone of their own, or no due date, is kept
+	require.Equal(t, 200, serve("POST", "/tasks", `{"title":"berlin","description":"d","status":"todo","due_at":"2026-03-10T14:00:00Z","due_timezone":"Europe/Berlin"}`).Code)
+	assert.Equal(t, "Europe/Berlin", timezone(3))
+	require.Equal(t, 200, serve("PATCH", "/tasks/1", `{"title":"renamed"}`).Code)
+	assert.Empty(t, timezone(1))
+
+	// Updates that give a due date without a timezone get it too
+	require.Equal(t, 200, serve("PATCH", "/tasks/1", `{"due_at":"2026-03-10T14:00:00Z"}`).Code)
+	assert.Equal(t, "America/New_York", timezone(1))
+	require.Equal(t, 200, serve("PATCH", "/tasks/3", `{"due_timezone":null}`).Code)
+	assert.Equal(t, "America/New_York", timezone(3))
+	require.Equal(t, 200, serve("PATCH", "/me", `{"timezone":"Asia/Tokyo"}`).Code)
+	require.Equal(t, 200, serve("PUT", "/tasks/1", `{"title":"put","description":"d","status":"todo","due_at":"2026-03-10T14:00:00Z"}`).Code)
+	assert.Equal(t, "Asia/Tokyo", timezone(1))
+	require.Equal(t, 200, serve("POST", "/tasks/bulk", `{"operations":[{"op":"create","task":{"title":"bulk","description":"d","status":"todo","due_at":"2026-03-10T14:00:00Z"}},{"op":"update","id":3,"task":{"due_at":"2026-03-11T14:00:00Z","due_timezone":null}}]}`).Code)
+	assert.Equal(t, "Asia/Tokyo", timezone(4))
+	assert.Equal(t, "Asia/Tokyo", timezone(3))
+}
+
+func TestDeleteMeWithoutPassword(t *testing.T) {
+	store := newTestStore()
+	provision := func(username string) int {
+		user, err := store.CreateExternalUser(context.Background(), utils.User{Username: username}, utils.ExternalIdentity{Issuer: "https://issuer.example.com", Subject: username + "-sub"})
+		require.NoError(t, err)
+		return user.ID
+	}
+	serve := func(userID int, authTime time.Time, body string) *httptest.ResponseRecorder {
+		router := newUserRouter(store, userID)
+		router.Use(func(c *gin.Context) {
+			if !authTime.IsZero() {
+				c.Set("auth_time", authTime)
+			}
+		})
+		router.DELETE("/me", DeleteMe)
+		req, err := http.NewRequest("DELETE", "/me", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	exists := func(userID int) bool {
+		_, err := store.GetUserByID(context.Background(), userID)
+		return err == nil
+	}
+
+	// Without two-factor authentication, a recent login confirms
+	bob := provision("bob")
+	assert.Equal(t, 401, serve(bob, time.Time{}, `{}`).Code)
+	recorder := serve(bob, time.Now().Add(-2*helpers.RecentLogin), `{"password":""}`)
+	assert.Equal(t, 401, recorder.Code)
+	assert.JSONEq(t, `{"error":"Log in again to confirm"}`, recorder.Body.String())
+	assert.True(t, exists(bob))
+	assert.Equal(t, 200, serve(bob, time.Now().Add(-time.Minute), `{}`).Code)
+	assert.False(t, exists(bob))
+
+	// With it, a code does, and a recent login alone is not enough
+	alice := provision("alice")
+	require.NoError(t, store.SetTOTPSecret(context.Background(), alice, "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, store.EnableTOTP(context.Background(), alice, 0, []string{helpers.HashRecoveryCode("abcde-fghjk")}))
+	assert.Equal(t, 400, serve(alice, time.Now(), `{}`).Code)
+	assert.Equal(t, 401, serve(alice, time.Now(), `{"code":"zzzzz-zzzzz"}`).Code)
+	assert.True(t, exists(alice))
+	assert.Equal(t, 200, serve(alice, time.Time{}, `{"code":"abcde-fghjk"}`).Code)
+	assert.False(t, exists(alice))
+
+	// Users with a password still give it, and the code too once two-factor
+	// authentication is enabled
+	assert.Equal(t, 400, serve(1, time.Now(), `{"code":"abcde-fghjk"}`).Code)
+	require.NoError(t, store.SetTOTPSecret(context.Background(), 1, "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, store.EnableTOTP(context.Background(), 1, 0, []string{helpers.HashRecoveryCode("abcde-fghjk")}))
+	recorder = serve(1, time.Now(), `{"password":"password1"}`)
+	assert.Equal(t, 400, recorder.Code)
+	assert.JSONEq(t, `{"error":"Code is required"}`, recorder.Body.String())
+	assert.Equal(t, 401, serve(1, time.Now(), `{"password":"password1","code":"zzzzz-zzzzz"}`).Code)
+	assert.Equal(t, 401, serve(1, time.Now(), `{"password":"wrong","code":"abcde-fghjk"}`).Code)
+	assert.True(t, exists(1))
+	assert.Equal(t, 200, serve(1, time.Time{}, `{"password":"password1","code":"abcde-fghjk"}`).Code)
+	assert.False(t, exists(1))
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/oidc"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDC is the OpenID Connect provider users can log in with, nil when none
+// is configured.
+var OIDC *oidc.Provider
+
+// oidcLoginTTL is how long a user has to log in at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcStateCookie holds the hash of the state of the login the browser
+// started, so the callback only finishes logins started by the same client
+// and nobody can be logged in to someone else's account with a callback link.
+const oidcStateCookie = "oidc_state"
+
+// setOIDCStateCookie sets the state cookie for the login and callback
+// endpoints, which share a path, or clears it with a negative maxAge.
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || strings.HasPrefix(OIDC.RedirectURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, path.Dir(c.Request.URL.Path), "", secure, true)
+}
+
+// @Summary		Start an OpenID Connect login
+// @Description	Start logging in through the company identity provider. Send the user to authorization_url; the provider redirects back to the configured redirect URL with code and state, to pass on to /api/v1/auth/oidc/callback. Sets an HttpOnly oidc_state cookie the callback must be sent with, so only this client can finish the login. Clients should check that the state they get back is the one returned here
+// @Tags			Register & Login
+// @Produce		application/json
+// @Success		200	{object}	object{authorization_url=string,state=string,expires_in=int}	"Login started"
+// @Failure		500	{object}	object{error=string}										"Internal server error"
+// @Failure		503	{object}	object{error=string}										"Request cancelled"
+// @Failure		504	{object}	object{error=string}										"Request timed out"
+// @Router			/api/v1/auth/oidc/login [get]
+func OIDCLogin(c *gin.Context) {
+	state, stateHash, err := helpers.GenerateOpaqueToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	nonce, err := helpers.NewTokenID()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	err = db.CreateOIDCLogin(c.Request.Context(), utils.OIDCLogin{
+		StateHash:    stateHash,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	setOIDCStateCookie(c, stateHash, int(oidcLoginTTL.Seconds()))
+	c.JSON(200, gin.H{"authorization_url": OIDC.AuthCodeURL(state, nonce, challenge), "state": state, "expires_in": int(oidcLoginTTL.Seconds())})
+}
+
+// @Summary		Finish an OpenID Connect login
+// @Description	Exchange the code and state the identity provider redirected back with for an access token and a refresh token. Needs the oidc_state cookie set by /api/v1/auth/oidc/login for the same state. Users logging in for the first time get an account linked to their identity at the provider, without a password. Users with two-factor authentication get an mfa_token instead, like at /api/v1/auth/login
+// @Tags			Register & Login
+// @Produce		application/json
+// @Param			code	query		string														true	"Authorization code"
+// @Param			state	query		string														true	"State returned by /api/v1/auth/oidc/login"
+// @Success		200		{object}	object{token=string,refresh_token=string,expires_in=int}	"User logged in successfully"
+// @Success		200		{object}	object{mfa_required=bool,mfa_token=string,expires_in=int}	"Two-factor authentication required"
+// @Failure		400		{object}	object{error=string}										"Missing code or state"
+// @Failure		400		{object}	object{error=string}										"State was not issued to this client"
+// @Failure		400		{object}	object{error=string}										"Invalid or expired state"
+// @Failure		401		{object}	object{error=string}										"Login with the identity provider failed"
+// @Failure		401		{object}	object{error=string}										"Invalid ID token"
+// @Failure		403		{object}	object{error=string}										"Account disabled"
+// @Failure		409		{object}	object{error=string}										"Username already exists"
+// @Failure		500		{object}	object{error=string}										"Internal server error"
+// @Failure		502		{object}	object{error=string}										"Identity provider unavailable"
+// @Failure		503		{object}	object{error=string}										"Request cancelled"
+// @Failure		504		{object}	object{error=string}										"Request timed out"
+// @Router			/api/v1/auth/oidc/callback [get]
+func OIDCCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(401, gin.H{"error": "Login with the identity provider failed"})
+		return
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(400, gin.H{"error": "Missing code or state"})
+		return
+	}
+
+	stateHash := helpers.HashToken(state)
+	if cookie, err := c.Cookie(oidcStateCookie); err != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(stateHash)) != 1 {
+		c.JSON(400, gin.H{"error": "State was not issued to this client"})
+		return
+	}
+	setOIDCStateCookie(c, "", -1)
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	login, err := db.ConsumeOIDCLogin(c.Request.Context(), stateHash)
+	if err != nil {
+		if errors.Is(err, utils.ErrOIDCLoginInvalid) {
+			c.JSON(400, gin.H{"error": "Invalid or expired state"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+
+	rawIDToken, err := OIDC.Exchange(c.Request.Context(), code, login.CodeVerifier)
+	if err != nil {
+		var providerErr *oidc.Error
+		if errors.As(err, &providerErr) {
+			c.JSON(401, gin.H{"error": "Login with the identity provider failed"})
+			return
+		}
+		log.Println("OIDC token exchange failed:", err)
+		c.JSON(502, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+	idToken, err := OIDC.Verify(c.Request.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidIDToken) {
+			c.JSON(401, gin.H{"error": "Invalid ID token"})
+			return
+		}
+		log.Println("OIDC key fetch failed:", err)
+		c.JSON(502, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	user, err := db.GetUserByExternalIdentity(c.Request.Context(), idToken.Issuer, idToken.Subject)
+	if errors.Is(err, utils.ErrUserNotFound) {
+		user, err = provisionExternalUser(c, db, idToken)
+	}
+	if err != nil {
+		if errors.Is(err, utils.ErrUsernameExists) {
+			c.JSON(409, gin.H{"error": "Username already exists"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if user.DisabledAt != nil {
+		c.JSON(403, gin.H{"error": "Account disabled"})
+		return
+	}
+
+	startSession(c, db, user)
+}
+
+// provisionExternalUser creates the account of a user logging in through the
+// identity provider for the first time. Existing users are never linked by
+// username or email, so an account at the provider cannot take over a local
+// one; a taken username gets a suffix derived from the subject instead.
+func provisionExternalUser(c *gin.Context, db utils.Storage, idToken oidc.IDToken) (utils.User, error) {
+	identity := utils.ExternalIdentity{Issuer: idToken.Issuer, Subject: idToken.Subject}
+	base := externalUsername(idToken)
+	sum := sha256.Sum256([]byte(idToken.Issuer + " " + idToken.Subject))
+	suffix := hex.EncodeToString(sum[:])[:6]
+
+	var err error
+	for _, username := range []string{base, base + suffix} {
+		var user utils.User
+		user, err = db.CreateExternalUser(c.Request.Context(), utils.User{Username: username}, identity)
+		switch {
+		case err == nil:
+			return user, nil
+		case errors.Is(err, utils.ErrExternalIdentityExists):
+			// Another login of the same user provisioned it first
+			return db.GetUserByExternalIdentity(c.Request.Context(), idToken.Issuer, idToken.Subject)
+		case !errors.Is(err, utils.ErrUsernameExists):
+			return utils.User{}, err
+		}
+	}
+	return utils.User{}, err
+}
+
+// externalUsername derives a username like the ones users register with,
+// letters and digits only, from the preferred username or email.
+func externalUsername(idToken oidc.IDToken) string {
+	name := idToken.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return -1
+	}, name)
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	if len(name) < 4 {
+		name = "user" + name
+	}
+	return name
+}
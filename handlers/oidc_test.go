@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Parjun2000/task-manager/oidc"
+	"github.com/Parjun2000/task-manager/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	provider, err := oidctest.NewProvider("task-manager", "client-secret")
+	require.NoError(t, err)
+	defer provider.Close()
+	OIDC, err = oidc.Discover(context.Background(), provider.Config("http://app.test/auth/oidc/callback"), nil)
+	require.NoError(t, err)
+	defer func() { OIDC = nil }()
+
+	store := newTestStore()
+	router := newUserRouter(store, 0)
+	router.GET("/auth/oidc/login", OIDCLogin)
+	router.GET("/auth/oidc/callback", OIDCCallback)
+	router.POST("/auth/login", Login)
+
+	// cookie is the state cookie of the client, kept between requests
+	var cookie *http.Cookie
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, target, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		for _, set := range recorder.Result().Cookies() {
+			if set.Name == oidcStateCookie {
+				cookie = set
+				if set.MaxAge < 0 {
+					cookie = nil
+				}
+			}
+		}
+		return recorder
+	}
+	// authorize starts a login and returns the callback the provider
+	// redirects the subject back to
+	authorize := func(subject string, claims map[string]interface{}) string {
+		recorder := serve("GET", "/auth/oidc/login", "")
+		require.Equal(t, 200, recorder.Code)
+		var started struct {
+			AuthorizationURL string `json:"authorization_url"`
+			State            string `json:"state"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &started))
+		callback, err := provider.Authorize(started.AuthorizationURL, subject, claims)
+		require.NoError(t, err)
+		parsed, err := url.Parse(callback)
+		require.NoError(t, err)
+		assert.Equal(t, started.State, parsed.Query().Get("state"))
+		return parsed.RequestURI()
+	}
+	login := func(subject string, claims map[string]interface{}) *httptest.ResponseRecorder {
+		return serve("GET", authorize(subject, claims), "")
+	}
+
+	// First logins provision a user without a password
+	recorder := login("alice-sub", map[string]interface{}{"preferred_username": "alice.smith", "email": "alice@example.com"})
+	require.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "refresh_token")
+	alice, err := store.GetUserByUsername(context.Background(), "alicesmith")
+	require.NoError(t, err)
+	assert.Empty(t, alice.Password)
+	assert.Equal(t, 401, serve("POST", "/auth/login", `{"username":"alicesmith","password":""}`).Code)
+
+	// Later logins find the same user, even under a new name
+	assert.Equal(t, 200, login("alice-sub", map[string]interface{}{"preferred_username": "alice"}).Code)
+	user, err := store.GetUserByExternalIdentity(context.Background(), provider.URL, "alice-sub")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID)
+
+	// Local users are never taken over by name
+	assert.Equal(t, 200, login("other-sub", map[string]interface{}{"preferred_username": "user1"}).Code)
+	user, err = store.GetUserByExternalIdentity(context.Background(), provider.URL, "other-sub")
+	require.NoError(t, err)
+	assert.NotEqual(t, "user1", user.Username)
+	assert.Regexp(t, "^user1[0-9a-f]{6}$", user.Username)
+
+	// The email names users without a preferred username, padded to the
+	// minimum length
+	assert.Equal(t, 200, login("bob-sub", map[string]interface{}{"email": "bob@example.com"}).Code)
+	_, err = store.GetUserByUsername(context.Background(), "userbob")
+	assert.NoError(t, err)
+
+	// States are single use and bound to the login that issued them
+	callback := authorize("alice-sub", nil)
+	assert.Equal(t, 200, serve("GET", callback, "").Code)
+	assert.Equal(t, 400, serve("GET", callback, "").Code)
+	assert.Equal(t, 400, serve("GET", "/auth/oidc/callback?code=abc&state=unknown", "").Code)
+	assert.Equal(t, 400, serve("GET", "/auth/oidc/callback", "").Code)
+	assert.Equal(t, 401, serve("GET", "/auth/oidc/callback?error=access_denied&state=abc", "").Code)
+
+	// Callbacks need the state cookie of the client that started the login,
+	// so nobody can be logged in with someone else's callback link
+	callback = authorize("alice-sub", nil)
+	recorder = serve("GET", "/auth/oidc/login", "")
+	require.Equal(t, 200, recorder.Code)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.Equal(t, "/auth/oidc", cookie.Path)
+	recorder = serve("GET", callback, "")
+	assert.Equal(t, 400, recorder.Code)
+	assert.JSONEq(t, `{"error":"State was not issued to this client"}`, recorder.Body.String())
+	cookie = nil
+	assert.Equal(t, 400, serve("GET", callback, "").Code)
+
+	// Codes are exchanged with the verifier of their own login
+	callback = authorize("alice-sub", nil)
+	other := authorize("alice-sub", nil)
+	parsed, _ := url.Parse(callback)
+	otherParsed, _ := url.Parse(other)
+	query := parsed.Query()
+	query.Set("state", otherParsed.Query().Get("state"))
+	assert.Equal(t, 401, serve("GET", "/auth/oidc/callback?"+query.Encode(), "").Code)
+
+	// ID tokens for another client or past their expiry are rejected
+	assert.Equal(t, 401, login("alice-sub", map[string]interface{}{"aud": "another-client"}).Code)
+	assert.Equal(t, 401, login("alice-sub", map[string]interface{}{"exp": 1}).Code)
+
+	// Users with two-factor authentication still need a code
+	require.NoError(t, store.SetTOTPSecret(context.Background(), alice.ID, "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, store.EnableTOTP(context.Background(), alice.ID, 0, nil))
+	recorder = login("alice-sub", nil)
+	require.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "mfa_token")
+	assert.NotContains(t, recorder.Body.String(), "refresh_token")
+
+	require.NoError(t, store.SetUserDisabled(context.Background(), alice.ID, true))
+	assert.Equal(t, 403, login("alice-sub", nil).Code)
+
+	// The provider's keys being unavailable is not the token's fault
+	OIDC, err = oidc.Discover(context.Background(), provider.Config("http://app.test/auth/oidc/callback"), nil)
+	require.NoError(t, err)
+	provider.SetKeysDown(true)
+	recorder = login("bob-sub", nil)
+	assert.Equal(t, 502, recorder.Code)
+	assert.JSONEq(t, `{"error":"Identity provider unavailable"}`, recorder.Body.String())
+	provider.SetKeysDown(false)
+	assert.Equal(t, 200, login("bob-sub", nil).Code)
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/models"
+	"github.com/Parjun2000/task-manager/notifier"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Notifier delivers password reset tokens to users.
+var Notifier notifier.Notifier = notifier.NewLogNotifier(nil)
+
+// PasswordResetURL is the page reset tokens are sent to as the token query
+// parameter. When empty the bare token is sent.
+var PasswordResetURL string
+
+// passwordResetTimeout limits how long creating and sending a password reset
+// may take.
+const passwordResetTimeout = 30 * time.Second
+
+// ForgotPasswordDetails
+type ForgotPasswordDetails struct {
+	Username string `json:"username"`
+}
+
+// forgotPasswordMessage is returned whether or not the user exists, so the
+// endpoint cannot be used to find out which usernames are taken.
+const forgotPasswordMessage = "If the user exists, a password reset token has been sent"
+
+// @Summary		Change password
+// @Description	Change the password of the logged in user. Every other session of the user is signed out
+// @Tags			Register & Login
+// @Accept			application/json
+// @Param			PasswordChange	body		models.PasswordChange	true	"Current & new password"
+// @Success		200				{object}	object{message=string}	"Password changed successfully"
+// @Failure		400				{object}	object{error=string}	"Invalid JSON"
+// @Failure		400				{object}	object{error=string}	"Validation error"
+// @Failure		401				{object}	object{error=string}	"Invalid current password"
+// @Failure		500				{object}	object{error=string}	"Internal server error"
+// @Failure		503				{object}	object{error=string}	"Request cancelled"
+// @Failure		504				{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/auth/password [post]
+// @Security		JWT
+func ChangePassword(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var details models.PasswordChange
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	user, err := db.GetUserByID(c.Request.Context(), userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(details.CurrentPassword)); err != nil {
+		c.JSON(401, gin.H{"error": "Invalid current password"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(details.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	// The session making the change stays signed in
+	if err := db.UpdateUserPassword(c.Request.Context(), user.ID, string(hashedPassword), c.GetString("token_id")); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Password changed successfully"})
+}
+
+// @Summary		Request a password reset
+// @Description	Send a single-use password reset token to the user through the configured notifier. The response is the same whether or not the user exists, and the token is sent after responding. Requests are limited per username and per client IP
+// @Tags			Register & Login
+// @Accept			application/json
+// @Param			ForgotPasswordDetails	body		ForgotPasswordDetails	true	"Username"
+// @Success		200						{object}	object{message=string}	"If the user exists, a password reset token has been sent"
+// @Failure		400						{object}	object{error=string}	"Invalid JSON"
+// @Failure		429						{object}	object{error=string}	"Too many password reset requests"
+// @Failure		500						{object}	object{error=string}	"Internal server error"
+// @Failure		503						{object}	object{error=string}	"Request cancelled"
+// @Failure		504						{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/auth/password/forgot [post]
+func ForgotPassword(c *gin.Context) {
+	var details ForgotPasswordDetails
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	userKey, ipKey := "reset:user:"+details.Username, "reset:ip:"+c.ClientIP()
+	locked, err := throttledFor(c, db, map[string]int{
+		userKey: helpers.PasswordResetMaxRequests,
+		ipKey:   helpers.PasswordResetIPMaxRequests,
+	})
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if locked > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.Seconds()))))
+		c.JSON(429, gin.H{"error": "Too many password reset requests"})
+		return
+	}
+	// Every request counts, whether or not the user exists
+	since := time.Now().Add(-helpers.LoginFailureWindow)
+	for _, key := range []string{userKey, ipKey} {
+		if _, err := db.RecordLoginFailure(c.Request.Context(), key, since); err != nil {
+			helpers.RespondStorageError(c, err, 500, "Internal server error")
+			return
+		}
+	}
+
+	// Looking the user up and sending the token happen after responding, so
+	// the response time does not tell whether the user exists either
+	passwordResets.Add(1)
+	go func(username string) {
+		defer passwordResets.Done()
+		sendPasswordReset(db, username)
+	}(details.Username)
+
+	c.JSON(200, gin.H{"message": forgotPasswordMessage})
+}
+
+// passwordResets tracks the password resets still being sent.
+var passwordResets sync.WaitGroup
+
+// sendPasswordReset creates a password reset token for the user, if there is
+// one with a password, and sends it. Failures are only logged.
+func sendPasswordReset(db utils.Storage, username string) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordResetTimeout)
+	defer cancel()
+
+	user, err := db.GetUserByUsername(ctx, username)
+	if err != nil {
+		if !errors.Is(err, utils.ErrUserNotFound) {
+			log.Printf("Looking up user for password reset failed: %v", err)
+		}
+		return
+	}
+	// Users of the identity provider have no password to reset
+	if user.Password == "" {
+		return
+	}
+
+	token, tokenHash, err := helpers.GenerateOpaqueToken()
+	if err != nil {
+		log.Printf("Creating password reset for user %d failed: %v", user.ID, err)
+		return
+	}
+	err = db.CreatePasswordReset(ctx, utils.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(helpers.PasswordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Creating password reset for user %d failed: %v", user.ID, err)
+		return
+	}
+
+	err = Notifier.Notify(ctx, notifier.Message{
+		To:      user.Username,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use %s to choose a new password. It can be used once and expires in %s.",
+			passwordResetLink(token), helpers.PasswordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Sending password reset to user %d failed: %v", user.ID, err)
+	}
+}
+
+// passwordResetLink returns what a user is sent to reset their password.
+func passwordResetLink(token string) string {
+	if PasswordResetURL == "" {
+		return "the reset token " + token
+	}
+	link, err := url.Parse(PasswordResetURL)
+	if err != nil {
+		return "the reset token " + token
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// @Summary		Reset password
+// @Description	Choose a new password with a password reset token. The token and every other outstanding token of the user are used up, and all of the user's sessions are signed out
+// @Tags			Register & Login
+// @Accept			application/json
+// @Param			PasswordReset	body		models.PasswordReset	true	"Reset token & new password"
+// @Success		200				{object}	object{message=string}	"Password reset successfully"
+// @Failure		400				{object}	object{error=string}	"Invalid JSON"
+// @Failure		400				{object}	object{error=string}	"Validation error"
+// @Failure		400				{object}	object{error=string}	"Invalid or expired reset token"
+// @Failure		500				{object}	object{error=string}	"Internal server error"
+// @Failure		503				{object}	object{error=string}	"Request cancelled"
+// @Failure		504				{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/auth/password/reset [post]
+func ResetPassword(c *gin.Context) {
+	var details models.PasswordReset
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(details.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if _, err := db.ResetPassword(c.Request.Context(), helpers.HashToken(details.Token), string(hashedPassword)); err != nil {
+		if errors.Is(err, utils.ErrResetTokenInvalid) {
+			c.JSON(400, gin.H{"error": "Invalid or expired reset token"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Password reset successfully"})
+}
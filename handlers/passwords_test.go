@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Parjun2000/task-manager/notifier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingNotifier keeps the messages it is asked to deliver.
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []notifier.Message
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, msg notifier.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+func TestPasswords(t *testing.T) {
+	outbox := &recordingNotifier{}
+	Notifier, PasswordResetURL = outbox, "https://tasks.example.com/reset?lang=en"
+	defer func() {
+		Notifier, PasswordResetURL = notifier.NewLogNotifier(nil), ""
+	}()
+
+	router := newUserRouter(newTestStore(), 1)
+	router.POST("/auth/login", Login)
+	router.POST("/auth/refresh", Refresh)
+	router.POST("/auth/password", ChangePassword)
+	router.POST("/auth/password/forgot", ForgotPassword)
+	router.POST("/auth/password/reset", ResetPassword)
+
+	serve := func(url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	refreshToken := regexp.MustCompile(`"refresh_token":"([^"]+)"`)
+	login := func(password string) string {
+		recorder := serve("/auth/login", `{"username":"user1","password":"`+password+`"}`)
+		require.Equal(t, 200, recorder.Code)
+		return refreshToken.FindStringSubmatch(recorder.Body.String())[1]
+	}
+
+	// Changing the password needs the current one and signs out other sessions
+	session := login("password1")
+	assert.Equal(t, 401, serve("/auth/password", `{"current_password":"wrong","new_password":"password2"}`).Code)
+	assert.Equal(t, 400, serve("/auth/password", `{"current_password":"password1","new_password":"short"}`).Code)
+	assert.Equal(t, 400, serve("/auth/password", "").Code)
+	// bcrypt hashes at most 72 bytes, however many characters they are
+	assert.Equal(t, 400, serve("/auth/password", `{"current_password":"password1","new_password":"`+strings.Repeat("a", 73)+`"}`).Code)
+	assert.Equal(t, 400, serve("/auth/password", `{"current_password":"password1","new_password":"`+strings.Repeat("é", 37)+`"}`).Code)
+	assert.Equal(t, 200, serve("/auth/password", `{"current_password":"password1","new_password":"password2"}`).Code)
+	assert.Equal(t, 401, serve("/auth/login", `{"username":"user1","password":"password1"}`).Code)
+	assert.Equal(t, 401, serve("/auth/refresh", `{"refresh_token":"`+session+`"}`).Code)
+
+	// Unknown users get the same answer and no message
+	unknown := serve("/auth/password/forgot", `{"username":"nobody"}`)
+	assert.Equal(t, 200, unknown.Code)
+	passwordResets.Wait()
+	assert.Empty(t, outbox.messages)
+
+	session = login("password2")
+	recorder := serve("/auth/password/forgot", `{"username":"user1"}`)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, unknown.Body.String(), recorder.Body.String())
+	passwordResets.Wait()
+	require.Len(t, outbox.messages, 1)
+	assert.Equal(t, "user1", outbox.messages[0].To)
+	link := regexp.MustCompile(`https://\S+`).FindString(outbox.messages[0].Body)
+	parsed, err := url.Parse(link)
+	require.NoError(t, err)
+	assert.Equal(t, "en", parsed.Query().Get("lang"))
+	token := parsed.Query().Get("token")
+	require.NotEmpty(t, token)
+
+	// The token works once and signs out every session
+	assert.Equal(t, 400, serve("/auth/password/reset", `{"token":"`+token+`","new_password":"short"}`).Code)
+	assert.Equal(t, 400, serve("/auth/password/reset", `{"token":"`+token+`","new_password":"`+strings.Repeat("a", 73)+`"}`).Code)
+	assert.Equal(t, 200, serve("/auth/password/reset", `{"token":"`+token+`","new_password":"password3"}`).Code)
+	recorder = serve("/auth/password/reset", `{"token":"`+token+`","new_password":"password4"}`)
+	assert.Equal(t, 400, recorder.Code)
+	assert.JSONEq(t, `{"error":"Invalid or expired reset token"}`, recorder.Body.String())
+	assert.Equal(t, 401, serve("/auth/refresh", `{"refresh_token":"`+session+`"}`).Code)
+	assert.Equal(t, 401, serve("/auth/login", `{"username":"user1","password":"password2"}`).Code)
+	login("password3")
+
+	// Requests for a username are throttled, sent or not
+	assert.Equal(t, 200, serve("/auth/password/forgot", `{"username":"user1"}`).Code)
+	assert.Equal(t, 200, serve("/auth/password/forgot", `{"username":"user1"}`).Code)
+	recorder = serve("/auth/password/forgot", `{"username":"user1"}`)
+	assert.Equal(t, 429, recorder.Code)
+	assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+	// Other usernames are not
+	assert.Equal(t, 200, serve("/auth/password/forgot", `{"username":"nobody"}`).Code)
+	passwordResets.Wait()
+	assert.Len(t, outbox.messages, 3)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ParentDetails
+type ParentDetails struct {
+	// ParentID is the new parent task, or null to make the task top-level
+	ParentID *int `json:"parent_id"`
+}
+
+// @Summary		Get subtasks
+// @Description	Get the direct subtasks of a task ordered by creation
+// @Tags			Tasks
+// @Produce		application/json
+// @Security		JWT
+// @Param			id				path		int		true	"Task ID"
+// @Param			If-None-Match	header		string	false	"ETag of a cached response"
+// @Success		200	{array}		utils.Task
+// @Header			200	{string}	ETag	"Entity tag of the response"
+// @Success		304	"Not modified"
+// @Failure		400	{object}	object{error=string}	"Invalid Task Id"
+// @Failure		404	{object}	object{error=string}	"Task not found"
+// @Failure		500	{object}	object{error=string}	"Internal Server Error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/tasks/{id}/children [get]
+func GetTaskChildren(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Task Id"})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	tasks, err := db.GetTaskChildren(c.Request.Context(), userId.(int), id)
+	if err != nil {
+		if errors.Is(err, utils.ErrTaskNotFound) {
+			c.JSON(404, gin.H{"error": "Task not found"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
+	}
+	helpers.RespondWithETag(c, 200, tasks)
+}
+
+// @Summary		Move a task
+// @Description	Move a task under a different parent task, or make it top-level with a null parent_id
+// @Tags			Tasks
+// @Accept			application/json
+// @Security		JWT
+// @Param			id				path		int						true	"Task ID"
+// @Param			ParentDetails	body		ParentDetails			true	"New parent"
+// @Success		200				{object}	object{message=string}	"Task moved successfully"
+// @Failure		400				{object}	object{error=string}	"Invalid JSON"
+// @Failure		400				{object}	object{error=string}	"Invalid Task Id"
+// @Failure		404				{object}	object{error=string}	"Task not found"
+// @Failure		409				{object}	object{error=string}	"Task cannot be moved under itself or its subtasks"
+// @Failure		500				{object}	object{error=string}	"Failed to move task"
+// @Failure		503				{object}	object{error=string}	"Request cancelled"
+// @Failure		504				{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/tasks/{id}/parent [put]
+func MoveTask(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Task Id"})
+		return
+	}
+	var details ParentDetails
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.MoveTask(c.Request.Context(), userId.(int), id, details.ParentID); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrTaskNotFound):
+			c.JSON(404, gin.H{"error": "Task not found"})
+		case errors.Is(err, utils.ErrTaskCycle):
+			c.JSON(409, gin.H{"error": "Task cannot be moved under itself or its subtasks"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Failed to move task")
+		}
+		return
+	}
+	c.JSON(200, gin.H{"message": "Task moved successfully"})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubtasks(t *testing.T) {
+	// Create task routes for user1, whose task 1 is title1
+	router := newUserRouter(newTestStore(), 1)
+	router.POST("/tasks", CreateTask)
+	router.GET("/tasks/:id", GetTaskByID)
+	router.DELETE("/tasks/:id", DeleteTask)
+	router.GET("/tasks/:id/children", GetTaskChildren)
+	router.PUT("/tasks/:id/parent", MoveTask)
+	router.PUT("/tasks/:id", UpdateTask)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, 200, serve("POST", "/tasks", `{"title":"child","description":"d","status":"done","parent_id":1}`).Code)
+	assert.Equal(t, 200, serve("POST", "/tasks", `{"title":"grandchild","description":"d","status":"todo","parent_id":2}`).Code)
+	assert.Equal(t, 400, serve("POST", "/tasks", `{"title":"orphan","description":"d","status":"todo","parent_id":99}`).Code)
+
+	recorder := serve("GET", "/tasks/1", "")
+	assert.Equal(t, 200, recorder.Code)
+	var task utils.Task
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &task))
+	assert.Equal(t, &utils.TaskProgress{Done: 1, Total: 1}, task.Progress)
+
+	recorder = serve("GET", "/tasks/1/children", "")
+	assert.Equal(t, 200, recorder.Code)
+	var children []utils.Task
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &children))
+	assert.Len(t, children, 1)
+	assert.Equal(t, 404, serve("GET", "/tasks/99/children", "").Code)
+
+	assert.Equal(t, 409, serve("PUT", "/tasks/1/parent", `{"parent_id":3}`).Code)
+	assert.Equal(t, 404, serve("PUT", "/tasks/1/parent", `{"parent_id":99}`).Code)
+	assert.Equal(t, 200, serve("PUT", "/tasks/3/parent", `{"parent_id":1}`).Code)
+	assert.Equal(t, 200, serve("PUT", "/tasks/3/parent", `{"parent_id":null}`).Code)
+
+	// PUT keeps the parent, and refuses to move tasks
+	assert.Equal(t, 400, serve("PUT", "/tasks/3", `{"title":"grandchild","description":"d","status":"todo","parent_id":2}`).Code)
+	assert.Equal(t, 200, serve("PUT", "/tasks/3/parent", `{"parent_id":2}`).Code)
+	assert.Equal(t, 200, serve("PUT", "/tasks/3", `{"title":"grandchild","description":"d","status":"todo","parent_id":2}`).Code)
+	recorder = serve("PUT", "/tasks/3", `{"title":"grandchild","description":"d","status":"todo","parent_id":1}`)
+	assert.Equal(t, 400, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "PATCH")
+	assert.Equal(t, 200, serve("PUT", "/tasks/3", `{"title":"grandchild","description":"d","status":"todo"}`).Code)
+	task = utils.Task{}
+	assert.NoError(t, json.Unmarshal(serve("GET", "/tasks/3", "").Body.Bytes(), &task))
+	assert.Equal(t, 2, *task.ParentID)
+
+	defer func(policy utils.ChildDeletePolicy) { SubtaskDeletePolicy = policy }(SubtaskDeletePolicy)
+	SubtaskDeletePolicy = utils.RestrictChildren
+	assert.Equal(t, 409, serve("DELETE", "/tasks/1", "").Code)
+	SubtaskDeletePolicy = utils.CascadeChildren
+	assert.Equal(t, 200, serve("DELETE", "/tasks/1", "").Code)
+	assert.Equal(t, 404, serve("GET", "/tasks/2", "").Code)
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/models"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TagDetails
+type TagDetails struct {
+	Name string `json:"name"`
+}
+
+// @Summary		Get tags
+// @Description	Get all tags of the user ordered by name
+// @Tags			Tags
+// @Produce		application/json
+// @Security		JWT
+// @Success		200	{array}		utils.Tag
+// @Failure		500	{object}	object{error=string}	"Internal Server Error"
+// @Failure		500	{object}	object{error=string}	"Failed to fetch tags"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/tags [get]
+func GetTags(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	tags, err := db.GetTags(c.Request.Context(), userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Failed to fetch tags")
+		return
+	}
+	c.JSON(200, tags)
+}
+
+// @Summary		Create a tag
+// @Description	Create a new tag
+// @Tags			Tags
+// @Accept			application/json
+// @Produce		application/json
+// @Security		JWT
+// @Param			TagDetails	body		TagDetails						true	"Tag Details"
+// @Success		200			{object}	object{message=string,id=int}	"Tag created successfully"
+// @Failure		400			{object}	object{error=string}			"Invalid JSON"
+// @Failure		400			{object}	object{error=string}			"Validation Error"
+// @Failure		409			{object}	object{error=string}			"Tag already exists"
+// @Failure		500			{object}	object{error=string}			"Internal Server Error"
+// @Failure		503			{object}	object{error=string}			"Request cancelled"
+// @Failure		504			{object}	object{error=string}			"Request timed out"
+// @Router			/api/v1/tags [post]
+func CreateTag(c *gin.Context) {
+	var tag models.Tag
+	if err := c.BindJSON(&tag); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := tag.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	tagId, err := db.CreateTag(c.Request.Context(), utils.Tag{UserID: userId.(int), Name: tag.Name})
+	if err != nil {
+		if errors.Is(err, utils.ErrTagExists) {
+			c.JSON(409, gin.H{"error": "Tag already exists"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Tag created successfully", "id": tagId})
+}
+
+// @Summary		Get tag by ID
+// @Description	Get a tag by its ID
+// @Tags			Tags
+// @Produce		application/json
+// @Security		JWT
+// @Param			id	path		int	true	"Tag ID"
+// @Success		200	{object}	utils.Tag
+// @Failure		400	{object}	object{error=string}	"Invalid Tag Id"
+// @Failure		404	{object}	object{error=string}	"Tag not found"
+// @Failure		500	{object}	object{error=string}	"Internal Server Error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/tags/{id} [get]
+func GetTagByID(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Tag Id"})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	tag, err := db.GetTagByID(c.Request.Context(), userId.(int), id)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 404, "Tag not found")
+		return
+	}
+	c.JSON(200, tag)
+}
+
+// @Summary		Rename a tag
+// @Description	Rename an existing tag by ID, the change shows on every tagged task
+// @Tags			Tags
+// @Accept			application/json
+// @Security		JWT
+// @Param			id			path		int						true	"Tag ID"
+// @Param			TagDetails	body		TagDetails				true	"Updated Tag Details"
+// @Success		200			{object}	object{message=string}	"Tag updated successfully"
+// @Failure		400			{object}	object{error=string}	"Invalid JSON"
+// @Failure		400			{object}	object{error=string}	"Invalid Tag Id"
+// @Failure		404			{object}	object{error=string}	"Tag not found"
+// @Failure		409			{object}	object{error=string}	"Tag already exists"
+// @Failure		500			{object}	object{error=string}	"Failed to update tag"
+// @Failure		503			{object}	object{error=string}	"Request cancelled"
+// @Failure		504			{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/tags/{id} [put]
+func UpdateTag(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Tag Id"})
+		return
+	}
+	var tag models.Tag
+	if err := c.BindJSON(&tag); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if err := tag.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.RenameTag(c.Request.Context(), userId.(int), id, tag.Name); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrTagNotFound):
+			c.JSON(404, gin.H{"error": "Tag not found"})
+		case errors.Is(err, utils.ErrTagExists):
+			c.JSON(409, gin.H{"error": "Tag already exists"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Failed to update tag")
+		}
+		return
+	}
+	c.JSON(200, gin.H{"message": "Tag updated successfully"})
+}
+
+// @Summary		Delete a tag
+// @Description	Delete a tag by ID and remove it from every task
+// @Tags			Tags
+// @Security		JWT
+// @Param			id	path		int						true	"Tag ID"
+// @Success		200	{object}	object{message=string}	"Tag deleted successfully"
+// @Failure		400	{object}	object{error=string}	"Invalid Tag Id"
+// @Failure		404	{object}	object{error=string}	"Tag not found"
+// @Failure		500	{object}	object{error=string}	"Failed to delete tag"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/tags/{id} [delete]
+func DeleteTag(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Tag Id"})
+		return
+	}
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.DeleteTag(c.Request.Context(), userId.(int), id); err != nil {
+		if errors.Is(err, utils.ErrTagNotFound) {
+			c.JSON(404, gin.H{"error": "Tag not found"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Failed to delete tag")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Tag deleted successfully"})
+}
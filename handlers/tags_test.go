@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTags(t *testing.T) {
+	// Create tag routes for user1
+	router := newUserRouter(newTestStore(), 1)
+	router.GET("/tags", GetTags)
+	router.POST("/tags", CreateTag)
+	router.GET("/tags/:id", GetTagByID)
+	router.PUT("/tags/:id", UpdateTag)
+	router.DELETE("/tags/:id", DeleteTag)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, 200, serve("POST", "/tags", `{"name":"work"}`).Code)
+	assert.Equal(t, 200, serve("POST", "/tags", `{"name":"home"}`).Code)
+	assert.Equal(t, 409, serve("POST", "/tags", `{"name":"work"}`).Code)
+	assert.Equal(t, 400, serve("POST", "/tags", `{"name":""}`).Code)
+
+	recorder := serve("GET", "/tags", "")
+	assert.Equal(t, 200, recorder.Code)
+	var tags []utils.Tag
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &tags))
+	assert.Len(t, tags, 2)
+
+	assert.Equal(t, 200, serve("GET", "/tags/1", "").Code)
+	assert.Equal(t, 404, serve("GET", "/tags/99", "").Code)
+	assert.Equal(t, 400, serve("GET", "/tags/abc", "").Code)
+
+	assert.Equal(t, 409, serve("PUT", "/tags/1", `{"name":"home"}`).Code)
+	assert.Equal(t, 404, serve("PUT", "/tags/99", `{"name":"job"}`).Code)
+	assert.Equal(t, 200, serve("PUT", "/tags/1", `{"name":"job"}`).Code)
+
+	assert.Equal(t, 200, serve("DELETE", "/tags/1", "").Code)
+	assert.Equal(t, 404, serve("DELETE", "/tags/1", "").Code)
+}
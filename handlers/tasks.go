@@ -1,10 +1,20 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/Parjun2000/task-manager/filterexpr"
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/jsonpatch"
 	"github.com/Parjun2000/task-manager/models"
 	"github.com/Parjun2000/task-manager/utils"
 
@@ -13,29 +23,59 @@ import (
 
 // TaskDetails
 type TaskDetails struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Status      string `json:"status"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"`
+	DueAt       *time.Time `json:"due_at"`
+	DueTimezone string     `json:"due_timezone"`
+	Priority    string     `json:"priority" enums:"low,medium,high,urgent"`
+	Tags        []string   `json:"tags"`
+	ParentID    *int       `json:"parent_id"`
+	Recurrence  string     `json:"recurrence" example:"FREQ=WEEKLY;BYDAY=MO"`
 }
 
+// SubtaskDeletePolicy decides what DeleteTask does with the subtasks of a deleted task.
+var SubtaskDeletePolicy = utils.DetachChildren
+
 // @Summary		Get tasks with pagination, sorting, and filtering
-// @Description	Get tasks with pagination, sorting by status/created_at, and filtering by status
+// @Description	Get tasks with pagination, sorting by status/created_at/due_at/priority, and filtering by status, priority, due date, tags and filter expressions. With a cursor, an empty one for the first page, the response is a page with the cursors of the next and previous pages, which neither skips nor repeats tasks added or removed while paging; pass the same filters with every cursor. Without one, the legacy page number selects the tasks, and the response is an array, or with "Prefer: envelope" a page with the total of tasks passing the filters. The Link header points to the other pages
 // @Tags			Tasks
 // @Accept			application/json
 // @Produce		application/json
 // @Security		JWT
-// @Param			page	query		int		false	"Page number"
-// @Param			limit	query		int		false	"Items per page"
-// @Param			sort_by	query		string	false	"Sort by title/status/description/created_at"
-// @Param			order	query		string	false	"Sort order: asc/desc"
-// @Param			status	query		string	false	"Filter by task status"
+// @Param			cursor		query		string	false	"Cursor of the page to read, empty for the first page"
+// @Param			page		query		int		false	"Page number, when reading without a cursor"
+// @Param			limit		query		int		false	"Items per page, at most 100"
+// @Param			sort_by		query		string	false	"Sort by title/status/description/created_at/due_at/priority, defaults to the user's preference"
+// @Param			order		query		string	false	"Sort order: asc/desc, defaults to the user's preference"
+// @Param			status		query		string	false	"Filter by task status"
+// @Param			priority	query		string	false	"Filter by task priority: low/medium/high/urgent"
+// @Param			overdue		query		bool	false	"Filter tasks past their due date that are not done"
+// @Param			due_before	query		string	false	"Filter tasks due before an RFC 3339 time or YYYY-MM-DD date"
+// @Param			due_after	query		string	false	"Filter tasks due after an RFC 3339 time or YYYY-MM-DD date"
+// @Param			tag			query		[]string	false	"Filter by tag name, repeatable"	collectionFormat(multi)
+// @Param			tag_mode	query		string	false	"Match any (default) or all of the given tags"
+// @Param			filter		query		string	false	"Filter expression, e.g. status in (todo, \"in progress\") and title ~ invoice"
+// @Param			Prefer			header		string	false	"envelope, to get pages read by number in a TaskPage"
+// @Param			If-None-Match	header		string	false	"ETag of a cached response"
 // @Success		200		{array}		utils.Task
+// @Success		200		{object}	TaskPage	"Page read with a cursor, or with Prefer: envelope"
+// @Header			200		{string}	ETag	"Entity tag of the response"
+// @Header			200		{string}	Link	"Links to the first, previous, next and last pages"
+// @Success		304		"Not modified"
 // @Failure		400		{object}	object{error=string}	"Error Message"
 // @Failure		500		{object}	object{error=string}	"Internal Server Error"
-// @Failure		500		{object}	object{error=string}	"Failed to fetch tasks:Error"
+// @Failure		500		{object}	object{error=string}	"Failed to fetch tasks"
+// @Failure		503		{object}	object{error=string}	"Request cancelled"
+// @Failure		504		{object}	object{error=string}	"Request timed out"
 // @Router			/api/v1/tasks [get]
 func GetTasks(c *gin.Context) {
-	page, limit, sortBy, order, status, err := extractPaginationParams(c)
+	page, limit, sortBy, order, filter, err := extractPaginationParams(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	cursor, withCursor, err := extractCursor(c)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
@@ -47,46 +87,315 @@ func GetTasks(c *gin.Context) {
 	}
 	s, _ := c.Get("db")
 	db := s.(utils.Storage)
-	tasks, err := db.GetTasksWithParams(userId.(int), page, limit, sortBy, order, status)
+	// Lists that do not ask for an order are sorted the way the user prefers
+	if cursor != nil {
+		sortBy, order = cursor.SortBy, cursor.Order
+	} else if c.Query("sort_by") == "" || c.Query("order") == "" {
+		profile, err := db.GetProfile(c.Request.Context(), userId.(int))
+		if err != nil {
+			helpers.RespondStorageError(c, err, 500, "Failed to fetch tasks")
+			return
+		}
+		if c.Query("sort_by") == "" {
+			sortBy = profile.DefaultSortBy
+		}
+		if c.Query("order") == "" {
+			order = profile.DefaultSortOrder
+		}
+	}
+	if withCursor {
+		respondTaskPage(c, db, userId.(int), cursor, limit, sortBy, order, filter)
+		return
+	}
+	tasks, err := db.GetTasksWithParams(c.Request.Context(), userId.(int), page, limit, sortBy, order, filter)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to fetch tasks" + err.Error()})
+		helpers.RespondStorageError(c, err, 500, "Failed to fetch tasks")
 		return
 	}
 
-	c.JSON(200, tasks)
+	respondTaskList(c, db, userId.(int), tasks, page, limit, filter)
 }
 
 // Extract parameters for pagination, sorting, and filtering
-func extractPaginationParams(c *gin.Context) (int, int, string, string, string, error) {
-	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
-	if err != nil || page <= 0 {
-		return 0, 0, "", "", "", errors.New("invalid page number")
-	}
+func extractPaginationParams(c *gin.Context) (int, int, string, string, utils.TaskFilter, error) {
+	var filter utils.TaskFilter
 
-	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	if err != nil || limit <= 0 {
-		return 0, 0, "", "", "", errors.New("invalid limit")
+	page, limit, err := extractPageParams(c)
+	if err != nil {
+		return 0, 0, "", "", filter, err
 	}
 
-	sortBy := c.DefaultQuery("sort_by", "created_at")
-	order := c.DefaultQuery("order", "desc")
-	status := c.DefaultQuery("status", "")
+	sortBy := c.DefaultQuery("sort_by", utils.DefaultProfile.DefaultSortBy)
+	order := strings.ToLower(c.DefaultQuery("order", utils.DefaultProfile.DefaultSortOrder))
+	filter.Status = c.DefaultQuery("status", "")
+	filter.Priority = c.DefaultQuery("priority", "")
 
 	validSortOptions := map[string]bool{
 		"title":       true,
 		"description": true,
 		"status":      true,
 		"created_at":  true,
+		"due_at":      true,
+		"priority":    true,
 	}
 	if !validSortOptions[sortBy] {
-		return 0, 0, "", "", "", errors.New("invalid sort option")
+		return 0, 0, "", "", filter, errors.New("invalid sort option")
+	}
+	if filter.Priority != "" && !isTaskPriority(filter.Priority) {
+		return 0, 0, "", "", filter, errors.New("invalid priority")
+	}
+	if order != "asc" && order != "desc" {
+		return 0, 0, "", "", filter, errors.New("invalid sort order")
+	}
+
+	if value := c.Query("overdue"); value != "" {
+		overdue, err := strconv.ParseBool(value)
+		if err != nil {
+			return 0, 0, "", "", filter, errors.New("invalid overdue flag")
+		}
+		filter.Overdue = &overdue
+	}
+	filter.Tags = c.QueryArray("tag")
+	switch c.DefaultQuery("tag_mode", "any") {
+	case "any":
+	case "all":
+		filter.AllTags = true
+	default:
+		return 0, 0, "", "", filter, errors.New("invalid tag mode")
+	}
+
+	if value := c.Query("due_before"); value != "" {
+		dueBefore, err := parseDueDate(value)
+		if err != nil {
+			return 0, 0, "", "", filter, errors.New("invalid due_before date")
+		}
+		filter.DueBefore = &dueBefore
+	}
+	if value := c.Query("due_after"); value != "" {
+		dueAfter, err := parseDueDate(value)
+		if err != nil {
+			return 0, 0, "", "", filter, errors.New("invalid due_after date")
+		}
+		filter.DueAfter = &dueAfter
+	}
+	if value := c.Query("filter"); value != "" {
+		if len(value) > maxFilterLength {
+			return 0, 0, "", "", filter, fmt.Errorf("filter must be at most %d characters", maxFilterLength)
+		}
+		expr, err := filterexpr.Parse(value, utils.TaskFilterFields)
+		if err != nil {
+			return 0, 0, "", "", filter, errors.New("invalid filter: " + err.Error())
+		}
+		filter.Expression = expr
+	}
+
+	return page, limit, sortBy, order, filter, nil
+}
+
+// maxPageLimit bounds the items of one page.
+const maxPageLimit = 100
+
+// maxFilterLength bounds the filter expressions of task lists.
+const maxFilterLength = 1000
+
+// TaskPage is a page of tasks. Pages read by number tell the total of tasks
+// passing the filters. Pages read with a cursor have the cursors of the next
+// and previous pages instead, which are left out at the ends of the list.
+type TaskPage struct {
+	Items      []utils.Task `json:"items"`
+	Total      *int         `json:"total,omitempty"`
+	Page       int          `json:"page,omitempty"`
+	Limit      int          `json:"limit"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	PrevCursor string       `json:"prev_cursor,omitempty"`
+}
+
+// pageCursor is what the cursors handed out hold: a place in the list and
+// the order the list is sorted in.
+type pageCursor struct {
+	SortBy   string `json:"s"`
+	Order    string `json:"o"`
+	ID       int    `json:"i"`
+	Key      string `json:"k"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// extractCursor returns the cursor of the request, which is nil for the
+// first page. withCursor tells whether the request reads by cursor at all.
+func extractCursor(c *gin.Context) (cursor *pageCursor, withCursor bool, err error) {
+	value, withCursor := c.GetQuery("cursor")
+	if !withCursor {
+		return nil, false, nil
+	}
+	if c.Query("page") != "" {
+		return nil, false, errors.New("page cannot be combined with a cursor")
+	}
+	if value == "" {
+		return nil, true, nil
+	}
+	cursor = &pageCursor{}
+	if err := helpers.ParseCursor(value, cursor); err != nil {
+		return nil, false, errors.New("invalid cursor")
+	}
+	// The cursor is only meaningful in the order it was made for
+	if sortBy := c.Query("sort_by"); sortBy != "" && sortBy != cursor.SortBy {
+		return nil, false, errors.New("cursor was made for another sort option")
+	}
+	if order := c.Query("order"); order != "" && strings.ToLower(order) != cursor.Order {
+		return nil, false, errors.New("cursor was made for another sort order")
+	}
+	return cursor, true, nil
+}
+
+// respondTaskPage writes the page of the user's tasks at cursor, or the first
+// page without one.
+func respondTaskPage(c *gin.Context, db utils.Storage, userID int, cursor *pageCursor, limit int, sortBy, order string, filter utils.TaskFilter) {
+	var at *utils.TaskCursor
+	backward := false
+	if cursor != nil {
+		at = &utils.TaskCursor{ID: cursor.ID, Key: cursor.Key, Backward: cursor.Backward}
+		backward = cursor.Backward
+	}
+	// One task more tells whether the list goes on past the page
+	tasks, err := db.GetTasksByCursor(c.Request.Context(), userID, at, limit+1, sortBy, order, filter)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidCursor) {
+			c.JSON(400, gin.H{"error": "invalid cursor"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Failed to fetch tasks")
+		return
+	}
+	more := len(tasks) > limit
+	if more && backward {
+		tasks = tasks[1:]
+	} else if more {
+		tasks = tasks[:limit]
+	}
+
+	page := TaskPage{Items: tasks, Limit: limit}
+	sign := func(task utils.Task, backward bool) (string, error) {
+		place := utils.TaskCursorAt(task, sortBy, backward)
+		return helpers.SignCursor(pageCursor{SortBy: sortBy, Order: order, ID: place.ID, Key: place.Key, Backward: place.Backward})
+	}
+	if len(tasks) > 0 && (more || backward) {
+		if page.NextCursor, err = sign(tasks[len(tasks)-1], false); err != nil {
+			c.JSON(500, gin.H{"error": "Internal Server Error"})
+			return
+		}
+	}
+	if len(tasks) > 0 && ((more && backward) || (cursor != nil && !backward)) {
+		if page.PrevCursor, err = sign(tasks[0], true); err != nil {
+			c.JSON(500, gin.H{"error": "Internal Server Error"})
+			return
+		}
+	}
+	links := []pageLink{{"first", pageURL(c, "cursor", "")}}
+	if page.PrevCursor != "" {
+		links = append(links, pageLink{"prev", pageURL(c, "cursor", page.PrevCursor)})
+	}
+	if page.NextCursor != "" {
+		links = append(links, pageLink{"next", pageURL(c, "cursor", page.NextCursor)})
+	}
+	setLinks(c, links)
+	helpers.RespondWithETag(c, 200, page)
+}
+
+// respondTaskList writes a page of the user's tasks read by number, as an
+// array or, for requests preferring it, in a TaskPage. The Link header
+// points to the first, previous, next and last pages either way.
+func respondTaskList(c *gin.Context, db utils.Storage, userID int, tasks []utils.Task, page, limit int, filter utils.TaskFilter) {
+	total, err := db.CountTasks(c.Request.Context(), userID, filter)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Failed to fetch tasks")
+		return
+	}
+	last := (total + limit - 1) / limit
+	if last < 1 {
+		last = 1
 	}
+	links := []pageLink{{"first", pageURL(c, "page", "1")}}
+	if page > 1 {
+		prev := page - 1
+		if prev > last {
+			prev = last
+		}
+		links = append(links, pageLink{"prev", pageURL(c, "page", strconv.Itoa(prev))})
+	}
+	if page < last {
+		links = append(links, pageLink{"next", pageURL(c, "page", strconv.Itoa(page+1))})
+	}
+	links = append(links, pageLink{"last", pageURL(c, "page", strconv.Itoa(last))})
+	setLinks(c, links)
+
+	if !prefersEnvelope(c) {
+		helpers.RespondWithETag(c, 200, tasks)
+		return
+	}
+	c.Header("Preference-Applied", "envelope")
+	helpers.RespondWithETag(c, 200, TaskPage{Items: tasks, Total: &total, Page: page, Limit: limit})
+}
+
+// prefersEnvelope reports whether the request asks for lists in a TaskPage
+// with "Prefer: envelope" (RFC 7240).
+func prefersEnvelope(c *gin.Context) bool {
+	for _, header := range c.Request.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(preference, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "envelope") {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	return page, limit, sortBy, order, status, nil
+// pageLink is a link of the Link header (RFC 8288) to another page.
+type pageLink struct {
+	rel, url string
+}
+
+// pageURL returns the URL of the request for another page: with the query
+// parameter name set to value, and the one selecting pages the other way
+// removed.
+func pageURL(c *gin.Context, name, value string) string {
+	query := c.Request.URL.Query()
+	query.Del("page")
+	query.Del("cursor")
+	query.Set(name, value)
+	return c.Request.URL.Path + "?" + query.Encode()
+}
+
+// setLinks sets the Link header.
+func setLinks(c *gin.Context, links []pageLink) {
+	values := make([]string, len(links))
+	for i, link := range links {
+		values[i] = fmt.Sprintf("<%s>; rel=\"%s\"", link.url, link.rel)
+	}
+	c.Header("Link", strings.Join(values, ", "))
+}
+
+// isTaskPriority reports whether priority is one of utils.TaskPriorities.
+func isTaskPriority(priority string) bool {
+	for _, p := range utils.TaskPriorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDueDate accepts an RFC 3339 time or a plain date, which is taken as
+// midnight UTC.
+func parseDueDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
 }
 
 // @Summary		Create a task
-// @Description	Create a new task
+// @Description	Create a new task. A task due without a due_timezone takes the user's timezone
 // @Tags			Tasks
 // @Accept			application/json
 // @Security		JWT
@@ -94,7 +403,10 @@ func extractPaginationParams(c *gin.Context) (int, int, string, string, string,
 // @Success		200			{object}	object{message=string}	"Task created successfully"
 // @Failure		400			{object}	object{error=string}	"Invalid JSON"
 // @Failure		400			{object}	object{error=string}	"Validation Error"
+// @Failure		400			{object}	object{error=string}	"Parent task not found"
 // @Failure		500			{object}	object{error=string}	"Internal Server Error"
+// @Failure		503			{object}	object{error=string}	"Request cancelled"
+// @Failure		504			{object}	object{error=string}	"Request timed out"
 // @Router			/api/v1/tasks [post]
 func CreateTask(c *gin.Context) {
 	var task models.Task
@@ -119,13 +431,27 @@ func CreateTask(c *gin.Context) {
 		Description: task.Description,
 		Status:      task.Status,
 		UserID:      userId.(int),
+		DueAt:       task.DueAt,
+		DueTimezone: task.DueTimezone,
+		Priority:    task.Priority,
+		Tags:        task.Tags,
+		ParentID:    task.ParentID,
+		Recurrence:  task.Recurrence,
 	}
 
 	s, _ := c.Get("db")
 	db := s.(utils.Storage)
-	taskId, err := db.CreateTask(newTask)
+	if _, err := defaultDueTimezone(c.Request.Context(), db, userId.(int), &newTask, nil); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	taskId, err := db.CreateTask(c.Request.Context(), newTask)
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Internal server error"})
+		if errors.Is(err, utils.ErrTaskNotFound) {
+			c.JSON(400, gin.H{"error": "Parent task not found"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
 		return
 	}
 	c.Set("task_id", taskId)
@@ -137,10 +463,15 @@ func CreateTask(c *gin.Context) {
 // @Tags			Tasks
 // @Produce		application/json
 // @Security		JWT
-// @Param			id	path		int	true	"Task ID"
+// @Param			id				path		int		true	"Task ID"
+// @Param			If-None-Match	header		string	false	"ETag of a cached response"
 // @Success		200	{object}	utils.Task
+// @Header			200	{string}	ETag	"Entity tag of the task"
+// @Success		304	"Not modified"
 // @Failure		404	{object}	object{error=string}	"Task not found"
 // @Failure		500	{object}	object{error=string}	"Internal Server Error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
 // @Router			/api/v1/tasks/{id} [get]
 func GetTaskByID(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -156,27 +487,34 @@ func GetTaskByID(c *gin.Context) {
 	}
 	s, _ := c.Get("db")
 	db := s.(utils.Storage)
-	task, err := db.GetTaskByID(userId.(int), id)
+	task, err := db.GetTaskByID(c.Request.Context(), userId.(int), id)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
+		helpers.RespondStorageError(c, err, 404, "Task not found")
 		return
 	}
-	c.JSON(200, task)
+	helpers.RespondWithVersion(c, 200, task.ID, task.Version, task)
 }
 
 // @Summary		Update a task
-// @Description	Update an existing task by ID
+// @Description	Update an existing task by ID. A task cannot be marked done while any of its blockers is not done. Marking a recurring task done creates its next occurrence. The parent task is kept: a parent_id other than the current one is rejected, move tasks with PATCH or PUT /api/v1/tasks/{id}/parent instead
 // @Tags			Tasks
 // @Accept			application/json
 // @Security		JWT
-// @Param			id			path		int						true	"Task ID"
-// @Param			TaskDetails	body		TaskDetails				true	"Updated Task Details"
-// @Success		200			{object}	object{message=string}	"Task updated successfully"
-// @Failure		400			{object}	object{error=string}	"Invalid JSON"
-// @Failure		400			{object}	object{error=string}	"Invalid Task Id"
-// @Failure		404			{object}	object{error=string}	"Task not found"
+// @Param			id			path		int										true	"Task ID"
+// @Param			TaskDetails	body		TaskDetails								true	"Updated Task Details"
+// @Param			If-Match	header		string									false	"ETag the task must still have"
+// @Success		200			{object}	object{message=string}					"Task updated successfully"
+// @Header			200			{string}	ETag									"Entity tag of the updated task"
+// @Failure		400			{object}	object{error=string}					"Invalid JSON"
+// @Failure		400			{object}	object{error=string}					"Invalid Task Id"
+// @Failure		400			{object}	object{error=string}					"parent_id cannot be changed with PUT"
+// @Failure		404			{object}	object{error=string}					"Task not found"
+// @Failure		409			{object}	object{error=string,blocked_by=[]int}	"Task is blocked"
+// @Failure		412			{object}	object{error=string}					"Task was changed by another request"
 // @Failure		500			{object}	object{error=string}	"Internal Server Error"
 // @Failure		500			{object}	object{error=string}	"Failed to update task"
+// @Failure		503			{object}	object{error=string}	"Request cancelled"
+// @Failure		504			{object}	object{error=string}	"Request timed out"
 // @Router			/api/v1/tasks/{id} [put]
 func UpdateTask(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -203,8 +541,19 @@ func UpdateTask(c *gin.Context) {
 
 	s, _ := c.Get("db")
 	db := s.(utils.Storage)
-	if _, err := db.GetTaskByID(userId.(int), id); err != nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
+	current, err := db.GetTaskByID(c.Request.Context(), userId.(int), id)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 404, "Task not found")
+		return
+	}
+	// PUT cannot tell a missing parent_id from null, so moves go through
+	// PATCH or the move endpoint, which check for cycles
+	if updatedTask.ParentID != nil && (current.ParentID == nil || *current.ParentID != *updatedTask.ParentID) {
+		c.JSON(400, gin.H{"error": "parent_id cannot be changed with PUT, use PATCH /api/v1/tasks/{id} or PUT /api/v1/tasks/{id}/parent"})
+		return
+	}
+	version, ok := checkIfMatch(c, current)
+	if !ok {
 		return
 	}
 
@@ -212,26 +561,315 @@ func UpdateTask(c *gin.Context) {
 		Title:       updatedTask.Title,
 		Description: updatedTask.Description,
 		Status:      updatedTask.Status,
+		DueAt:       updatedTask.DueAt,
+		DueTimezone: updatedTask.DueTimezone,
+		Priority:    updatedTask.Priority,
+		Tags:        updatedTask.Tags,
+		Recurrence:  updatedTask.Recurrence,
+		Version:     version,
+	}
+	if _, err := defaultDueTimezone(c.Request.Context(), db, userId.(int), &task, nil); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
 	}
 
-	if err = db.UpdateTaskByID(userId.(int), id, task); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to update task"})
+	if err = db.UpdateTaskByID(c.Request.Context(), userId.(int), id, task); err != nil {
+		var blocked *utils.BlockedError
+		switch {
+		case errors.As(err, &blocked):
+			c.JSON(409, gin.H{"error": "Task is blocked", "blocked_by": blocked.Blockers})
+		case errors.Is(err, utils.ErrVersionMismatch):
+			c.JSON(412, gin.H{"error": "Task was changed by another request"})
+		case errors.Is(err, utils.ErrTaskNotFound):
+			c.JSON(404, gin.H{"error": "Task not found"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Failed to update task")
+		}
 		return
 	}
+	if updated, err := db.GetTaskByID(c.Request.Context(), userId.(int), id); err == nil {
+		c.Header("ETag", helpers.VersionETag(updated.ID, updated.Version))
+	}
 	c.JSON(200, gin.H{"message": "Task updated successfully"})
 }
 
+// checkIfMatch answers 412 Precondition Failed unless the request's If-Match
+// header allows changing the task as it is now. It returns the version the
+// change must be made against, or 0 for an unconditional change.
+func checkIfMatch(c *gin.Context, current utils.Task) (int, bool) {
+	ok, conditional := helpers.IfMatch(c, helpers.VersionETag(current.ID, current.Version))
+	if !ok {
+		c.JSON(412, gin.H{"error": "Task was changed by another request"})
+		return 0, false
+	}
+	if !conditional {
+		return 0, true
+	}
+	return current.Version, true
+}
+
+// defaultDueTimezone gives a task due without a timezone the user's, so its
+// due date is shown and recurs in their local time. For a patch, fields are
+// the sorted fields it changes: only a patch of the due date or timezone gets
+// the default, and due_timezone is added to the returned fields.
+func defaultDueTimezone(ctx context.Context, db utils.Storage, userID int, task *utils.Task, fields []string) ([]string, error) {
+	if task.DueAt == nil || task.DueTimezone != "" {
+		return fields, nil
+	}
+	hasField := func(field string) bool {
+		i := sort.SearchStrings(fields, field)
+		return i < len(fields) && fields[i] == field
+	}
+	if fields != nil && !hasField("due_at") && !hasField("due_timezone") {
+		return fields, nil
+	}
+	profile, err := db.GetProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	task.DueTimezone = profile.Timezone
+	if fields != nil && !hasField("due_timezone") {
+		fields = append(fields, "due_timezone")
+		sort.Strings(fields)
+	}
+	return fields, nil
+}
+
+// taskDetails returns the fields of a task that PatchTask can change.
+func taskDetails(task utils.Task) TaskDetails {
+	tags := task.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return TaskDetails{
+		Title:       task.Title,
+		Description: task.Description,
+		Status:      task.Status,
+		DueAt:       task.DueAt,
+		DueTimezone: task.DueTimezone,
+		Priority:    task.Priority,
+		Tags:        tags,
+		ParentID:    task.ParentID,
+		Recurrence:  task.Recurrence,
+	}
+}
+
+// model returns the details as a task to validate.
+func (d TaskDetails) model() models.Task {
+	return models.Task{
+		Title:       d.Title,
+		Description: d.Description,
+		Status:      d.Status,
+		DueAt:       d.DueAt,
+		DueTimezone: d.DueTimezone,
+		Priority:    d.Priority,
+		Tags:        d.Tags,
+		ParentID:    d.ParentID,
+		Recurrence:  d.Recurrence,
+	}
+}
+
+// task returns the details as a task to store.
+func (d TaskDetails) task() utils.Task {
+	return utils.Task{
+		Title:       d.Title,
+		Description: d.Description,
+		Status:      d.Status,
+		DueAt:       d.DueAt,
+		DueTimezone: d.DueTimezone,
+		Priority:    d.Priority,
+		Tags:        d.Tags,
+		ParentID:    d.ParentID,
+		Recurrence:  d.Recurrence,
+	}
+}
+
+// patchTaskDetails applies a patch to the editable fields of a task. It
+// returns them, still to be validated, with the names of the changed fields.
+// Patches adding unknown fields are rejected.
+func patchTaskDetails(current utils.Task, apply func(document, patch []byte) ([]byte, error), patch []byte) (TaskDetails, []string, error) {
+	document, err := json.Marshal(taskDetails(current))
+	if err != nil {
+		return TaskDetails{}, nil, err
+	}
+	patched, err := apply(document, patch)
+	if err != nil {
+		return TaskDetails{}, nil, err
+	}
+	var details TaskDetails
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&details); err != nil {
+		return TaskDetails{}, nil, err
+	}
+	fields, err := changedTaskFields(document, patched)
+	if err != nil {
+		return TaskDetails{}, nil, err
+	}
+	return details, fields, nil
+}
+
+// changedTaskFields returns the sorted names of the fields that differ
+// between two task documents, a missing field being null.
+func changedTaskFields(before, after []byte) ([]string, error) {
+	var previous, next map[string]json.RawMessage
+	if err := json.Unmarshal(before, &previous); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &next); err != nil {
+		return nil, err
+	}
+	fields := make([]string, 0)
+	for field, value := range previous {
+		changed, ok := next[field]
+		if !ok {
+			changed = json.RawMessage("null")
+		}
+		if !jsonpatch.Equal(value, changed) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// @Summary		Partially update a task
+// @Description	Change some fields of a task by ID, with a JSON Merge Patch (RFC 7396) sent as application/merge-patch+json or application/json, or a JSON Patch (RFC 6902) sent as application/json-patch+json. The patch applies to the task's title, description, status, due_at, due_timezone, priority, tags, parent_id and recurrence, and the result must be a valid task. Only the changed fields are written. A task cannot be marked done while any of its blockers is not done. Marking a recurring task done creates its next occurrence
+// @Tags			Tasks
+// @Accept			application/merge-patch+json
+// @Accept			application/json-patch+json
+// @Accept			application/json
+// @Produce		application/json
+// @Security		JWT
+// @Param			id			path		int										true	"Task ID"
+// @Param			TaskPatch	body		TaskDetails								true	"Fields to change, or a JSON Patch array of operations on them"
+// @Param			If-Match	header		string									false	"ETag the task must still have"
+// @Success		200			{object}	utils.Task
+// @Header			200			{string}	ETag									"Entity tag of the updated task"
+// @Failure		400			{object}	object{error=string}					"Invalid patch"
+// @Failure		400			{object}	object{error=string}					"Invalid Task Id"
+// @Failure		400			{object}	object{error=string}					"Validation Error"
+// @Failure		400			{object}	object{error=string}					"Parent task not found"
+// @Failure		404			{object}	object{error=string}					"Task not found"
+// @Failure		409			{object}	object{error=string}					"Patch test failed"
+// @Failure		409			{object}	object{error=string}					"Task cannot be moved under itself or its subtasks"
+// @Failure		409			{object}	object{error=string,blocked_by=[]int}	"Task is blocked"
+// @Failure		412			{object}	object{error=string}					"Task was changed by another request"
+// @Failure		415			{object}	object{error=string}					"Unsupported patch format"
+// @Failure		500			{object}	object{error=string}					"Internal Server Error"
+// @Failure		500			{object}	object{error=string}					"Failed to update task"
+// @Failure		503			{object}	object{error=string}					"Request cancelled"
+// @Failure		504			{object}	object{error=string}					"Request timed out"
+// @Router			/api/v1/tasks/{id} [patch]
+func PatchTask(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Task Id"})
+		return
+	}
+	var apply func(document, patch []byte) ([]byte, error)
+	switch c.ContentType() {
+	case "application/merge-patch+json", "application/json":
+		apply = jsonpatch.MergePatch
+	case "application/json-patch+json":
+		apply = jsonpatch.Apply
+	default:
+		c.JSON(415, gin.H{"error": "Unsupported patch format"})
+		return
+	}
+	patch, err := c.GetRawData()
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid patch"})
+		return
+	}
+
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	current, err := db.GetTaskByID(c.Request.Context(), userId.(int), id)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 404, "Task not found")
+		return
+	}
+	version, ok := checkIfMatch(c, current)
+	if !ok {
+		return
+	}
+	details, fields, err := patchTaskDetails(current, apply, patch)
+	if err != nil {
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			c.JSON(409, gin.H{"error": "Patch test failed"})
+			return
+		}
+		c.JSON(400, gin.H{"error": "Invalid patch: " + err.Error()})
+		return
+	}
+	updatedTask := details.model()
+	if err := updatedTask.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(fields) == 0 {
+		helpers.RespondWithVersion(c, 200, current.ID, current.Version, current)
+		return
+	}
+
+	task := details.task()
+	task.Version = version
+	if fields, err = defaultDueTimezone(c.Request.Context(), db, userId.(int), &task, fields); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
+	}
+	if err := db.PatchTask(c.Request.Context(), userId.(int), id, task, fields); err != nil {
+		i := sort.SearchStrings(fields, "parent_id")
+		moved := i < len(fields) && fields[i] == "parent_id" && details.ParentID != nil
+		var blocked *utils.BlockedError
+		switch {
+		case errors.As(err, &blocked):
+			c.JSON(409, gin.H{"error": "Task is blocked", "blocked_by": blocked.Blockers})
+		case errors.Is(err, utils.ErrTaskCycle):
+			c.JSON(409, gin.H{"error": "Task cannot be moved under itself or its subtasks"})
+		case errors.Is(err, utils.ErrVersionMismatch):
+			c.JSON(412, gin.H{"error": "Task was changed by another request"})
+		case errors.Is(err, utils.ErrTaskNotFound) && moved:
+			c.JSON(400, gin.H{"error": "Parent task not found"})
+		case errors.Is(err, utils.ErrTaskNotFound):
+			c.JSON(404, gin.H{"error": "Task not found"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Failed to update task")
+		}
+		return
+	}
+
+	updated, err := db.GetTaskByID(c.Request.Context(), userId.(int), id)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
+	}
+	helpers.RespondWithVersion(c, 200, updated.ID, updated.Version, updated)
+}
+
 // @Summary		Delete a task
-// @Description	Delete a task by ID
+// @Description	Delete a task by ID. Its subtasks are detached, moved to its parent, deleted as well or keep the task from being deleted, depending on the server's SUBTASK_DELETE_POLICY
 // @Tags			Tasks
 // @Accept			application/json
 // @Security		JWT
-// @Param			id	path		int						true	"Task ID"
+// @Param			id			path		int						true	"Task ID"
+// @Param			If-Match	header		string					false	"ETag the task must still have"
 // @Success		200	{object}	object{message=string}	"Task deleted successfully"
 // @Failure		400	{object}	object{error=string}	"Invalid Task Id"
 // @Failure		404	{object}	object{error=string}	"Task not found"
+// @Failure		409	{object}	object{error=string}	"Task has subtasks"
+// @Failure		412	{object}	object{error=string}	"Task was changed by another request"
 // @Failure		500	{object}	object{error=string}	"Internal Server Error"
 // @Failure		500	{object}	object{error=string}	"Failed to delete task"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
 // @Router			/api/v1/tasks/{id} [delete]
 func DeleteTask(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -247,77 +885,190 @@ func DeleteTask(c *gin.Context) {
 
 	s, _ := c.Get("db")
 	db := s.(utils.Storage)
-	if _, err := db.GetTaskByID(userId.(int), id); err != nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
+	current, err := db.GetTaskByID(c.Request.Context(), userId.(int), id)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 404, "Task not found")
 		return
 	}
-	if err := db.DeleteTask(userId.(int), id); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to delete task"})
+	version, ok := checkIfMatch(c, current)
+	if !ok {
+		return
+	}
+	if err := db.DeleteTask(c.Request.Context(), userId.(int), id, version, SubtaskDeletePolicy); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrTaskHasChildren):
+			c.JSON(409, gin.H{"error": "Task has subtasks"})
+		case errors.Is(err, utils.ErrVersionMismatch):
+			c.JSON(412, gin.H{"error": "Task was changed by another request"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Failed to delete task")
+		}
 		return
 	}
 	c.JSON(200, gin.H{"message": "Task deleted successfully"})
 }
 
-// MarkTasksDoneConcurrently marks multiple tasks as done concurrently
+// markDoneWorkers bounds how many tasks MarkTasksDone marks done at once.
+const markDoneWorkers = 8
+
+// maxMarkDoneTasks bounds the task IDs of one MarkTasksDone request.
+const maxMarkDoneTasks = 1000
+
+// MarkDoneResult is the outcome of marking one of the requested tasks done.
+// In atomic mode, tasks left unchanged because others failed are rolled_back.
+type MarkDoneResult struct {
+	ID        string `json:"id"`
+	Status    string `json:"status" enums:"updated,not_found,invalid_id,blocked,error,rolled_back"`
+	BlockedBy []int  `json:"blocked_by,omitempty"`
+}
+
+// MarkDoneResponse lists the outcome for each requested task, in the order
+// of the request.
+type MarkDoneResponse struct {
+	Updated int              `json:"updated"`
+	Results []MarkDoneResult `json:"results"`
+}
+
+// markDoneResult returns the outcome of a task from the error marking it done.
+func markDoneResult(id string, err error) MarkDoneResult {
+	var blocked *utils.BlockedError
+	switch {
+	case err == nil:
+		return MarkDoneResult{ID: id, Status: "updated"}
+	case errors.As(err, &blocked):
+		return MarkDoneResult{ID: id, Status: "blocked", BlockedBy: blocked.Blockers}
+	case errors.Is(err, utils.ErrTaskNotFound):
+		return MarkDoneResult{ID: id, Status: "not_found"}
+	default:
+		return MarkDoneResult{ID: id, Status: "error"}
+	}
+}
+
+// MarkTasksDone marks multiple tasks as done
 //
-//	@Summary		Mark tasks as done concurrently
-//	@Description	Mark multiple tasks as done concurrently using Goroutines
+//	@Summary		Mark tasks as done
+//	@Description	Mark multiple tasks as done and report the outcome for each: updated, not_found, invalid_id, blocked with its remaining blockers, or error. Recurring tasks get their next occurrence created. Tasks are marked done independently, a few at a time, unless atomic is set: then either all of them are marked done in one transaction, or none is and the request fails with 409, the tasks that did not fail being rolled_back. In atomic mode tasks may be blocked by other tasks of the request
 //	@Tags			Tasks
 //	@Accept			application/json
 //	@Produce		application/json
 //	@Security		JWT
 //	@Param			task_ids	body		[]string	true	"Task IDs to mark as done"
-//	@Success		200			{object}	object{message=string,updated_tasks=[]string}
-//	@Failure		400			{object}	object{error=string}	"Invalid Task Id"
-//	@Failure		500			{object}	object{error=string}	"Internal Server Error"
-//	@Failure		500			{object}	object{error=string}	"Failed to Update task"
+//	@Param			atomic		query		bool		false	"Mark all tasks done or none"
+//	@Success		200			{object}	MarkDoneResponse
+//	@Failure		400			{object}	object{error=string}					"Invalid JSON"
+//	@Failure		400			{object}	object{error=string}					"Too many tasks"
+//	@Failure		409			{object}	object{error=string,results=[]MarkDoneResult}	"No tasks were marked done"
+//	@Failure		500			{object}	object{error=string}					"Internal Server Error"
+//	@Failure		500			{object}	object{error=string}					"Failed to update tasks"
+//	@Failure		503			{object}	object{error=string}					"Request cancelled"
+//	@Failure		504			{object}	object{error=string}					"Request timed out"
 //	@Router			/api/v1/tasks/mark-done [put]
-func MarkTasksDoneConcurrently(c *gin.Context) {
+func MarkTasksDone(c *gin.Context) {
+	atomic := false
+	if value := c.Query("atomic"); value != "" {
+		var err error
+		if atomic, err = strconv.ParseBool(value); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid atomic flag"})
+			return
+		}
+	}
 	var taskIDs []string
 	if err := c.BindJSON(&taskIDs); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid JSON"})
 		return
 	}
+	if len(taskIDs) > maxMarkDoneTasks {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Too many tasks, at most %d can be marked done at once", maxMarkDoneTasks)})
+		return
+	}
 	userId, ok := c.Get("user_id")
 	if !ok {
 		c.JSON(500, gin.H{"error": "Internal Server Error"})
 		return
 	}
-	var wg sync.WaitGroup
-	resultCh := make(chan string)
 
-	// Launch Goroutines to mark tasks as done concurrently
-	for _, taskID := range taskIDs {
+	results := make([]MarkDoneResult, len(taskIDs))
+	ids := make([]int, len(taskIDs))
+	valid := make([]int, 0, len(taskIDs))
+	for i, taskID := range taskIDs {
+		id, err := strconv.Atoi(taskID)
+		if err != nil || id < 1 {
+			results[i] = MarkDoneResult{ID: taskID, Status: "invalid_id"}
+			continue
+		}
+		ids[i] = id
+		valid = append(valid, i)
+	}
+
+	ctx := c.Request.Context()
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if atomic {
+		markTasksDoneAtomically(c, db, userId.(int), taskIDs, ids, valid, results)
+		return
+	}
+
+	// A bounded pool of workers marks the tasks done, each filling in its
+	// own results
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < markDoneWorkers && w < len(valid); w++ {
 		wg.Add(1)
-		go func(id string) {
+		go func() {
 			defer wg.Done()
-			task_id, err := strconv.Atoi(id)
-			if err != nil {
-				c.JSON(400, gin.H{"error": "Invalid Task Id"})
-				return
+			for i := range jobs {
+				results[i] = markDoneResult(taskIDs[i], db.UpdateTaskStatusDone(ctx, userId.(int), ids[i]))
 			}
-
-			s, _ := c.Get("db")
-			db := s.(utils.Storage)
-			if err := db.UpdateTaskStatusDone(userId.(int), task_id); err != nil {
-				c.JSON(500, gin.H{"error": "Failed to Update task"})
-				return
-			}
-			resultCh <- id
-		}(taskID)
+		}()
+	}
+	for _, i := range valid {
+		jobs <- i
 	}
+	close(jobs)
+	wg.Wait()
 
-	// Wait for all Goroutines to finish
-	go func() {
-		wg.Wait()
-		close(resultCh)
-	}()
+	if err := ctx.Err(); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Failed to update tasks")
+		return
+	}
+	response := MarkDoneResponse{Results: results}
+	for _, result := range results {
+		if result.Status == "updated" {
+			response.Updated++
+		}
+	}
+	c.JSON(200, response)
+}
 
-	// Collect results from Goroutines
-	updatedTasks := make([]string, 0)
-	for id := range resultCh {
-		updatedTasks = append(updatedTasks, id)
+// markTasksDoneAtomically marks the tasks at the valid indexes done in one
+// transaction, or none when any task is invalid or cannot be marked done.
+func markTasksDoneAtomically(c *gin.Context, db utils.Storage, userID int, taskIDs []string, ids, valid []int, results []MarkDoneResult) {
+	var failed utils.TaskErrors
+	if len(valid) == len(taskIDs) {
+		validIDs := make([]int, 0, len(valid))
+		for _, i := range valid {
+			validIDs = append(validIDs, ids[i])
+		}
+		err := db.MarkTasksDone(c.Request.Context(), userID, validIDs)
+		if err == nil {
+			for _, i := range valid {
+				results[i] = MarkDoneResult{ID: taskIDs[i], Status: "updated"}
+			}
+			c.JSON(200, MarkDoneResponse{Updated: len(valid), Results: results})
+			return
+		}
+		if !errors.As(err, &failed) {
+			helpers.RespondStorageError(c, err, 500, "Failed to update tasks")
+			return
+		}
 	}
 
-	c.JSON(200, gin.H{"message": "Tasks marked as done concurrently", "updated_tasks": updatedTasks})
+	for _, i := range valid {
+		if err, ok := failed[ids[i]]; ok {
+			results[i] = markDoneResult(taskIDs[i], err)
+		} else {
+			results[i] = MarkDoneResult{ID: taskIDs[i], Status: "rolled_back"}
+		}
+	}
+	c.JSON(409, gin.H{"error": "No tasks were marked done", "results": results})
 }
@@ -1,10 +1,19 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/Parjun2000/task-manager/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -81,6 +90,35 @@ func TestUpdateTask(t *testing.T) {
 	assert.Equal(t, 400, recorder.Code)
 }
 
+// deletingStore deletes every task it has just read, as a concurrent request
+// could.
+type deletingStore struct {
+	*utils.MemoryDB
+}
+
+func (s deletingStore) GetTaskByID(ctx context.Context, userID, id int) (utils.Task, error) {
+	task, err := s.MemoryDB.GetTaskByID(ctx, userID, id)
+	if err == nil {
+		err = s.MemoryDB.DeleteTask(ctx, userID, id, 0, utils.DetachChildren)
+	}
+	return task, err
+}
+
+func TestUpdateDeletedTask(t *testing.T) {
+	router := newUserRouter(deletingStore{newTestStore()}, 1)
+	router.PUT("/tasks/:id", UpdateTask)
+
+	req, err := http.NewRequest("PUT", "/tasks/1", bytes.NewBufferString(`{"title":"renamed","description":"d","status":"todo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	assert.Equal(t, 404, recorder.Code)
+	assert.JSONEq(t, `{"error":"Task not found"}`, recorder.Body.String())
+}
+
 func TestDeleteTask(t *testing.T) {
 	// Create a DELETE route
 	testRouter.DELETE("/tasks/:id", DeleteTask)
@@ -98,9 +136,9 @@ func TestDeleteTask(t *testing.T) {
 	assert.Equal(t, 400, recorder.Code)
 }
 
-func TestMarkTasksDoneConcurrently(t *testing.T) {
+func TestMarkTasksDone(t *testing.T) {
 	// Create a PUT route
-	testRouter.PUT("/tasks/mark-done", MarkTasksDoneConcurrently)
+	testRouter.PUT("/tasks/mark-done", MarkTasksDone)
 
 	// This create a mock request to test the handler
 	req, err := http.NewRequest("PUT", "/tasks/mark-done", nil)
@@ -114,3 +152,510 @@ func TestMarkTasksDoneConcurrently(t *testing.T) {
 	// Assert the HTTP status code
 	assert.Equal(t, 400, recorder.Code)
 }
+
+func TestGetTasksTimeout(t *testing.T) {
+	// Create a router whose requests belong to user1
+	router := newUserRouter(newTestStore(), 1)
+	router.GET("/tasks", GetTasks)
+
+	// This create a mock request whose deadline has already passed
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "/tasks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	// Assert the HTTP status code
+	assert.Equal(t, 504, recorder.Code)
+}
+
+// brokenProfileStore fails to read profiles, as a database gone away would.
+type brokenProfileStore struct {
+	*utils.MemoryDB
+}
+
+func (s brokenProfileStore) GetProfile(ctx context.Context, userID int) (utils.Profile, error) {
+	return utils.Profile{}, errors.New("pq: connection refused")
+}
+
+func TestGetTasksStorageError(t *testing.T) {
+	router := newUserRouter(brokenProfileStore{newTestStore()}, 1)
+	router.GET("/tasks", GetTasks)
+
+	req, err := http.NewRequest("GET", "/tasks", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	// The database's error is not shown to the client
+	assert.Equal(t, 500, recorder.Code)
+	assert.JSONEq(t, `{"error":"Failed to fetch tasks"}`, recorder.Body.String())
+}
+
+func TestGetTasksFilters(t *testing.T) {
+	store := newTestStore()
+	dueAt := time.Now().Add(-time.Hour)
+	_, err := store.CreateTask(context.Background(), utils.Task{Title: "overdue", Status: "todo", UserID: 1, DueAt: &dueAt, Tags: []string{"work", "home"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newUserRouter(store, 1)
+	router.GET("/tasks", GetTasks)
+
+	tests := []struct {
+		query  string
+		code   int
+		titles []string
+	}{
+		{"?overdue=true", 200, []string{"overdue"}},
+		{"?overdue=false", 200, []string{"title1"}},
+		{"?sort_by=due_at&order=asc", 200, []string{"overdue", "title1"}},
+		{"?priority=medium&sort_by=priority", 200, []string{"title1", "overdue"}},
+		{"?priority=high", 200, []string{}},
+		{"?priority=someday", 400, nil},
+		{"?tag=work&tag=errands", 200, []string{"overdue"}},
+		{"?tag=work&tag=errands&tag_mode=all", 200, []string{}},
+		{"?tag=errands", 200, []string{}},
+		{"?tag=work&tag_mode=some", 400, nil},
+		{"?due_before=2000-01-01", 200, []string{}},
+		{"?due_after=2000-01-01T00:00:00Z", 200, []string{"overdue"}},
+		{"?overdue=maybe", 400, nil},
+		{"?due_before=yesterday", 400, nil},
+		{"?order=sideways", 400, nil},
+		{"?filter=" + url.QueryEscape(`status in (todo, "in progress") and title ~ OVER`), 200, []string{"overdue"}},
+		{"?filter=" + url.QueryEscape(`due_at = null or tags = home`) + "&sort_by=title&order=asc", 200, []string{"overdue", "title1"}},
+		{"?filter=" + url.QueryEscape(`not tags in (work)`), 200, []string{"title1"}},
+		{"?filter=" + url.QueryEscape(`title ~ over`) + "&status=done", 200, []string{}},
+		{"?filter=" + url.QueryEscape(`owner = me`), 400, nil},
+		{"?filter=" + url.QueryEscape(`title < b`), 400, nil},
+		{"?filter=" + url.QueryEscape(`title = "open`), 400, nil},
+		{"?filter=" + strings.Repeat("x", maxFilterLength+1), 400, nil},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/tasks"+tt.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		assert.Equal(t, tt.code, recorder.Code, tt.query)
+		if tt.titles != nil {
+			var tasks []utils.Task
+			assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &tasks))
+			titles := make([]string, 0)
+			for _, task := range tasks {
+				titles = append(titles, task.Title)
+			}
+			assert.Equal(t, tt.titles, titles, tt.query)
+		}
+	}
+}
+
+func TestGetTasksCursor(t *testing.T) {
+	store := newTestStore()
+	for _, title := range []string{"b", "d", "a", "c"} {
+		if _, err := store.CreateTask(context.Background(), utils.Task{Title: title, Status: "todo", UserID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := newUserRouter(store, 1)
+	router.GET("/tasks", GetTasks)
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/tasks"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	read := func(query string) ([]string, TaskPage) {
+		recorder := serve(query)
+		assert.Equal(t, 200, recorder.Code, query)
+		var page TaskPage
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+		titles := make([]string, 0)
+		for _, task := range page.Items {
+			titles = append(titles, task.Title)
+		}
+		return titles, page
+	}
+
+	// The cursors lead through the list and back
+	titles, page := read("?cursor=&sort_by=title&order=asc&limit=2")
+	assert.Equal(t, []string{"a", "b"}, titles)
+	assert.Empty(t, page.PrevCursor)
+	titles, page = read("?limit=2&cursor=" + url.QueryEscape(page.NextCursor))
+	assert.Equal(t, []string{"c", "d"}, titles)
+	titles, page = read("?limit=2&cursor=" + url.QueryEscape(page.NextCursor))
+	assert.Equal(t, []string{"title1"}, titles)
+	assert.Empty(t, page.NextCursor)
+	titles, page = read("?limit=2&cursor=" + url.QueryEscape(page.PrevCursor))
+	assert.Equal(t, []string{"c", "d"}, titles)
+	titles, page = read("?limit=2&cursor=" + url.QueryEscape(page.PrevCursor))
+	assert.Equal(t, []string{"a", "b"}, titles)
+	assert.Empty(t, page.PrevCursor)
+
+	// Without an order, the first page is sorted the way the user prefers
+	titles, _ = read("?cursor=&limit=2")
+	assert.Equal(t, []string{"c", "a"}, titles)
+
+	// Cursors cannot be made up or used for another order
+	next := url.QueryEscape(page.NextCursor)
+	assert.Equal(t, 400, serve("?cursor="+next+"x").Code)
+	assert.Equal(t, 400, serve("?cursor=e30.e30").Code)
+	assert.Equal(t, 400, serve("?sort_by=status&cursor="+next).Code)
+	assert.Equal(t, 400, serve("?order=desc&cursor="+next).Code)
+	assert.Equal(t, 400, serve("?page=2&cursor=").Code)
+	assert.Equal(t, 200, serve("?sort_by=title&order=asc&cursor="+next).Code)
+
+	// Pages are bounded either way
+	assert.Equal(t, 400, serve("?limit=101").Code)
+	assert.Equal(t, 400, serve("?cursor=&limit=101").Code)
+	assert.Equal(t, 200, serve("?limit=100").Code)
+}
+
+func TestGetTasksEnvelope(t *testing.T) {
+	store := newTestStore()
+	for _, title := range []string{"b", "c", "d", "e"} {
+		if _, err := store.CreateTask(context.Background(), utils.Task{Title: title, Status: "done", UserID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := newUserRouter(store, 1)
+	router.GET("/tasks", GetTasks)
+
+	serve := func(query string, header ...string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/tasks"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Arrays stay the default, with links to the other pages
+	recorder := serve("?page=2&limit=2&sort_by=title&order=asc")
+	assert.Equal(t, 200, recorder.Code)
+	var tasks []utils.Task
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &tasks))
+	assert.Len(t, tasks, 2)
+	assert.Equal(t, `</tasks?limit=2&order=asc&page=1&sort_by=title>; rel="first", `+
+		`</tasks?limit=2&order=asc&page=1&sort_by=title>; rel="prev", `+
+		`</tasks?limit=2&order=asc&page=3&sort_by=title>; rel="next", `+
+		`</tasks?limit=2&order=asc&page=3&sort_by=title>; rel="last"`, recorder.Header().Get("Link"))
+	assert.Empty(t, recorder.Header().Get("Preference-Applied"))
+
+	// The envelope counts the tasks passing the filters
+	recorder = serve("?page=2&limit=2&sort_by=title&order=asc", "Prefer", "envelope")
+	assert.Equal(t, 200, recorder.Code)
+	assert.Equal(t, "envelope", recorder.Header().Get("Preference-Applied"))
+	var page TaskPage
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	assert.Equal(t, 5, *page.Total)
+	assert.Equal(t, 2, page.Page)
+	assert.Equal(t, 2, page.Limit)
+	assert.Equal(t, "d", page.Items[0].Title)
+
+	recorder = serve("?status=done&limit=3", "Prefer", "respond-async, envelope")
+	page = TaskPage{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	assert.Equal(t, 4, *page.Total)
+	assert.Equal(t, `</tasks?limit=3&page=1&status=done>; rel="first", </tasks?limit=3&page=2&status=done>; rel="next", `+
+		`</tasks?limit=3&page=2&status=done>; rel="last"`, recorder.Header().Get("Link"))
+
+	// Pages past the end still lead back, and empty lists have one page
+	recorder = serve("?page=9&limit=2&status=done", "Prefer", "envelope")
+	assert.JSONEq(t, `{"items":[],"total":4,"page":9,"limit":2}`, recorder.Body.String())
+	assert.Contains(t, recorder.Header().Get("Link"), `</tasks?limit=2&page=2&status=done>; rel="prev"`)
+	recorder = serve("?status=in+progress", "Prefer", "envelope")
+	assert.JSONEq(t, `{"items":[],"total":0,"page":1,"limit":10}`, recorder.Body.String())
+	assert.Equal(t, `</tasks?page=1&status=in+progress>; rel="first", </tasks?page=1&status=in+progress>; rel="last"`, recorder.Header().Get("Link"))
+
+	// Cursor pages link to their neighbours
+	recorder = serve("?cursor=&limit=2")
+	assert.Equal(t, 200, recorder.Code)
+	page = TaskPage{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	assert.Nil(t, page.Total)
+	assert.Equal(t, `</tasks?cursor=&limit=2>; rel="first", </tasks?cursor=`+url.QueryEscape(page.NextCursor)+`&limit=2>; rel="next"`, recorder.Header().Get("Link"))
+}
+
+func TestCreateTaskDueTimezone(t *testing.T) {
+	router := newUserRouter(newTestStore(), 1)
+	router.POST("/tasks", CreateTask)
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"title":"t","description":"d","status":"todo","due_at":"2026-03-29T09:00:00+02:00","due_timezone":"Europe/Berlin"}`, 200},
+		{`{"title":"t","description":"d","status":"todo","due_at":"2026-03-29T09:00:00+02:00","due_timezone":"Mars/Olympus"}`, 400},
+		{`{"title":"t","description":"d","status":"todo","due_timezone":"Europe/Berlin"}`, 400},
+		{`{"title":"t","description":"d","status":"todo","due_at":"tomorrow"}`, 400},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", "/tasks", bytes.NewBufferString(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		assert.Equal(t, tt.code, recorder.Code, tt.body)
+	}
+}
+
+func TestRecurringTasks(t *testing.T) {
+	router := newUserRouter(newTestStore(), 1)
+	router.POST("/tasks", CreateTask)
+	router.GET("/tasks/:id", GetTaskByID)
+	router.PUT("/tasks/mark-done", MarkTasksDone)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	assert.Equal(t, 400, serve("POST", "/tasks", `{"title":"t","description":"d","status":"todo","due_at":"2099-01-30T09:00:00Z","recurrence":"FREQ=SOMETIMES"}`).Code)
+	assert.Equal(t, 400, serve("POST", "/tasks", `{"title":"t","description":"d","status":"todo","recurrence":"FREQ=MONTHLY"}`).Code)
+	assert.Equal(t, 200, serve("POST", "/tasks", `{"title":"invoice","description":"d","status":"todo","due_at":"2099-01-31T09:00:00Z","recurrence":"FREQ=MONTHLY;BYMONTHDAY=-1"}`).Code)
+
+	assert.Equal(t, 200, serve("PUT", "/tasks/mark-done", `["2"]`).Code)
+	recorder := serve("GET", "/tasks/3", "")
+	assert.Equal(t, 200, recorder.Code)
+	var task utils.Task
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &task))
+	assert.Equal(t, "invoice", task.Title)
+	assert.Equal(t, "todo", task.Status)
+	assert.Equal(t, "2099-02-28T09:00:00Z", task.DueAt.Format(time.RFC3339))
+	assert.Equal(t, "FREQ=MONTHLY;BYMONTHDAY=-1", task.Recurrence)
+}
+
+func TestPatchTask(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.PATCH("/tasks/:id", PatchTask)
+
+	serve := func(contentType, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PATCH", url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	get := func() utils.Task {
+		task, err := store.GetTaskByID(context.Background(), 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return task
+	}
+
+	// A merge patch changes only the fields it names
+	recorder := serve("application/merge-patch+json", "/tasks/1", `{"status":"in progress","tags":["home"]}`)
+	assert.Equal(t, 200, recorder.Code)
+	var task utils.Task
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &task))
+	assert.Equal(t, "in progress", task.Status)
+	assert.Equal(t, "title1", task.Title)
+	assert.Equal(t, "description1", task.Description)
+	assert.Equal(t, []string{"home"}, task.Tags)
+
+	// A JSON Patch applies its operations in order, or none of them
+	assert.Equal(t, 200, serve("application/json-patch+json", "/tasks/1",
+		`[{"op":"test","path":"/status","value":"in progress"},{"op":"replace","path":"/title","value":"renamed"},{"op":"add","path":"/tags/-","value":"work"}]`).Code)
+	task = get()
+	assert.Equal(t, "renamed", task.Title)
+	assert.Equal(t, []string{"home", "work"}, task.Tags)
+	assert.Equal(t, 409, serve("application/json-patch+json", "/tasks/1",
+		`[{"op":"replace","path":"/title","value":"lost"},{"op":"test","path":"/status","value":"todo"}]`).Code)
+	assert.Equal(t, "renamed", get().Title)
+
+	// The patched task must be valid
+	tests := []struct {
+		contentType, body string
+		code              int
+	}{
+		{"application/merge-patch+json", `{"title":null}`, 400},
+		{"application/merge-patch+json", `{"priority":"whenever"}`, 400},
+		{"application/merge-patch+json", `{"due_timezone":"Europe/Berlin"}`, 400},
+		{"application/merge-patch+json", `{"owner":2}`, 400},
+		{"application/merge-patch+json", `["title"]`, 400},
+		{"application/merge-patch+json", `{`, 400},
+		{"application/json-patch+json", `[{"op":"remove","path":"/missing"}]`, 400},
+		{"application/merge-patch+json", `{"parent_id":1}`, 409},
+		{"application/merge-patch+json", `{"parent_id":99}`, 400},
+		{"text/plain", `{"title":"t"}`, 415},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, serve(tt.contentType, "/tasks/1", tt.body).Code, tt.body)
+	}
+	assert.Equal(t, "renamed", get().Title)
+
+	assert.Equal(t, 404, serve("application/json", "/tasks/99", `{"status":"done"}`).Code)
+	assert.Equal(t, 400, serve("application/json", "/tasks/x", `{"status":"done"}`).Code)
+	assert.Equal(t, 200, serve("application/json", "/tasks/1", `{"status":"done"}`).Code)
+	assert.Equal(t, "done", get().Status)
+}
+
+func TestTaskETags(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.GET("/tasks", GetTasks)
+	router.GET("/tasks/:id", GetTaskByID)
+	router.PUT("/tasks/:id", UpdateTask)
+	router.PATCH("/tasks/:id", PatchTask)
+	router.DELETE("/tasks/:id", DeleteTask)
+
+	serve := func(method, url, body string, header ...string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Reads answer 304 while the ETag they were sent still matches
+	recorder := serve("GET", "/tasks/1", "")
+	assert.Equal(t, 200, recorder.Code)
+	etag := recorder.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	var task utils.Task
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &task))
+	assert.Equal(t, 1, task.Version)
+	recorder = serve("GET", "/tasks/1", "", "If-None-Match", `"other", W/`+etag)
+	assert.Equal(t, 304, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
+	assert.Equal(t, etag, recorder.Header().Get("ETag"))
+
+	list := serve("GET", "/tasks", "").Header().Get("ETag")
+	assert.NotEmpty(t, list)
+	assert.Equal(t, 304, serve("GET", "/tasks", "", "If-None-Match", list).Code)
+
+	// Changes need the current ETag when they send one
+	recorder = serve("PUT", "/tasks/1", `{"title":"t","description":"d","status":"todo"}`, "If-Match", etag)
+	assert.Equal(t, 200, recorder.Code)
+	updated := recorder.Header().Get("ETag")
+	assert.NotEqual(t, etag, updated)
+	assert.Equal(t, 200, serve("GET", "/tasks/1", "", "If-None-Match", etag).Code)
+	assert.Equal(t, 200, serve("GET", "/tasks", "", "If-None-Match", list).Code)
+
+	assert.Equal(t, 412, serve("PUT", "/tasks/1", `{"title":"lost","description":"d","status":"todo"}`, "If-Match", etag).Code)
+	assert.Equal(t, 412, serve("PATCH", "/tasks/1", `{"title":"lost"}`, "If-Match", etag).Code)
+	assert.Equal(t, 412, serve("DELETE", "/tasks/1", "", "If-Match", etag).Code)
+	assert.Equal(t, 412, serve("DELETE", "/tasks/1", "", "If-Match", "W/"+updated).Code)
+
+	recorder = serve("PATCH", "/tasks/1", `{"title":"patched"}`, "If-Match", updated)
+	assert.Equal(t, 200, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &task))
+	assert.Equal(t, "patched", task.Title)
+	assert.Equal(t, 3, task.Version)
+	assert.Equal(t, 200, serve("PUT", "/tasks/1", `{"title":"t","description":"d","status":"todo"}`, "If-Match", "*").Code)
+
+	// Changes to other tasks that only show in derived fields keep the ETag
+	etag = serve("GET", "/tasks/1", "").Header().Get("ETag")
+	blockerID, err := store.CreateTask(context.Background(), utils.Task{Title: "blocker", Status: "todo", UserID: 1})
+	assert.NoError(t, err)
+	assert.NoError(t, store.AddTaskBlocker(context.Background(), 1, 1, blockerID))
+	recorder = serve("GET", "/tasks/1", "")
+	assert.Contains(t, recorder.Body.String(), fmt.Sprintf(`"blocked_by":[%d]`, blockerID))
+	assert.Equal(t, etag, recorder.Header().Get("ETag"))
+	assert.Equal(t, 200, serve("PATCH", "/tasks/1", `{"title":"still mine"}`, "If-Match", etag).Code)
+	assert.Equal(t, 200, serve("DELETE", "/tasks/1", "", "If-Match", serve("GET", "/tasks/1", "").Header().Get("ETag")).Code)
+	assert.Equal(t, 404, serve("GET", "/tasks/1", "").Code)
+}
+
+func TestMarkTasksDoneResults(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.POST("/tasks", CreateTask)
+	router.POST("/tasks/:id/blockers", AddTaskBlocker)
+	router.PUT("/tasks/mark-done", MarkTasksDone)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	status := func(id int) string {
+		task, err := store.GetTaskByID(context.Background(), 1, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return task.Status
+	}
+
+	// Tasks 2 and 3 of user1, task 4 of another user; 3 waits on 2
+	for _, title := range []string{"two", "three"} {
+		assert.Equal(t, 200, serve("POST", "/tasks", `{"title":"`+title+`","description":"d","status":"todo"}`).Code)
+	}
+	other, err := store.CreateUser(context.Background(), utils.User{Username: "user2", Password: "hashed-password"})
+	assert.NoError(t, err)
+	_, err = store.CreateTask(context.Background(), utils.Task{Title: "foreign", Description: "d", Status: "todo", UserID: other})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, serve("POST", "/tasks/3/blockers", `{"blocker_id":2}`).Code)
+
+	// Atomic requests change nothing unless every task can be marked done
+	recorder := serve("PUT", "/tasks/mark-done?atomic=true", `["1","3","4"]`)
+	assert.Equal(t, 409, recorder.Code)
+	assert.JSONEq(t, `{"error":"No tasks were marked done","results":[
+		{"id":"1","status":"rolled_back"},{"id":"3","status":"blocked","blocked_by":[2]},{"id":"4","status":"not_found"}]}`, recorder.Body.String())
+	recorder = serve("PUT", "/tasks/mark-done?atomic=true", `["1","x"]`)
+	assert.Equal(t, 409, recorder.Code)
+	assert.JSONEq(t, `{"error":"No tasks were marked done","results":[{"id":"1","status":"rolled_back"},{"id":"x","status":"invalid_id"}]}`, recorder.Body.String())
+	assert.Equal(t, "todo", status(1))
+
+	recorder = serve("PUT", "/tasks/mark-done?atomic=true", `["3","2"]`)
+	assert.Equal(t, 200, recorder.Code)
+	assert.JSONEq(t, `{"updated":2,"results":[{"id":"3","status":"updated"},{"id":"2","status":"updated"}]}`, recorder.Body.String())
+	assert.Equal(t, "done", status(3))
+
+	// Otherwise each task gets its own outcome
+	recorder = serve("PUT", "/tasks/mark-done", `["1","4","0","x","1"]`)
+	assert.Equal(t, 200, recorder.Code)
+	assert.JSONEq(t, `{"updated":2,"results":[
+		{"id":"1","status":"updated"},{"id":"4","status":"not_found"},{"id":"0","status":"invalid_id"},{"id":"x","status":"invalid_id"},{"id":"1","status":"updated"}]}`, recorder.Body.String())
+	assert.Equal(t, "done", status(1))
+	foreign, err := store.GetTaskByID(context.Background(), other, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "todo", foreign.Status)
+
+	assert.Equal(t, 400, serve("PUT", "/tasks/mark-done?atomic=maybe", `["1"]`).Code)
+	ids := make([]string, maxMarkDoneTasks+1)
+	for i := range ids {
+		ids[i] = "1"
+	}
+	body, _ := json.Marshal(ids)
+	assert.Equal(t, 400, serve("PUT", "/tasks/mark-done", string(body)).Code)
+}
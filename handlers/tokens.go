@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/models"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary		Create a personal access token
+// @Description	Create a long-lived token for scripts and CI. Send it as the Authorization header like a JWT. A tasks:read token can only read tasks and tags, a tasks:write token can also change them. The token is only returned once
+// @Tags			Personal Access Tokens
+// @Accept			application/json
+// @Produce		application/json
+// @Security		JWT
+// @Param			PersonalAccessToken	body		models.PersonalAccessToken								true	"Token name, scope and optional expiry"
+// @Success		200					{object}	object{token=string,details=utils.PersonalAccessToken}	"Token created successfully"
+// @Failure		400					{object}	object{error=string}									"Invalid JSON"
+// @Failure		400					{object}	object{error=string}									"Validation error"
+// @Failure		400					{object}	object{error=string}									"Expiry must be in the future"
+// @Failure		403					{object}	object{error=string}									"Forbidden: Personal access tokens cannot be used here"
+// @Failure		409					{object}	object{error=string}									"Token name already exists"
+// @Failure		500					{object}	object{error=string}									"Internal Server Error"
+// @Failure		503					{object}	object{error=string}									"Request cancelled"
+// @Failure		504					{object}	object{error=string}									"Request timed out"
+// @Router			/api/v1/auth/tokens [post]
+func CreatePersonalAccessToken(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	var details models.PersonalAccessToken
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if details.ExpiresAt != nil && !details.ExpiresAt.After(time.Now()) {
+		c.JSON(400, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	token, tokenHash, err := helpers.GeneratePersonalAccessToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	stored, err := db.CreatePersonalAccessToken(c.Request.Context(), utils.PersonalAccessToken{
+		UserID:    userId.(int),
+		Name:      details.Name,
+		Scope:     details.Scope,
+		TokenHash: tokenHash,
+		ExpiresAt: details.ExpiresAt,
+	})
+	if err != nil {
+		if errors.Is(err, utils.ErrPersonalAccessTokenExists) {
+			c.JSON(409, gin.H{"error": "Token name already exists"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
+	}
+	c.JSON(200, gin.H{"token": token, "details": stored})
+}
+
+// @Summary		List personal access tokens
+// @Description	List the user's personal access tokens. The tokens themselves are never shown again
+// @Tags			Personal Access Tokens
+// @Produce		application/json
+// @Security		JWT
+// @Success		200	{array}		utils.PersonalAccessToken
+// @Failure		403	{object}	object{error=string}	"Forbidden: Personal access tokens cannot be used here"
+// @Failure		500	{object}	object{error=string}	"Internal Server Error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/auth/tokens [get]
+func GetPersonalAccessTokens(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	tokens, err := db.GetPersonalAccessTokens(c.Request.Context(), userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+		return
+	}
+	c.JSON(200, tokens)
+}
+
+// @Summary		Revoke a personal access token
+// @Description	Revoke one of the user's personal access tokens by ID, it stops working immediately
+// @Tags			Personal Access Tokens
+// @Security		JWT
+// @Param			id	path		int						true	"Token ID"
+// @Success		200	{object}	object{message=string}	"Token revoked successfully"
+// @Failure		400	{object}	object{error=string}	"Invalid Token Id"
+// @Failure		403	{object}	object{error=string}	"Forbidden: Personal access tokens cannot be used here"
+// @Failure		404	{object}	object{error=string}	"Token not found"
+// @Failure		500	{object}	object{error=string}	"Internal Server Error"
+// @Failure		503	{object}	object{error=string}	"Request cancelled"
+// @Failure		504	{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/auth/tokens/{id} [delete]
+func DeletePersonalAccessToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Token Id"})
+		return
+	}
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.DeletePersonalAccessToken(c.Request.Context(), userId.(int), id); err != nil {
+		helpers.RespondStorageError(c, err, 404, "Token not found")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Token revoked successfully"})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersonalAccessTokens(t *testing.T) {
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.GET("/auth/tokens", GetPersonalAccessTokens)
+	router.POST("/auth/tokens", CreatePersonalAccessToken)
+	router.DELETE("/auth/tokens/:id", DeletePersonalAccessToken)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve("POST", "/auth/tokens", `{"name":"ci","scope":"tasks:write"}`)
+	assert.Equal(t, 200, recorder.Code)
+	var created struct {
+		Token   string                    `json:"token"`
+		Details utils.PersonalAccessToken `json:"details"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+	assert.True(t, strings.HasPrefix(created.Token, helpers.PersonalAccessTokenPrefix))
+	assert.Equal(t, "ci", created.Details.Name)
+	assert.Equal(t, utils.ScopeTasksWrite, created.Details.Scope)
+
+	// Only the hash is stored
+	token, err := store.UsePersonalAccessToken(context.Background(), helpers.HashToken(created.Token))
+	require.NoError(t, err)
+	assert.Equal(t, created.Details.ID, token.ID)
+
+	assert.Equal(t, 409, serve("POST", "/auth/tokens", `{"name":"ci","scope":"tasks:read"}`).Code)
+	assert.Equal(t, 400, serve("POST", "/auth/tokens", `{"name":"ci2","scope":"admin"}`).Code)
+	assert.Equal(t, 400, serve("POST", "/auth/tokens", `{"scope":"tasks:read"}`).Code)
+	assert.Equal(t, 400, serve("POST", "/auth/tokens", `{"name":"old","scope":"tasks:read","expires_at":"2000-01-01T00:00:00Z"}`).Code)
+	assert.Equal(t, 200, serve("POST", "/auth/tokens", `{"name":"report","scope":"tasks:read","expires_at":"2999-01-01T00:00:00Z"}`).Code)
+
+	recorder = serve("GET", "/auth/tokens", "")
+	assert.Equal(t, 200, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), created.Token)
+	var tokens []utils.PersonalAccessToken
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &tokens))
+	require.Len(t, tokens, 2)
+	assert.Equal(t, "report", tokens[1].Name)
+	assert.NotNil(t, tokens[0].LastUsedAt)
+
+	assert.Equal(t, 400, serve("DELETE", "/auth/tokens/ci", "").Code)
+	assert.Equal(t, 200, serve("DELETE", "/auth/tokens/1", "").Code)
+	assert.Equal(t, 404, serve("DELETE", "/auth/tokens/1", "").Code)
+	_, err = store.UsePersonalAccessToken(context.Background(), helpers.HashToken(created.Token))
+	assert.ErrorIs(t, err, utils.ErrPersonalAccessTokenInvalid)
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/models"
+	"github.com/Parjun2000/task-manager/signing"
+	"github.com/Parjun2000/task-manager/totp"
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTPIssuer is the name authenticator apps show next to the account.
+var TOTPIssuer = "Task Manager"
+
+// TOTPSecretKey encrypts TOTP secrets in storage. main sets it to JWT_KEY,
+// which encrypts the signing keys too.
+var TOTPSecretKey []byte
+
+// sealedTOTPPrefix starts sealed TOTP secrets in storage. Secrets stored
+// before they were sealed have none and are read as they are.
+const sealedTOTPPrefix = "sealed:"
+
+// sealTOTPSecret encrypts a TOTP secret with TOTPSecretKey for storing it.
+func sealTOTPSecret(secret string) (string, error) {
+	sealed, err := signing.SealBytes([]byte(secret), TOTPSecretKey)
+	if err != nil {
+		return "", err
+	}
+	return sealedTOTPPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// openTOTPSecret decrypts a TOTP secret stored by sealTOTPSecret.
+func openTOTPSecret(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, sealedTOTPPrefix)
+	if !ok {
+		return stored, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	secret, err := signing.OpenBytes(sealed, TOTPSecretKey)
+	if err != nil {
+		return "", errors.New("cannot decrypt TOTP secret, was JWT_KEY changed?")
+	}
+	return string(secret), nil
+}
+
+// recoveryCodeCount is how many recovery codes are issued when two-factor
+// authentication is enabled.
+const recoveryCodeCount = 10
+
+// @Summary		Get two-factor authentication status
+// @Description	Show whether two-factor authentication is enabled for the logged in user and how many recovery codes are left
+// @Tags			Two-Factor Authentication
+// @Produce		application/json
+// @Security		JWT
+// @Success		200	{object}	object{enabled=bool,recovery_codes_left=int}	"Two-factor authentication status"
+// @Failure		500	{object}	object{error=string}							"Internal server error"
+// @Failure		503	{object}	object{error=string}							"Request cancelled"
+// @Failure		504	{object}	object{error=string}							"Request timed out"
+// @Router			/api/v1/auth/2fa [get]
+func GetTwoFactor(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	credential, err := db.GetTOTP(c.Request.Context(), userId.(int))
+	if err != nil {
+		if errors.Is(err, utils.ErrTOTPNotFound) {
+			c.JSON(200, gin.H{"enabled": false, "recovery_codes_left": 0})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	enabled := credential.ConfirmedAt != nil
+	if !enabled {
+		credential.RecoveryCodesLeft = 0
+	}
+	c.JSON(200, gin.H{"enabled": enabled, "recovery_codes_left": credential.RecoveryCodesLeft})
+}
+
+// @Summary		Set up two-factor authentication
+// @Description	Generate a new TOTP secret for the logged in user. Add it to an authenticator app, by hand or through the otpauth URI, and confirm it with a code to enable two-factor authentication
+// @Tags			Two-Factor Authentication
+// @Produce		application/json
+// @Security		JWT
+// @Success		200	{object}	object{secret=string,otpauth_uri=string}	"TOTP secret generated"
+// @Failure		409	{object}	object{error=string}						"Two-factor authentication is already enabled"
+// @Failure		500	{object}	object{error=string}						"Internal server error"
+// @Failure		503	{object}	object{error=string}						"Request cancelled"
+// @Failure		504	{object}	object{error=string}						"Request timed out"
+// @Router			/api/v1/auth/2fa/setup [post]
+func SetupTwoFactor(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	sealed, err := sealTOTPSecret(secret)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	if err := db.SetTOTPSecret(c.Request.Context(), userId.(int), sealed); err != nil {
+		if errors.Is(err, utils.ErrTOTPEnabled) {
+			c.JSON(409, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	c.JSON(200, gin.H{"secret": secret, "otpauth_uri": totp.URI(TOTPIssuer, c.GetString("username"), secret)})
+}
+
+// @Summary		Confirm two-factor authentication
+// @Description	Enable two-factor authentication with a code from the authenticator app. Returns one-time recovery codes to log in without the app; they are only shown once
+// @Tags			Two-Factor Authentication
+// @Accept			application/json
+// @Produce		application/json
+// @Security		JWT
+// @Param			TwoFactorCode	body		models.TwoFactorCode				true	"Code from the authenticator app"
+// @Success		200				{object}	object{recovery_codes=[]string}	"Two-factor authentication enabled"
+// @Failure		400				{object}	object{error=string}				"Invalid JSON"
+// @Failure		400				{object}	object{error=string}				"Validation error"
+// @Failure		400				{object}	object{error=string}				"Invalid code"
+// @Failure		404				{object}	object{error=string}				"Two-factor authentication is not set up"
+// @Failure		409				{object}	object{error=string}				"Two-factor authentication is already enabled"
+// @Failure		500				{object}	object{error=string}				"Internal server error"
+// @Failure		503				{object}	object{error=string}				"Request cancelled"
+// @Failure		504				{object}	object{error=string}				"Request timed out"
+// @Router			/api/v1/auth/2fa/confirm [post]
+func ConfirmTwoFactor(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var details models.TwoFactorCode
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	credential, err := db.GetTOTP(c.Request.Context(), userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 404, "Two-factor authentication is not set up")
+		return
+	}
+	if credential.ConfirmedAt != nil {
+		c.JSON(409, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	secret, err := openTOTPSecret(credential.Secret)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+	counter, ok := totp.Validate(secret, details.Code, time.Now())
+	if !ok {
+		c.JSON(400, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], hashes[i], err = helpers.GenerateRecoveryCode(); err != nil {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+	}
+	if err := db.EnableTOTP(c.Request.Context(), userId.(int), counter, hashes); err != nil {
+		switch {
+		case errors.Is(err, utils.ErrTOTPNotFound):
+			c.JSON(404, gin.H{"error": "Two-factor authentication is not set up"})
+		case errors.Is(err, utils.ErrTOTPEnabled):
+			c.JSON(409, gin.H{"error": "Two-factor authentication is already enabled"})
+		default:
+			helpers.RespondStorageError(c, err, 500, "Internal server error")
+		}
+		return
+	}
+	c.JSON(200, gin.H{"recovery_codes": codes})
+}
+
+// @Summary		Disable two-factor authentication
+// @Description	Disable two-factor authentication for the logged in user. Requires the password and a code from the authenticator app or a recovery code. Users of the identity provider have no password and give the code alone
+// @Tags			Two-Factor Authentication
+// @Accept			application/json
+// @Produce		application/json
+// @Security		JWT
+// @Param			TwoFactorDisable	body		models.TwoFactorDisable	true	"Password & code"
+// @Success		200					{object}	object{message=string}	"Two-factor authentication disabled"
+// @Failure		400					{object}	object{error=string}	"Invalid JSON"
+// @Failure		400					{object}	object{error=string}	"Validation error"
+// @Failure		400					{object}	object{error=string}	"Password is required"
+// @Failure		401					{object}	object{error=string}	"Invalid password"
+// @Failure		401					{object}	object{error=string}	"Invalid code"
+// @Failure		404					{object}	object{error=string}	"Two-factor authentication is not enabled"
+// @Failure		500					{object}	object{error=string}	"Internal server error"
+// @Failure		503					{object}	object{error=string}	"Request cancelled"
+// @Failure		504					{object}	object{error=string}	"Request timed out"
+// @Router			/api/v1/auth/2fa/disable [post]
+func DisableTwoFactor(c *gin.Context) {
+	userId, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(500, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	var details models.TwoFactorDisable
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	user, err := db.GetUserByID(c.Request.Context(), userId.(int))
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	// Users of the identity provider have no password, the code alone
+	// confirms that it is them
+	if user.Password != "" {
+		if details.Password == "" {
+			c.JSON(400, gin.H{"error": "Password is required"})
+			return
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(details.Password)); err != nil {
+			c.JSON(401, gin.H{"error": "Invalid password"})
+			return
+		}
+	}
+
+	credential, err := db.GetTOTP(c.Request.Context(), user.ID)
+	if err != nil && !errors.Is(err, utils.ErrTOTPNotFound) {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if err != nil || credential.ConfirmedAt == nil {
+		c.JSON(404, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	valid, err := verifySecondFactor(c, db, credential, details.Code)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if !valid {
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err := db.DisableTOTP(c.Request.Context(), user.ID); err != nil {
+		helpers.RespondStorageError(c, err, 404, "Two-factor authentication is not enabled")
+		return
+	}
+	c.JSON(200, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// @Summary		Complete a two-factor login
+// @Description	Exchange the mfa_token returned by /api/v1/auth/login and a code from the authenticator app, or a recovery code, for an access token and a refresh token. Each mfa_token allows 5 attempts, and wrong codes count as failed logins
+// @Tags			Register & Login
+// @Accept			application/json
+// @Produce		application/json
+// @Param			MFALogin	body		models.MFALogin												true	"MFA token & code"
+// @Success		200			{object}	object{token=string,refresh_token=string,expires_in=int}	"User logged in successfully"
+// @Failure		400			{object}	object{error=string}										"Invalid JSON"
+// @Failure		400			{object}	object{error=string}										"Validation error"
+// @Failure		401			{object}	object{error=string}										"Invalid or expired MFA token"
+// @Failure		401			{object}	object{error=string}										"Invalid code"
+// @Failure		403			{object}	object{error=string}										"Account disabled"
+// @Failure		429			{object}	object{error=string}										"Too many failed login attempts"
+// @Failure		500			{object}	object{error=string}										"Internal server error"
+// @Failure		503			{object}	object{error=string}										"Request cancelled"
+// @Failure		504			{object}	object{error=string}										"Request timed out"
+// @Router			/api/v1/auth/login/mfa [post]
+func LoginMFA(c *gin.Context) {
+	var details models.MFALogin
+	if err := c.BindJSON(&details); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := details.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	s, _ := c.Get("db")
+	db := s.(utils.Storage)
+	challenge, err := db.AttemptMFAChallenge(c.Request.Context(), helpers.HashToken(details.MFAToken))
+	if err != nil {
+		if errors.Is(err, utils.ErrMFAChallengeInvalid) {
+			c.JSON(401, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+
+	user, err := db.GetUserByID(c.Request.Context(), challenge.UserID)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if user.DisabledAt != nil {
+		c.JSON(403, gin.H{"error": "Account disabled"})
+		return
+	}
+	if locked, err := loginLockedFor(c, db, user.Username); err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	} else if locked > 0 {
+		respondLoginLocked(c, locked)
+		return
+	}
+
+	// Two-factor authentication may have been disabled since the login
+	credential, err := db.GetTOTP(c.Request.Context(), user.ID)
+	if err != nil && !errors.Is(err, utils.ErrTOTPNotFound) {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if err != nil || credential.ConfirmedAt == nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+	valid, err := verifySecondFactor(c, db, credential, details.Code)
+	if err != nil {
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	if !valid {
+		// Wrong codes count towards the lockout, or new challenges would
+		// allow guessing codes without limit
+		failLogin(c, db, user.Username, "Invalid code")
+		return
+	}
+
+	if err := db.CompleteMFAChallenge(c.Request.Context(), challenge.ID); err != nil {
+		if errors.Is(err, utils.ErrMFAChallengeInvalid) {
+			c.JSON(401, gin.H{"error": "Invalid or expired MFA token"})
+			return
+		}
+		helpers.RespondStorageError(c, err, 500, "Internal server error")
+		return
+	}
+	issueTokens(c, db, user)
+}
+
+// verifySecondFactor checks a TOTP code or recovery code against the user's
+// confirmed credential and uses it up, so neither can be replayed.
+func verifySecondFactor(c *gin.Context, db utils.Storage, credential utils.TOTPCredential, code string) (bool, error) {
+	secret, err := openTOTPSecret(credential.Secret)
+	if err != nil {
+		return false, err
+	}
+	if counter, ok := totp.Validate(secret, code, time.Now()); ok {
+		err := db.UseTOTPCounter(c.Request.Context(), credential.UserID, counter)
+		if errors.Is(err, utils.ErrTOTPCodeReused) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	err = db.UseRecoveryCode(c.Request.Context(), credential.UserID, helpers.HashRecoveryCode(code))
+	if errors.Is(err, utils.ErrRecoveryCodeInvalid) {
+		return false, nil
+	}
+	return err == nil, err
+}
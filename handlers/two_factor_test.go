@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/totp"
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactor(t *testing.T) {
+	// Lockouts after wrong codes are covered by TestLoginLockout
+	defer func(maxFailures int) { helpers.LoginMaxFailures = maxFailures }(helpers.LoginMaxFailures)
+	helpers.LoginMaxFailures = 100
+
+	store := newTestStore()
+	router := newUserRouter(store, 1)
+	router.GET("/auth/2fa", GetTwoFactor)
+	router.POST("/auth/2fa/setup", SetupTwoFactor)
+	router.POST("/auth/2fa/confirm", ConfirmTwoFactor)
+	router.POST("/auth/2fa/disable", DisableTwoFactor)
+	router.POST("/auth/login", Login)
+	router.POST("/auth/login/mfa", LoginMFA)
+
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	code := func(secret string, t time.Time) string {
+		code, err := totp.Code(secret, t)
+		if err != nil {
+			panic(err)
+		}
+		return code
+	}
+	login := func() string {
+		recorder := serve("POST", "/auth/login", `{"username":"user1","password":"password1"}`)
+		require.Equal(t, 200, recorder.Code)
+		var response struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		return response.MFAToken
+	}
+
+	assert.Equal(t, 404, serve("POST", "/auth/2fa/confirm", `{"code":"123456"}`).Code)
+
+	recorder := serve("POST", "/auth/2fa/setup", "")
+	require.Equal(t, 200, recorder.Code)
+	var setup struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &setup))
+	assert.True(t, strings.HasPrefix(setup.OTPAuthURI, "otpauth://totp/"))
+	assert.Contains(t, setup.OTPAuthURI, "secret="+setup.Secret)
+
+	// The secret is stored sealed
+	credential, err := store.GetTOTP(context.Background(), 1)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(credential.Secret, sealedTOTPPrefix))
+	assert.NotContains(t, credential.Secret, setup.Secret)
+
+	// Logging in needs no code until the secret is confirmed
+	assert.Empty(t, login())
+
+	assert.Equal(t, 400, serve("POST", "/auth/2fa/confirm", `{"code":"abcdef"}`).Code)
+	recorder = serve("POST", "/auth/2fa/confirm", fmt.Sprintf(`{"code":%q}`, code(setup.Secret, time.Now())))
+	require.Equal(t, 200, recorder.Code)
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &confirmed))
+	require.Len(t, confirmed.RecoveryCodes, recoveryCodeCount)
+	assert.Equal(t, 409, serve("POST", "/auth/2fa/setup", "").Code)
+	assert.JSONEq(t, `{"enabled":true,"recovery_codes_left":10}`, serve("GET", "/auth/2fa", "").Body.String())
+
+	// The password alone only gets a challenge
+	mfaToken := login()
+	require.NotEmpty(t, mfaToken)
+	assert.Equal(t, 401, serve("POST", "/auth/login/mfa", `{"mfa_token":"unknown","code":"123456"}`).Code)
+	assert.Equal(t, 401, serve("POST", "/auth/login/mfa", fmt.Sprintf(`{"mfa_token":%q,"code":"000000x"}`, mfaToken)).Code)
+
+	// Codes from the time step used to confirm cannot be replayed
+	body := fmt.Sprintf(`{"mfa_token":%q,"code":%q}`, mfaToken, code(setup.Secret, time.Now()))
+	assert.Equal(t, 401, serve("POST", "/auth/login/mfa", body).Code)
+	body = fmt.Sprintf(`{"mfa_token":%q,"code":%q}`, mfaToken, code(setup.Secret, time.Now().Add(totp.Period)))
+	recorder = serve("POST", "/auth/login/mfa", body)
+	assert.Equal(t, 200, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "refresh_token")
+
+	// Challenges are single use
+	assert.Equal(t, 401, serve("POST", "/auth/login/mfa", body).Code)
+
+	// Recovery codes work once, typed in any case
+	mfaToken = login()
+	body = fmt.Sprintf(`{"mfa_token":%q,"code":%q}`, mfaToken, strings.ToUpper(confirmed.RecoveryCodes[0]))
+	assert.Equal(t, 200, serve("POST", "/auth/login/mfa", body).Code)
+	mfaToken = login()
+	body = fmt.Sprintf(`{"mfa_token":%q,"code":%q}`, mfaToken, confirmed.RecoveryCodes[0])
+	assert.Equal(t, 401, serve("POST", "/auth/login/mfa", body).Code)
+
+	// Challenges run out of attempts
+	for i := 1; i < 5; i++ {
+		assert.Equal(t, 401, serve("POST", "/auth/login/mfa", body).Code)
+	}
+	body = fmt.Sprintf(`{"mfa_token":%q,"code":%q}`, mfaToken, confirmed.RecoveryCodes[1])
+	assert.Equal(t, 401, serve("POST", "/auth/login/mfa", body).Code)
+
+	body = fmt.Sprintf(`{"code":%q}`, confirmed.RecoveryCodes[1])
+	assert.Equal(t, 400, serve("POST", "/auth/2fa/disable", body).Code)
+	body = fmt.Sprintf(`{"password":"wrong","code":%q}`, confirmed.RecoveryCodes[1])
+	assert.Equal(t, 401, serve("POST", "/auth/2fa/disable", body).Code)
+	body = fmt.Sprintf(`{"password":"password1","code":%q}`, confirmed.RecoveryCodes[1])
+	assert.Equal(t, 200, serve("POST", "/auth/2fa/disable", body).Code)
+	assert.Equal(t, 404, serve("POST", "/auth/2fa/disable", body).Code)
+	assert.JSONEq(t, `{"enabled":false,"recovery_codes_left":0}`, serve("GET", "/auth/2fa", "").Body.String())
+	assert.Empty(t, login())
+}
+
+func TestTwoFactorWithoutPassword(t *testing.T) {
+	store := newTestStore()
+	alice, err := store.CreateExternalUser(context.Background(), utils.User{Username: "alice"}, utils.ExternalIdentity{Issuer: "https://issuer.example.com", Subject: "alice-sub"})
+	require.NoError(t, err)
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	sealed, err := sealTOTPSecret(secret)
+	require.NoError(t, err)
+	require.NoError(t, store.SetTOTPSecret(context.Background(), alice.ID, sealed))
+	require.NoError(t, store.EnableTOTP(context.Background(), alice.ID, 0, nil))
+
+	router := newUserRouter(store, alice.ID)
+	router.POST("/auth/2fa/disable", DisableTwoFactor)
+	serve := func(body string) int {
+		req, err := http.NewRequest("POST", "/auth/2fa/disable", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// Users of the identity provider confirm with the code alone
+	assert.Equal(t, 400, serve(`{}`))
+	assert.Equal(t, 401, serve(`{"code":"000000x"}`))
+	code, err := totp.Code(secret, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 200, serve(fmt.Sprintf(`{"code":%q}`, code)))
+	_, err = store.GetTOTP(context.Background(), alice.ID)
+	assert.ErrorIs(t, err, utils.ErrTOTPNotFound)
+}
@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for cursors that were not made by SignCursor
+// with CursorKey.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorKey signs page cursors, so clients cannot make up places in a list.
+// It is random unless set with DeriveCursorKey, which lets every instance
+// sharing the secret read the cursors of the others.
+var CursorKey = randomKey()
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// DeriveCursorKey returns the key cursors are signed with for a secret, so
+// the secret itself is not used for two purposes.
+func DeriveCursorKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("page cursors"))
+	return mac.Sum(nil)
+}
+
+// SignCursor encodes v as an opaque cursor signed with CursorKey.
+func SignCursor(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(cursorMAC(encoded)), nil
+}
+
+// ParseCursor checks the signature of a cursor made by SignCursor and
+// decodes it into v.
+func ParseCursor(cursor string, v interface{}) error {
+	encoded, signature, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return ErrInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, cursorMAC(encoded)) {
+		return ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+func cursorMAC(encoded string) []byte {
+	mac := hmac.New(sha256.New, CursorKey)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETag returns the strong entity tag of a JSON response: a hash of the body,
+// so it changes whenever anything in the response does.
+func ETag(v interface{}) (string, []byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, body, nil
+}
+
+// VersionETag returns the strong entity tag of a versioned resource. It only
+// changes with the version, not with fields derived from other resources, so
+// a change elsewhere does not fail an If-Match on the resource.
+func VersionETag(id, version int) string {
+	return fmt.Sprintf(`"%d-%d"`, id, version)
+}
+
+// matchETag reports whether a comma separated list of entity tags, or "*",
+// includes etag. Weak tags only match when weak is set.
+func matchETag(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// RespondWithETag writes v as JSON with its ETag. A GET whose If-None-Match
+// lists the ETag gets 304 Not Modified without a body.
+func RespondWithETag(c *gin.Context, code int, v interface{}) {
+	etag, body, err := ETag(v)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	respondTagged(c, code, etag, body)
+}
+
+// RespondWithVersion is RespondWithETag for a versioned resource, tagged by
+// VersionETag.
+func RespondWithVersion(c *gin.Context, code int, id, version int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Internal Server Error"})
+		return
+	}
+	respondTagged(c, code, VersionETag(id, version), body)
+}
+
+func respondTagged(c *gin.Context, code int, etag string, body []byte) {
+	c.Header("ETag", etag)
+	if header := c.GetHeader("If-None-Match"); header != "" && (c.Request.Method == "GET" || c.Request.Method == "HEAD") && matchETag(header, etag, true) {
+		c.Status(304)
+		return
+	}
+	c.Data(code, "application/json; charset=utf-8", body)
+}
+
+// IfMatch reports whether the request's If-Match header, when it has one,
+// lists etag, the tag of the resource as it is now. conditional tells
+// whether the change must be made against this very version of it.
+func IfMatch(c *gin.Context, etag string) (ok, conditional bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return true, false
+	}
+	return matchETag(header, etag, false), true
+}
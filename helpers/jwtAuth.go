@@ -1,35 +1,94 @@
 package helpers
 
 import (
-	"os"
+	"errors"
 	"time"
 
+	"github.com/Parjun2000/task-manager/signing"
+
 	"github.com/dgrijalva/jwt-go"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_KEY"))
+// ErrUnknownSigningKey is returned when a token names no key of the key set.
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// Lifetimes of the issued tokens, main overrides them from ACCESS_TOKEN_TTL,
+// REFRESH_TOKEN_TTL, PASSWORD_RESET_TTL and MFA_CHALLENGE_TTL.
+var (
+	AccessTokenTTL   = 15 * time.Minute
+	RefreshTokenTTL  = 30 * 24 * time.Hour
+	PasswordResetTTL = 30 * time.Minute
+	MFAChallengeTTL  = 5 * time.Minute
+)
+
+// RecentLogin is how long after logging in users without a password may
+// confirm deleting their account with the login alone.
+var RecentLogin = 5 * time.Minute
 
+// Claims of an access token. StandardClaims.Id holds the jti, which ties the
+// token to the refresh token it was issued with so it can be revoked.
+// Role is informational for clients, AuthMiddleware reads the current role
+// from storage. AuthTime is when the user logged in, and only set on tokens
+// issued by a login, not on those issued by a refresh.
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	AuthTime int64  `json:"auth_time,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateJWT(username string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Token expires in 24 hour (just for illustration)
+// GenerateJWT returns a new access token. authTime is the time of the login
+// it is issued by, zero for tokens issued by a refresh.
+func GenerateJWT(username, role, jti string, authTime time.Time) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL)
 
 	claims := &Claims{
 		Username: username,
+		Role:     role,
 		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
 			ExpiresAt: expirationTime.Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	if !authTime.IsZero() {
+		claims.AuthTime = authTime.Unix()
+	}
+
+	key, ok := signing.Keys.Current()
+	if !ok {
+		return "", errors.New("no signing key")
+	}
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", err
 	}
 
 	return tokenString, nil
 }
+
+// ParseJWT verifies an access token with the key named by its kid header and
+// returns its claims. The token must use the algorithm of that key, so it
+// cannot choose a weaker one.
+func ParseJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.Parser{ValidMethods: []string{signing.RS256, signing.EdDSA}}
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := signing.Keys.Lookup(kid)
+		if !ok {
+			return nil, ErrUnknownSigningKey
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return key.Public(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,45 @@
+package helpers
+
+import "time"
+
+// Login throttling, main overrides these from LOGIN_MAX_FAILURES,
+// LOGIN_IP_MAX_FAILURES, LOGIN_LOCKOUT, LOGIN_LOCKOUT_MAX and
+// LOGIN_FAILURE_WINDOW.
+var (
+	// LoginMaxFailures is how many logins in a row can fail for a username
+	// before it is locked.
+	LoginMaxFailures = 5
+	// LoginIPMaxFailures is the same for a client IP, higher because many
+	// users can share one.
+	LoginIPMaxFailures = 20
+	// LoginLockout is the first lockout, doubled with every further failure
+	// up to LoginLockoutMax.
+	LoginLockout    = 30 * time.Second
+	LoginLockoutMax = 30 * time.Minute
+	// LoginFailureWindow is how long failures are remembered after the last one.
+	LoginFailureWindow = time.Hour
+)
+
+// Password reset throttling, main overrides these from
+// PASSWORD_RESET_MAX_REQUESTS and PASSWORD_RESET_IP_MAX_REQUESTS. Requests
+// are locked out like failed logins once there are this many.
+var (
+	PasswordResetMaxRequests   = 3
+	PasswordResetIPMaxRequests = 10
+)
+
+// LoginLockoutAfter returns how long logins stay locked after the given
+// number of consecutive failures, none while failures is below maxFailures.
+func LoginLockoutAfter(failures, maxFailures int) time.Duration {
+	if failures < maxFailures {
+		return 0
+	}
+	lockout := LoginLockout
+	for i := maxFailures; i < failures && lockout < LoginLockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > LoginLockoutMax {
+		return LoginLockoutMax
+	}
+	return lockout
+}
@@ -0,0 +1,28 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RespondStorageError writes the error response for a failed storage call.
+// When the request deadline passed or the client went away, the query error
+// is reported as 504 or 503 instead of the given status and message.
+func RespondStorageError(c *gin.Context, err error, status int, message string) {
+	// The driver may surface its own error for a cancelled query, so the
+	// request context is the source of truth.
+	if ctxErr := c.Request.Context().Err(); ctxErr != nil {
+		err = ctxErr
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		c.JSON(504, gin.H{"error": "Request timed out"})
+	case errors.Is(err, context.Canceled):
+		c.JSON(503, gin.H{"error": "Request cancelled"})
+	default:
+		c.JSON(status, gin.H{"error": message})
+	}
+}
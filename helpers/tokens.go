@@ -0,0 +1,80 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+// NewTokenID returns a random identifier for a token or a token family.
+func NewTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GenerateOpaqueToken returns a new opaque token, such as a refresh or
+// password reset token, together with the hash that is stored in its place.
+func GenerateOpaqueToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded SHA-256 of a token, the only form in
+// which tokens are stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PersonalAccessTokenPrefix starts every personal access token, which is how
+// AuthMiddleware tells them apart from JWTs.
+const PersonalAccessTokenPrefix = "tm_pat_"
+
+// GeneratePersonalAccessToken returns a new personal access token together
+// with the hash that is stored in its place.
+func GeneratePersonalAccessToken() (token, hash string, err error) {
+	token, _, err = GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	token = PersonalAccessTokenPrefix + token
+	return token, HashToken(token), nil
+}
+
+// recoveryCodeAlphabet leaves out characters that are easily confused.
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// GenerateRecoveryCode returns a new two-factor recovery code, formatted as
+// two groups of five characters, together with the hash that is stored in its place.
+func GenerateRecoveryCode() (code, hash string, err error) {
+	// rand.Int picks every character equally often, where a random byte
+	// modulo the alphabet size would favour its first characters
+	size := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	chars := make([]byte, 10)
+	for i := range chars {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", "", err
+		}
+		chars[i] = recoveryCodeAlphabet[n.Int64()]
+	}
+	code = string(chars[:5]) + "-" + string(chars[5:])
+	return code, HashRecoveryCode(code), nil
+}
+
+// HashRecoveryCode returns the hash of a recovery code, ignoring case, spaces
+// and dashes so codes can be typed the way they are shown or not.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashToken(code)
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/Parjun2000/task-manager/docs"
 
 	"github.com/Parjun2000/task-manager/handlers"
+	"github.com/Parjun2000/task-manager/helpers"
 	"github.com/Parjun2000/task-manager/middleware"
+	"github.com/Parjun2000/task-manager/notifier"
+	"github.com/Parjun2000/task-manager/oidc"
+	"github.com/Parjun2000/task-manager/signing"
 	"github.com/Parjun2000/task-manager/utils"
 	"github.com/joho/godotenv"
 
@@ -30,40 +40,318 @@ func main() {
 		log.Panic("Error loading .env file")
 	}
 
-	// Initialize database connection
-	db, err := utils.InitDB()
+	// Initialize storage, in memory for local development or PostgreSQL
+	var store utils.Storage
+	if os.Getenv("DB_DRIVER") == "memory" {
+		log.Println("Using in-memory database")
+		store = utils.NewMemoryDB()
+	} else {
+		db, err := utils.InitDB()
+		if err != nil {
+			log.Fatal("Database Initialize Error: ", err)
+		}
+		store = utils.NewPostgresDB(db)
+		defer db.Close()
+	}
+
+	// Promote the configured users to admins, there is no other way to get the first one
+	for _, username := range strings.Split(os.Getenv("ADMIN_USERNAMES"), ",") {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+		user, err := store.GetUserByUsername(context.Background(), username)
+		if err != nil {
+			log.Printf("Cannot make %s an admin: %v", username, err)
+			continue
+		}
+		if err := store.SetUserRole(context.Background(), user.ID, utils.RoleAdmin); err != nil {
+			log.Fatal("Admin Initialize Error: ", err)
+		}
+	}
+
+	// Deadline for the storage calls of a single request
+	queryTimeout := 5 * time.Second
+	if value := os.Getenv("DB_QUERY_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid DB_QUERY_TIMEOUT: ", err)
+		}
+		queryTimeout = timeout
+	}
+
+	// What deleting a task does with its subtasks
+	policy, err := utils.ParseChildDeletePolicy(os.Getenv("SUBTASK_DELETE_POLICY"))
+	if err != nil {
+		log.Fatal("Invalid SUBTASK_DELETE_POLICY: ", err)
+	}
+	handlers.SubtaskDeletePolicy = policy
+
+	// Lifetimes of access and refresh tokens
+	if value := os.Getenv("ACCESS_TOKEN_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid ACCESS_TOKEN_TTL: ", err)
+		}
+		helpers.AccessTokenTTL = ttl
+	}
+	if value := os.Getenv("REFRESH_TOKEN_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid REFRESH_TOKEN_TTL: ", err)
+		}
+		helpers.RefreshTokenTTL = ttl
+	}
+	if value := os.Getenv("PASSWORD_RESET_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid PASSWORD_RESET_TTL: ", err)
+		}
+		helpers.PasswordResetTTL = ttl
+	}
+	if value := os.Getenv("MFA_CHALLENGE_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid MFA_CHALLENGE_TTL: ", err)
+		}
+		helpers.MFAChallengeTTL = ttl
+	}
+	if value := os.Getenv("DATA_EXPORT_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid DATA_EXPORT_TTL: ", err)
+		}
+		handlers.DataExportTTL = ttl
+	}
+
+	// Keys signing the access tokens, shared by all instances through the
+	// database and rotated on schedule. JWT_KEY encrypts them there.
+	rotator := &signing.Rotator{
+		Store:     store,
+		Keys:      signing.Keys,
+		Secret:    []byte(os.Getenv("JWT_KEY")),
+		Algorithm: signing.EdDSA,
+		Interval:  30 * 24 * time.Hour,
+		TokenTTL:  helpers.AccessTokenTTL,
+	}
+	if len(rotator.Secret) == 0 {
+		log.Fatal("JWT_KEY must be set")
+	}
+	// Page cursors stay valid across instances
+	helpers.CursorKey = helpers.DeriveCursorKey(rotator.Secret)
+	handlers.TOTPSecretKey = rotator.Secret
+	if value := os.Getenv("JWT_ALGORITHM"); value != "" {
+		if value != signing.RS256 && value != signing.EdDSA {
+			log.Fatalf("Invalid JWT_ALGORITHM: %q", value)
+		}
+		rotator.Algorithm = value
+	}
+	if value := os.Getenv("JWT_KEY_ROTATION"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatal("Invalid JWT_KEY_ROTATION: ", err)
+		}
+		rotator.Interval = interval
+	}
+	if err := rotator.Sync(context.Background()); err != nil {
+		log.Fatal("Signing Key Initialize Error: ", err)
+	}
+	go rotator.Run(context.Background())
+
+	// Lockout after repeated failed logins and password reset requests
+	for name, maxFailures := range map[string]*int{
+		"LOGIN_MAX_FAILURES":             &helpers.LoginMaxFailures,
+		"LOGIN_IP_MAX_FAILURES":          &helpers.LoginIPMaxFailures,
+		"PASSWORD_RESET_MAX_REQUESTS":    &helpers.PasswordResetMaxRequests,
+		"PASSWORD_RESET_IP_MAX_REQUESTS": &helpers.PasswordResetIPMaxRequests,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid %s: %q", name, value)
+			}
+			*maxFailures = n
+		}
+	}
+	for name, duration := range map[string]*time.Duration{
+		"LOGIN_LOCKOUT":        &helpers.LoginLockout,
+		"LOGIN_LOCKOUT_MAX":    &helpers.LoginLockoutMax,
+		"LOGIN_FAILURE_WINDOW": &helpers.LoginFailureWindow,
+	} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				log.Fatalf("Invalid %s: %v", name, err)
+			}
+			*duration = d
+		}
+	}
+
+	// Delivery of password reset tokens
+	notify, err := notifier.New(os.Getenv("NOTIFIER"), os.Getenv("NOTIFIER_FILE"))
 	if err != nil {
-		log.Fatal("Database Initialize Error: ", err)
+		log.Fatal("Invalid NOTIFIER: ", err)
 	}
-	store := utils.NewPostgresDB(db)
-	defer db.Close()
+	handlers.Notifier = notify
+	if value := os.Getenv("PASSWORD_RESET_URL"); value != "" {
+		if _, err := url.ParseRequestURI(value); err != nil {
+			log.Fatal("Invalid PASSWORD_RESET_URL: ", err)
+		}
+		handlers.PasswordResetURL = value
+	}
+
+	// Login through an OpenID Connect provider, alongside or instead of passwords
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		config := oidc.Config{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		}
+		if config.ClientID == "" || config.RedirectURL == "" {
+			log.Fatal("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set with OIDC_ISSUER")
+		}
+		if os.Getenv("OIDC_SCOPES") == "" {
+			config.Scopes = []string{"profile", "email"}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		provider, err := oidc.Discover(ctx, config, &http.Client{Timeout: 10 * time.Second})
+		cancel()
+		if err != nil {
+			log.Fatal("OIDC Initialize Error: ", err)
+		}
+		handlers.OIDC = provider
+	}
+	passwordLogin := true
+	if value := os.Getenv("PASSWORD_LOGIN"); value != "" {
+		passwordLogin, err = strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("Invalid PASSWORD_LOGIN: %q", value)
+		}
+	}
+	if !passwordLogin && handlers.OIDC == nil {
+		log.Fatal("PASSWORD_LOGIN=false requires OIDC_ISSUER")
+	}
+
+	// Gin router. Data export links carry their credential in the query
+	// string, which is kept out of the logs
+	router := gin.New()
+	router.Use(middleware.ConsoleLoggingMiddleware(os.Stdout, handlers.DataExportDownloadPath), gin.Recovery())
 
-	// Gin router
-	router := gin.Default()
+	// Client IPs are taken from X-Forwarded-For only behind the configured
+	// proxies, otherwise clients could dodge the login lockout by IP
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
 
 	// Middleware
 	router.Use(middleware.ErrorHandlerMiddleware())
-	router.Use(middleware.LoggingMiddleware())
+	router.Use(middleware.LoggingMiddleware(handlers.DataExportDownloadPath))
+	router.Use(middleware.QueryTimeoutMiddleware(queryTimeout))
 	router.Use(middleware.DatabaseMiddleware(store))
 
+	// Public keys for other services verifying our tokens
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
+
 	v1 := router.Group("/api/v1")
 	// Auth routes
 	auth := v1.Group("/auth")
 	{
-		auth.POST("/register", handlers.Register)
-		auth.POST("/login", handlers.Login)
+		if passwordLogin {
+			auth.POST("/register", handlers.Register)
+			auth.POST("/login", handlers.Login)
+			auth.POST("/password", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.ChangePassword)
+			auth.POST("/password/forgot", handlers.ForgotPassword)
+			auth.POST("/password/reset", handlers.ResetPassword)
+		}
+		if handlers.OIDC != nil {
+			auth.GET("/oidc/login", handlers.OIDCLogin)
+			auth.GET("/oidc/callback", handlers.OIDCCallback)
+		}
+		auth.POST("/login/mfa", handlers.LoginMFA)
+		auth.POST("/refresh", handlers.Refresh)
+		auth.POST("/logout", handlers.Logout)
+	}
+
+	// Personal access tokens, managed only from a login session
+	tokens := auth.Group("/tokens")
+	tokens.Use(middleware.AuthMiddleware(), middleware.RequireSession())
+	{
+		tokens.GET("/", handlers.GetPersonalAccessTokens)
+		tokens.POST("/", handlers.CreatePersonalAccessToken)
+		tokens.DELETE("/:id", handlers.DeletePersonalAccessToken)
 	}
 
+	// Two-factor authentication, managed only from a login session
+	twoFactor := auth.Group("/2fa")
+	twoFactor.Use(middleware.AuthMiddleware(), middleware.RequireSession())
+	{
+		twoFactor.GET("/", handlers.GetTwoFactor)
+		twoFactor.POST("/setup", handlers.SetupTwoFactor)
+		twoFactor.POST("/confirm", handlers.ConfirmTwoFactor)
+		twoFactor.POST("/disable", handlers.DisableTwoFactor)
+	}
+
+	// Profile of the logged in user, managed only from a login session
+	me := v1.Group("/me")
+	me.Use(middleware.AuthMiddleware(), middleware.RequireSession())
+	{
+		me.GET("", handlers.GetMe)
+		me.PATCH("", handlers.UpdateMe)
+		me.DELETE("", handlers.DeleteMe)
+		me.POST("/exports", handlers.CreateDataExport)
+		me.GET("/exports/:id", handlers.GetDataExport)
+	}
+	// Export downloads carry their own token or signed link, so browsers can
+	// follow the link
+	v1.GET("/exports/download", handlers.DownloadDataExport)
+
 	// Protected Tasks Routes
 	tasks := v1.Group("/tasks")
-	tasks.Use(middleware.AuthMiddleware())
+	tasks.Use(middleware.AuthMiddleware(), middleware.RequireTaskScope())
 	{
 		tasks.GET("/", handlers.GetTasks)
 		tasks.POST("/", handlers.CreateTask)
 		tasks.GET("/:id", handlers.GetTaskByID)
 		tasks.PUT("/:id", handlers.UpdateTask)
+		tasks.PATCH("/:id", handlers.PatchTask)
 		tasks.DELETE("/:id", handlers.DeleteTask)
-		tasks.PUT("/mark-done", handlers.MarkTasksDoneConcurrently)
+		tasks.PUT("/mark-done", handlers.MarkTasksDone)
+		tasks.POST("/bulk", handlers.BulkTasks)
+		tasks.GET("/:id/children", handlers.GetTaskChildren)
+		tasks.PUT("/:id/parent", handlers.MoveTask)
+		tasks.POST("/:id/blockers", handlers.AddTaskBlocker)
+		tasks.DELETE("/:id/blockers/:blocker_id", handlers.RemoveTaskBlocker)
+	}
+
+	// Protected Tags Routes
+	tags := v1.Group("/tags")
+	tags.Use(middleware.AuthMiddleware(), middleware.RequireTaskScope())
+	{
+		tags.GET("/", handlers.GetTags)
+		tags.POST("/", handlers.CreateTag)
+		tags.GET("/:id", handlers.GetTagByID)
+		tags.PUT("/:id", handlers.UpdateTag)
+		tags.DELETE("/:id", handlers.DeleteTag)
+	}
+
+	// Admin Routes, every request is recorded in the audit log
+	admin := v1.Group("/admin")
+	admin.Use(middleware.AuthMiddleware(), middleware.AuditMiddleware(), middleware.RequireSession(), middleware.RequireRole(utils.RoleAdmin))
+	{
+		admin.GET("/users", handlers.GetUsers)
+		admin.PUT("/users/:id/role", handlers.SetUserRole)
+		admin.PUT("/users/:id/disable", handlers.DisableUser)
+		admin.PUT("/users/:id/enable", handlers.EnableUser)
+		admin.GET("/users/:id/tasks", handlers.GetUserTasks)
+		admin.GET("/audit", handlers.GetAuditLog)
 	}
 
 	// Swagger documentation route
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Parjun2000/task-manager/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditTimeout bounds writing an audit entry, which happens after the request
+// was handled and so may outlive its deadline.
+const auditTimeout = 5 * time.Second
+
+// AuditMiddleware records every request of the authenticated user in the
+// audit log once it has been handled, with the user given by the id route
+// parameter as its target. Entries that cannot be written are logged.
+func AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		actorID, ok := c.Get("user_id")
+		if !ok {
+			return
+		}
+		entry := utils.AuditEntry{
+			ActorID:    actorID.(int),
+			Action:     c.Request.Method + " " + c.FullPath(),
+			StatusCode: c.Writer.Status(),
+		}
+		if id, err := strconv.Atoi(c.Param("id")); err == nil {
+			entry.TargetUserID = &id
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), auditTimeout)
+		defer cancel()
+		s, _ := c.Get("db")
+		db := s.(utils.Storage)
+		if err := db.CreateAuditEntry(ctx, entry); err != nil {
+			log.Printf("Recording %s by user %d in the audit log failed: %v", entry.Action, entry.ActorID, err)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package middleware
 
 import (
-	"os"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/Parjun2000/task-manager/helpers"
@@ -11,12 +12,15 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_KEY"))
-
+// AuthMiddleware accepts a JWT access token or a personal access token in the
+// Authorization header. For personal access tokens it also sets token_scope.
+//
 // @Failure	401	{object}	object{error=string}	"Unauthorized: Missing token"
 // @Failure	401	{object}	object{error=string}	"Unauthorized: Invalid token"
+// @Failure	401	{object}	object{error=string}	"Unauthorized: Token revoked"
 // @Failure	401	{object}	object{error=string}	"Unauthorized"
 // @Failure	403	{object}	object{error=string}	"Unauthorized: Token expired"
+// @Failure	403	{object}	object{error=string}	"Forbidden: Account disabled"
 // @Failure	500	{object}	object{error=string}	"Internal Server Error"
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -27,12 +31,35 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.ParseWithClaims(tokenString, &helpers.Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		s, _ := c.Get("db")
+		db := s.(utils.Storage)
+		if strings.HasPrefix(tokenString, helpers.PersonalAccessTokenPrefix) {
+			token, err := db.UsePersonalAccessToken(c.Request.Context(), helpers.HashToken(tokenString))
+			if err != nil {
+				if errors.Is(err, utils.ErrPersonalAccessTokenInvalid) {
+					c.JSON(401, gin.H{"error": "Unauthorized: Invalid token"})
+					c.Abort()
+					return
+				}
+				helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+				c.Abort()
+				return
+			}
+			user, err := db.GetUserByID(c.Request.Context(), token.UserID)
+			if err != nil {
+				helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+				c.Abort()
+				return
+			}
+			c.Set("token_scope", token.Scope)
+			authenticate(c, user)
+			return
+		}
 
+		claims, err := helpers.ParseJWT(tokenString)
 		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
+			var validationErr *jwt.ValidationError
+			if errors.As(err, &validationErr) && validationErr.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0 {
 				c.JSON(401, gin.H{"error": "Unauthorized: Invalid token"})
 				c.Abort()
 				return
@@ -42,34 +69,85 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if !token.Valid {
-			c.JSON(401, gin.H{"error": "Unauthorized: Invalid token"})
+		if time.Now().Unix() > claims.ExpiresAt {
+			c.JSON(403, gin.H{"error": "Unauthorized: Token expired"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(*helpers.Claims)
-		if !ok {
-			c.JSON(401, gin.H{"error": "Unauthorized"})
+		if claims.Id == "" {
+			c.JSON(401, gin.H{"error": "Unauthorized: Invalid token"})
 			c.Abort()
 			return
 		}
 
-		if time.Now().Unix() > claims.ExpiresAt {
-			c.JSON(403, gin.H{"error": "Unauthorized: Token expired"})
+		// Logout and refresh token reuse revoke access tokens before they expire
+		revoked, err := db.IsAccessTokenRevoked(c.Request.Context(), claims.Id)
+		if err != nil {
+			helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(401, gin.H{"error": "Unauthorized: Token revoked"})
 			c.Abort()
 			return
 		}
 
-		c.Set("username", claims.Username)
-		s, _ := c.Get("db")
-		user, err := s.(utils.Storage).GetUserByUsername(claims.Username)
+		user, err := db.GetUserByUsername(c.Request.Context(), claims.Username)
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Internal Server Error"})
+			helpers.RespondStorageError(c, err, 500, "Internal Server Error")
+			c.Abort()
+			return
+		}
+		c.Set("token_id", claims.Id)
+		if claims.AuthTime != 0 {
+			c.Set("auth_time", time.Unix(claims.AuthTime, 0))
+		}
+		authenticate(c, user)
+	}
+}
+
+// authenticate lets the request of an enabled user through.
+func authenticate(c *gin.Context, user utils.User) {
+	if user.DisabledAt != nil {
+		c.JSON(403, gin.H{"error": "Forbidden: Account disabled"})
+		c.Abort()
+		return
+	}
+	c.Set("username", user.Username)
+	c.Set("user_id", user.ID)
+	c.Set("role", user.Role)
+	c.Next()
+}
+
+// RequireSession rejects personal access tokens, for routes that manage the
+// account itself and so need a login.
+//
+// @Failure	403	{object}	object{error=string}	"Forbidden: Personal access tokens cannot be used here"
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("token_scope"); ok {
+			c.JSON(403, gin.H{"error": "Forbidden: Personal access tokens cannot be used here"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireTaskScope limits personal access tokens with the tasks:read scope to
+// reading. Logged in users are not limited.
+//
+// @Failure	403	{object}	object{error=string}	"Forbidden: Token scope does not allow changes"
+func RequireTaskScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := c.GetString("token_scope")
+		if scope == utils.ScopeTasksRead && c.Request.Method != "GET" && c.Request.Method != "HEAD" {
+			c.JSON(403, gin.H{"error": "Forbidden: Token scope does not allow changes"})
 			c.Abort()
 			return
 		}
-		c.Set("user_id", user.ID)
 		c.Next()
 	}
 }
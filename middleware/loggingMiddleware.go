@@ -9,7 +9,9 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func LoggingMiddleware() gin.HandlerFunc {
+// LoggingMiddleware logs every request to app.log. The query string is left
+// out for secretQueryPaths, whose query carries a credential.
+func LoggingMiddleware(secretQueryPaths ...string) gin.HandlerFunc {
 	// Logging to a file.
 	f, _ := os.Create("app.log")
 	gin.DefaultWriter = io.MultiWriter(f)
@@ -20,7 +22,7 @@ func LoggingMiddleware() gin.HandlerFunc {
 			param.ClientIP,
 			param.TimeStamp.Format(time.RFC1123),
 			param.Method,
-			param.Path,
+			loggedPath(param, secretQueryPaths),
 			param.Request.Proto,
 			param.StatusCode,
 			param.Latency,
@@ -29,3 +31,42 @@ func LoggingMiddleware() gin.HandlerFunc {
 		)
 	})
 }
+
+// ConsoleLoggingMiddleware logs every request to out in gin's default format,
+// leaving out the query string for secretQueryPaths like LoggingMiddleware.
+func ConsoleLoggingMiddleware(out io.Writer, secretQueryPaths ...string) gin.HandlerFunc {
+	return gin.LoggerWithConfig(gin.LoggerConfig{
+		Output: out,
+		Formatter: func(param gin.LogFormatterParams) string {
+			var statusColor, methodColor, resetColor string
+			if param.IsOutputColor() {
+				statusColor = param.StatusCodeColor()
+				methodColor = param.MethodColor()
+				resetColor = param.ResetColor()
+			}
+			if param.Latency > time.Minute {
+				param.Latency = param.Latency.Truncate(time.Second)
+			}
+			return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+				param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+				statusColor, param.StatusCode, resetColor,
+				param.Latency,
+				param.ClientIP,
+				methodColor, param.Method, resetColor,
+				loggedPath(param, secretQueryPaths),
+				param.ErrorMessage,
+			)
+		},
+	})
+}
+
+// loggedPath returns the path of a request as it is logged: with the query
+// string, unless the path is one of secretQueryPaths.
+func loggedPath(param gin.LogFormatterParams, secretQueryPaths []string) string {
+	for _, path := range secretQueryPaths {
+		if param.Request.URL.Path == path {
+			return path
+		}
+	}
+	return param.Path
+}
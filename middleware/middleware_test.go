@@ -1,12 +1,20 @@
 package middleware
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/Parjun2000/task-manager/helpers"
+	"github.com/Parjun2000/task-manager/signing"
 	"github.com/Parjun2000/task-manager/utils"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -39,8 +47,339 @@ func TestAuthMiddleware(t *testing.T) {
 	// Add more assertions as needed
 }
 
+func TestAuthMiddlewareRevocation(t *testing.T) {
+	ctx := context.Background()
+	store := utils.NewMemoryDB()
+	userID, err := store.CreateUser(ctx, utils.User{Username: "user1", Password: "hashed-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshHash := helpers.HashToken("refresh-token")
+	err = store.CreateRefreshToken(ctx, utils.RefreshToken{UserID: userID, FamilyID: "family", TokenHash: refreshHash, AccessJTI: "jti", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(DatabaseMiddleware(store))
+	router.Use(AuthMiddleware())
+	router.GET("/tasks", func(c *gin.Context) {
+		c.String(200, "Authorized")
+	})
+	serve := func(jti string) int {
+		token, err := helpers.GenerateJWT("user1", utils.RoleUser, jti, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("GET", "/tasks", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 200, serve("jti"))
+	assert.Equal(t, 401, serve(""))
+	assert.Equal(t, 401, serve("unknown"))
+
+	// Logging out revokes the access token before it expires
+	if err := store.RevokeRefreshTokenFamily(ctx, refreshHash); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, serve("jti"))
+}
+
+func TestAuthMiddlewareAuthTime(t *testing.T) {
+	ctx := context.Background()
+	store := utils.NewMemoryDB()
+	userID, err := store.CreateUser(ctx, utils.User{Username: "user1", Password: "hashed-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.CreateRefreshToken(ctx, utils.RefreshToken{UserID: userID, FamilyID: "family", TokenHash: helpers.HashToken("refresh-token"), AccessJTI: "jti", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(DatabaseMiddleware(store))
+	router.Use(AuthMiddleware())
+	router.GET("/auth-time", func(c *gin.Context) {
+		authTime, ok := c.Get("auth_time")
+		if !ok {
+			c.String(200, "none")
+			return
+		}
+		c.String(200, "%d", authTime.(time.Time).Unix())
+	})
+	serve := func(authTime time.Time) string {
+		token, err := helpers.GenerateJWT("user1", utils.RoleUser, "jti", authTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("GET", "/auth-time", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// Only tokens issued by a login tell when it was
+	loggedIn := time.Now().Add(-time.Minute)
+	assert.Equal(t, fmt.Sprint(loggedIn.Unix()), serve(loggedIn))
+	assert.Equal(t, "none", serve(time.Time{}))
+}
+
+func TestAuthMiddlewareSigningKeys(t *testing.T) {
+	ctx := context.Background()
+	store := utils.NewMemoryDB()
+	userID, err := store.CreateUser(ctx, utils.User{Username: "user1", Password: "hashed-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.CreateRefreshToken(ctx, utils.RefreshToken{UserID: userID, FamilyID: "family", TokenHash: helpers.HashToken("refresh-token"), AccessJTI: "jti", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(DatabaseMiddleware(store))
+	router.Use(AuthMiddleware())
+	router.GET("/tasks", func(c *gin.Context) {
+		c.String(200, "Authorized")
+	})
+	serve := func(token string) int {
+		req, err := http.NewRequest("GET", "/tasks", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, helpers.Claims{Username: "user1", StandardClaims: jwt.StandardClaims{Id: "jti", ExpiresAt: time.Now().Add(time.Minute).Unix()}})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	current, ok := signing.Keys.Current()
+	if !ok {
+		t.Fatal("no signing key")
+	}
+	assert.Equal(t, 200, serve(sign(current.Method(), current.ID, current.Private)))
+
+	// Keys must be known by kid
+	assert.Equal(t, 401, serve(sign(current.Method(), "", current.Private)))
+	assert.Equal(t, 401, serve(sign(current.Method(), "unknown", current.Private)))
+
+	// The algorithm is pinned: HS256 with the public key as secret is rejected
+	public := []byte(current.Public().(ed25519.PublicKey))
+	assert.Equal(t, 401, serve(sign(jwt.SigningMethodHS256, current.ID, public)))
+	assert.Equal(t, 401, serve(sign(jwt.SigningMethodNone, current.ID, jwt.UnsafeAllowNoneSignatureType)))
+
+	// Tokens signed by a key that is not in the set are rejected
+	stranger, err := signing.GenerateKey(signing.EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, serve(sign(stranger.Method(), current.ID, stranger.Private)))
+
+	// After a rotation both keys verify
+	next, err := signing.GenerateKey(signing.RS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing.Keys.Set(current, next)
+	defer signing.Keys.Set(current)
+	assert.Equal(t, 200, serve(sign(next.Method(), next.ID, next.Private)))
+	assert.Equal(t, 200, serve(sign(current.Method(), current.ID, current.Private)))
+	assert.Equal(t, 401, serve(sign(current.Method(), next.ID, current.Private)), "EdDSA is not accepted for an RS256 key")
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	ctx := context.Background()
+	store := utils.NewMemoryDB()
+	for _, user := range []utils.User{
+		{Username: "admin1", Password: "hashed-password", Role: utils.RoleAdmin},
+		{Username: "user1", Password: "hashed-password"},
+	} {
+		userID, err := store.CreateUser(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.CreateRefreshToken(ctx, utils.RefreshToken{UserID: userID, FamilyID: user.Username, TokenHash: helpers.HashToken(user.Username),
+			AccessJTI: user.Username, ExpiresAt: time.Now().Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	router.Use(DatabaseMiddleware(store))
+	admin := router.Group("/admin", AuthMiddleware(), AuditMiddleware(), RequireRole(utils.RoleAdmin))
+	admin.PUT("/users/:id/disable", func(c *gin.Context) {
+		c.String(200, "Disabled")
+	})
+	serve := func(username string) int {
+		// The role claim is ignored, the stored role decides
+		token, err := helpers.GenerateJWT(username, utils.RoleAdmin, username, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("PUT", "/admin/users/2/disable", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 200, serve("admin1"))
+	assert.Equal(t, 403, serve("user1"))
+
+	// Both requests are recorded, the rejected one too
+	entries, err := store.GetAuditLog(ctx, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, 2, entries[0].ActorID)
+		assert.Equal(t, 403, entries[0].StatusCode)
+		assert.Equal(t, 1, entries[1].ActorID)
+		assert.Equal(t, "PUT /admin/users/:id/disable", entries[1].Action)
+		assert.Equal(t, 2, *entries[1].TargetUserID)
+		assert.Equal(t, 200, entries[1].StatusCode)
+	}
+
+	// Disabling an account revokes its tokens
+	if err := store.SetUserDisabled(ctx, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 401, serve("admin1"))
+}
+
+func TestPersonalAccessTokenMiddleware(t *testing.T) {
+	ctx := context.Background()
+	store := utils.NewMemoryDB()
+	userID, err := store.CreateUser(ctx, utils.User{Username: "user1", Password: "hashed-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(name, scope string) string {
+		token, hash, err := helpers.GeneratePersonalAccessToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.CreatePersonalAccessToken(ctx, utils.PersonalAccessToken{UserID: userID, Name: name, Scope: scope, TokenHash: hash}); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	readToken := create("read", utils.ScopeTasksRead)
+	writeToken := create("write", utils.ScopeTasksWrite)
+
+	router := gin.New()
+	router.Use(DatabaseMiddleware(store))
+	tasks := router.Group("/tasks", AuthMiddleware(), RequireTaskScope())
+	tasks.GET("/", func(c *gin.Context) {
+		c.String(200, "%d", c.GetInt("user_id"))
+	})
+	tasks.POST("/", func(c *gin.Context) {
+		c.String(200, "Created")
+	})
+	router.GET("/auth/tokens", AuthMiddleware(), RequireSession(), func(c *gin.Context) {
+		c.String(200, "Tokens")
+	})
+	serve := func(method, url, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/tasks/", readToken)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "1", w.Body.String())
+	assert.Equal(t, 403, serve("POST", "/tasks/", readToken).Code)
+	assert.Equal(t, 200, serve("POST", "/tasks/", writeToken).Code)
+	assert.Equal(t, 403, serve("GET", "/auth/tokens", writeToken).Code)
+	assert.Equal(t, 401, serve("GET", "/tasks/", helpers.PersonalAccessTokenPrefix+"unknown").Code)
+
+	// Disabled users cannot use their tokens
+	if err := store.SetUserDisabled(ctx, userID, true); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 403, serve("GET", "/tasks/", readToken).Code)
+}
+
+func TestQueryTimeoutMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(QueryTimeoutMiddleware(time.Minute))
+	router.GET("/deadline", func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+		c.String(200, "OK")
+	})
+
+	req, err := http.NewRequest("GET", "/deadline", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestConsoleLoggingMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	router := gin.New()
+	router.Use(ConsoleLoggingMiddleware(&out, "/download"))
+	router.GET("/download", func(c *gin.Context) { c.String(200, "OK") })
+	router.GET("/tasks", func(c *gin.Context) { c.String(200, "OK") })
+
+	for _, target := range []string{"/download?link=secret", "/tasks?page=2"} {
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Only the paths whose query carries a credential lose it
+	assert.Contains(t, out.String(), `"/download"`)
+	assert.NotContains(t, out.String(), "secret")
+	assert.Contains(t, out.String(), `"/tasks?page=2"`)
+}
+
 func setup() {
 	testRouter.Use(mockDB())
+
+	// Sign test tokens with a fresh key
+	key, err := signing.GenerateKey(signing.EdDSA)
+	if err != nil {
+		panic(err)
+	}
+	signing.Keys.Set(key)
 }
 
 func teardown() {
@@ -49,8 +388,8 @@ func teardown() {
 
 func mockDB() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Passing MockDatabase in context
-		s := utils.NewMockDB()
+		// Passing an in-memory database in context
+		s := utils.NewMemoryDB()
 		c.Set("db", s)
 		c.Next()
 	}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets users with one of the given roles through. It runs
+// after AuthMiddleware, which sets the role of the authenticated user.
+//
+// @Failure	403	{object}	object{error=string}	"Forbidden: Insufficient role"
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(403, gin.H{"error": "Forbidden: Insufficient role"})
+		c.Abort()
+	}
+}
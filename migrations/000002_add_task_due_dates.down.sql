@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tasks_user_id_due_at_idx;
+ALTER TABLE tasks DROP COLUMN IF EXISTS due_timezone;
+ALTER TABLE tasks DROP COLUMN IF EXISTS due_at;
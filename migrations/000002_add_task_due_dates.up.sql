@@ -0,0 +1,5 @@
+-- Optional due date, stored in UTC, with the IANA timezone it was set in
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_at TIMESTAMPTZ;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_timezone VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS tasks_user_id_due_at_idx ON tasks (user_id, due_at);
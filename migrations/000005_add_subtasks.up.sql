@@ -0,0 +1,4 @@
+-- Optional parent task; deleting a parent is handled by the API according to SUBTASK_DELETE_POLICY
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES tasks(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS tasks_parent_id_idx ON tasks (parent_id);
@@ -0,0 +1,2 @@
+-- Optional RFC 5545 RRULE, marking a recurring task done creates its next occurrence
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS recurrence VARCHAR(255);
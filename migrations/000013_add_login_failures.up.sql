@@ -0,0 +1,10 @@
+-- Consecutive failed logins per username and per client IP, keyed as
+-- "user:<username>" or "ip:<address>". Usernames are tracked whether or not
+-- the user exists, so lockouts do not reveal which usernames are taken.
+CREATE TABLE IF NOT EXISTS login_failures (
+    key VARCHAR(300) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_failures_last_failure_at_idx ON login_failures (last_failure_at);
@@ -0,0 +1,9 @@
+-- Keys that sign access tokens, shared by every instance of the API. The
+-- newest key signs, older ones still verify the tokens they signed.
+-- private_key is PKCS #8, encrypted with a key derived from JWT_KEY.
+CREATE TABLE IF NOT EXISTS signing_keys (
+    kid VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(10) NOT NULL CHECK (algorithm IN ('RS256', 'EdDSA')),
+    private_key BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
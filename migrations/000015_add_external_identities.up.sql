@@ -0,0 +1,23 @@
+-- Users signed in through an OpenID Connect provider, linked by the issuer
+-- and subject of their ID tokens. Such users have no password.
+CREATE TABLE IF NOT EXISTS external_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS external_identities_user_id_idx ON external_identities (user_id);
+
+-- Logins waiting for the provider to redirect back, by the hash of their
+-- state parameter. The PKCE verifier and nonce never leave the server.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    id SERIAL PRIMARY KEY,
+    state_hash CHAR(64) NOT NULL UNIQUE,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
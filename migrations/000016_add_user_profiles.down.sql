@@ -0,0 +1,6 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS default_sort_order,
+    DROP COLUMN IF EXISTS default_sort_by,
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS email,
+    DROP COLUMN IF EXISTS display_name;
@@ -0,0 +1,8 @@
+-- Profile and preferences users manage themselves
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS display_name VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS email VARCHAR(254) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    ADD COLUMN IF NOT EXISTS default_sort_by VARCHAR(20) NOT NULL DEFAULT 'created_at'
+        CHECK (default_sort_by IN ('title', 'description', 'status', 'created_at', 'due_at', 'priority')),
+    ADD COLUMN IF NOT EXISTS default_sort_order VARCHAR(4) NOT NULL DEFAULT 'desc' CHECK (default_sort_order IN ('asc', 'desc'));
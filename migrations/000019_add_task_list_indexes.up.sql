@@ -0,0 +1,2 @@
+-- Pages read with a cursor seek to their first task in the default sort order
+CREATE INDEX IF NOT EXISTS tasks_user_id_created_at_id_idx ON tasks (user_id, created_at, id);
@@ -0,0 +1,5 @@
+-- Sealed secrets cannot be read without sealing, so their users set up
+-- two-factor authentication again
+DELETE FROM recovery_codes WHERE user_id IN (SELECT user_id FROM totp_credentials WHERE secret LIKE 'sealed:%');
+DELETE FROM totp_credentials WHERE secret LIKE 'sealed:%';
+ALTER TABLE totp_credentials ALTER COLUMN secret TYPE VARCHAR(64);
@@ -0,0 +1,4 @@
+-- TOTP secrets are sealed with JWT_KEY, which makes them longer. Secrets
+-- stored before are read as they are until two-factor authentication is set
+-- up again.
+ALTER TABLE totp_credentials ALTER COLUMN secret TYPE VARCHAR(256);
@@ -0,0 +1,41 @@
+package models
+
+import "errors"
+
+// MaxPasswordBytes is the longest password bcrypt hashes. The validator
+// counts characters, so passwords are also checked in bytes.
+const MaxPasswordBytes = 72
+
+var errPasswordTooLong = errors.New("new_password must be at most 72 bytes")
+
+// PasswordChange example
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+func (p *PasswordChange) Validate() error {
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+	if len(p.NewPassword) > MaxPasswordBytes {
+		return errPasswordTooLong
+	}
+	return nil
+}
+
+// PasswordReset example
+type PasswordReset struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+func (p *PasswordReset) Validate() error {
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+	if len(p.NewPassword) > MaxPasswordBytes {
+		return errPasswordTooLong
+	}
+	return nil
+}
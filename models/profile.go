@@ -0,0 +1,39 @@
+package models
+
+import "errors"
+
+// ProfileUpdate example
+type ProfileUpdate struct {
+	// Fields left out keep their value; display_name and email can be
+	// cleared with an empty string.
+	DisplayName      *string `json:"display_name" validate:"omitnil,max=100"`
+	Email            *string `json:"email" validate:"omitnil,max=254"`
+	Timezone         *string `json:"timezone" validate:"omitnil,timezone" example:"Europe/Berlin"`
+	DefaultSortBy    *string `json:"default_sort_by" validate:"omitnil,oneof=title description status created_at due_at priority"`
+	DefaultSortOrder *string `json:"default_sort_order" validate:"omitnil,oneof=asc desc"`
+}
+
+func (p *ProfileUpdate) Validate() error {
+	if err := validate.Struct(p); err != nil {
+		return err
+	}
+	if p.Email != nil && *p.Email != "" {
+		if err := validate.Var(*p.Email, "email"); err != nil {
+			return errors.New("invalid email")
+		}
+	}
+	return nil
+}
+
+// AccountDeletion example
+type AccountDeletion struct {
+	// Password is left out by users of the identity provider, who have none.
+	Password string `json:"password"`
+	// Code is a current TOTP code or an unused recovery code, required when
+	// two-factor authentication is enabled.
+	Code string `json:"code"`
+}
+
+func (a *AccountDeletion) Validate() error {
+	return validate.Struct(a)
+}
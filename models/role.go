@@ -0,0 +1,10 @@
+package models
+
+// RoleChange example
+type RoleChange struct {
+	Role string `json:"role" validate:"required,oneof=user admin" enums:"user,admin"`
+}
+
+func (r *RoleChange) Validate() error {
+	return validate.Struct(r)
+}
@@ -0,0 +1,11 @@
+package models
+
+// Tag example
+type Tag struct {
+	ID   int    `json:"id"`
+	Name string `json:"name" validate:"required,max=50"`
+}
+
+func (t *Tag) Validate() error {
+	return validate.Struct(t)
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"time"
 
+	"github.com/Parjun2000/task-manager/rrule"
+
 	"github.com/go-playground/validator/v10"
 )
 
@@ -10,15 +12,25 @@ var validate *validator.Validate
 
 func init() {
 	validate = validator.New()
+	validate.RegisterValidation("rrule", func(fl validator.FieldLevel) bool {
+		_, err := rrule.Parse(fl.Field().String())
+		return err == nil
+	})
 }
 
 // Task example
 type Task struct {
-	ID          string    `json:"id"`
-	Title       string    `json:"title" validate:"required"`
-	Description string    `json:"description" validate:"required"`
-	Status      string    `json:"status" validate:"required"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          string     `json:"id"`
+	Title       string     `json:"title" validate:"required"`
+	Description string     `json:"description" validate:"required"`
+	Status      string     `json:"status" validate:"required"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DueAt       *time.Time `json:"due_at"`
+	DueTimezone string     `json:"due_timezone" validate:"omitempty,timezone,excluded_without=DueAt"`
+	Priority    string     `json:"priority" validate:"omitempty,oneof=low medium high urgent"`
+	Tags        []string   `json:"tags" validate:"omitempty,max=20,dive,required,max=50"`
+	ParentID    *int       `json:"parent_id" validate:"omitempty,min=1"`
+	Recurrence  string     `json:"recurrence" validate:"omitempty,max=255,rrule,excluded_without=DueAt"`
 }
 
 func (t *Task) Validate() error {
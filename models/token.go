@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// PersonalAccessToken example
+type PersonalAccessToken struct {
+	Name      string     `json:"name" validate:"required,max=50"`
+	Scope     string     `json:"scope" validate:"required,oneof=tasks:read tasks:write" enums:"tasks:read,tasks:write"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (t *PersonalAccessToken) Validate() error {
+	return validate.Struct(t)
+}
@@ -0,0 +1,33 @@
+package models
+
+// TwoFactorCode example
+type TwoFactorCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+func (t *TwoFactorCode) Validate() error {
+	return validate.Struct(t)
+}
+
+// TwoFactorDisable example
+type TwoFactorDisable struct {
+	// Password is left out by users of the identity provider, who have none.
+	Password string `json:"password"`
+	// Code is a current TOTP code or an unused recovery code.
+	Code string `json:"code" validate:"required"`
+}
+
+func (t *TwoFactorDisable) Validate() error {
+	return validate.Struct(t)
+}
+
+// MFALogin example
+type MFALogin struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	// Code is a current TOTP code or an unused recovery code.
+	Code string `json:"code" validate:"required"`
+}
+
+func (m *MFALogin) Validate() error {
+	return validate.Struct(m)
+}
@@ -0,0 +1,94 @@
+// Package notifier delivers messages such as password reset links to users.
+//
+// Deployments plug in their own Notifier (email, chat, ...); the log and
+// file implementations here are meant for local development.
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message is a notification for a single user.
+type Message struct {
+	// To is the username of the recipient.
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// LogNotifier writes messages to a logger.
+type LogNotifier struct {
+	Logger *log.Logger
+}
+
+// Create LogNotifier, writing to the standard logger when logger is nil.
+func NewLogNotifier(logger *log.Logger) *LogNotifier {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &LogNotifier{Logger: logger}
+}
+
+// Notify logs the message.
+func (n *LogNotifier) Notify(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n.Logger.Printf("notification to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileNotifier appends messages to a file, one block per message.
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Create FileNotifier
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{Path: path}
+}
+
+// Notify appends the message to the file.
+func (n *FileNotifier) Notify(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// New returns the notifier for a NOTIFIER setting: "log" (the default) or
+// "file", which writes to path.
+func New(kind, path string) (Notifier, error) {
+	switch kind {
+	case "", "log":
+		return NewLogNotifier(nil), nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("file notifier needs a path")
+		}
+		return NewFileNotifier(path), nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", kind)
+}
@@ -0,0 +1,50 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogNotifier(t *testing.T) {
+	var buf bytes.Buffer
+	n := NewLogNotifier(log.New(&buf, "", 0))
+	require.NoError(t, n.Notify(context.Background(), Message{To: "user1", Subject: "Hello", Body: "World"}))
+	assert.Equal(t, "notification to user1: Hello\nWorld\n", buf.String())
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.txt")
+	n := NewFileNotifier(path)
+	require.NoError(t, n.Notify(context.Background(), Message{To: "user1", Subject: "First", Body: "one"}))
+	require.NoError(t, n.Notify(context.Background(), Message{To: "user2", Subject: "Second", Body: "two"}))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "To: user1\nSubject: First\n\none\n")
+	assert.Contains(t, string(content), "To: user2\nSubject: Second\n\ntwo\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, n.Notify(ctx, Message{To: "user3"}), context.Canceled)
+}
+
+func TestNew(t *testing.T) {
+	n, err := New("", "")
+	require.NoError(t, err)
+	assert.IsType(t, &LogNotifier{}, n)
+	n, err = New("file", "outbox.txt")
+	require.NoError(t, err)
+	assert.IsType(t, &FileNotifier{}, n)
+
+	_, err = New("file", "")
+	assert.Error(t, err)
+	_, err = New("pigeon", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,11 @@
+package oidc
+
+import "time"
+
+// SetKeysRefreshInterval changes how often keys are fetched again and
+// returns a function restoring it.
+func SetKeysRefreshInterval(interval time.Duration) func() {
+	previous := keysRefreshInterval
+	keysRefreshInterval = interval
+	return func() { keysRefreshInterval = previous }
+}
@@ -0,0 +1,365 @@
+// Package oidc signs users in through an OpenID Connect provider, with the
+// authorization code flow protected by PKCE (RFC 7636). ID tokens are
+// verified against the keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Parjun2000/task-manager/signing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ErrInvalidIDToken is returned when an ID token fails verification.
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// KeyFetchError is returned when the provider's keys cannot be fetched to
+// verify an ID token. The token itself may be valid.
+type KeyFetchError struct {
+	Err error
+}
+
+func (e *KeyFetchError) Error() string {
+	return "fetching provider keys: " + e.Err.Error()
+}
+
+func (e *KeyFetchError) Unwrap() error {
+	return e.Err
+}
+
+// leeway is the clock difference allowed with the provider.
+const leeway = time.Minute
+
+// keysRefreshInterval limits how often the provider's keys are fetched again
+// for a token signed by an unknown key.
+var keysRefreshInterval = 10 * time.Second
+
+// Config of the client registered with the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes requested besides openid.
+	Scopes []string
+}
+
+// Provider is an OpenID Connect provider, configured by discovery.
+type Provider struct {
+	Config
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	client        *http.Client
+	mu            sync.Mutex
+	keys          map[string]signing.JWK
+	keysFetchedAt time.Time
+}
+
+// Error is an error response of the provider (RFC 6749 section 5.2).
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oidc: " + e.Code
+	}
+	return "oidc: " + e.Code + ": " + e.Description
+}
+
+// IDToken holds the verified claims of an ID token that identify the user.
+type IDToken struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Discover reads the provider's configuration from its discovery document.
+// A nil client means http.DefaultClient.
+func Discover(ctx context.Context, config Config, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var document struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, client, discoveryURL, &document); err != nil {
+		return nil, err
+	}
+	// The issuer must be the one we asked, or ID tokens would not match it
+	if document.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", document.Issuer, config.Issuer)
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" || document.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+	return &Provider{
+		Config:                config,
+		AuthorizationEndpoint: document.AuthorizationEndpoint,
+		TokenEndpoint:         document.TokenEndpoint,
+		JWKSURI:               document.JWKSURI,
+		client:                client,
+	}, nil
+}
+
+// NewPKCE returns a new PKCE code verifier and its S256 code challenge.
+func NewPKCE() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	return verifier, CodeChallenge(verifier), nil
+}
+
+// CodeChallenge returns the S256 code challenge of a PKCE code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider page where the user logs in. The provider
+// redirects back to RedirectURL with the state and a code to Exchange.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades an authorization code and its PKCE code verifier for an
+// ID token. Rejections by the provider are returned as *Error.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		providerErr := &Error{}
+		if json.Unmarshal(body, providerErr) != nil || providerErr.Code == "" {
+			return "", fmt.Errorf("oidc: token endpoint returned %s", resp.Status)
+		}
+		return "", providerErr
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("oidc: token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// idTokenClaims are the claims of an ID token (OpenID Connect Core 1.0
+// section 2). The audience may be a string or a list.
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Valid checks the times of the token, the rest is checked by Verify.
+func (c *idTokenClaims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)) {
+		return errors.New("token is expired")
+	}
+	if c.IssuedAt == 0 || now.Before(time.Unix(c.IssuedAt, 0).Add(-leeway)) {
+		return errors.New("token is issued in the future")
+	}
+	return nil
+}
+
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and returns its claims. Failures wrap ErrInvalidIDToken, except for
+// a *KeyFetchError when the provider's keys cannot be fetched.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (IDToken, error) {
+	claims := &idTokenClaims{}
+	parser := jwt.Parser{ValidMethods: []string{"RS256", "ES256", signing.EdDSA}}
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		// The key decides the algorithm, the token cannot pick another one
+		if key.Algorithm != "" && key.Algorithm != token.Method.Alg() {
+			return nil, fmt.Errorf("key %q is not for %s", kid, token.Method.Alg())
+		}
+		public, err := key.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		return public, nil
+	})
+	if err != nil {
+		// The parser keeps what the key func returned as the inner error
+		var validationErr *jwt.ValidationError
+		var fetchErr *KeyFetchError
+		if errors.As(err, &validationErr) && errors.As(validationErr.Inner, &fetchErr) {
+			return IDToken{}, fetchErr
+		}
+		return IDToken{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return IDToken{}, fmt.Errorf("%w: issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.ClientID):
+		return IDToken{}, fmt.Errorf("%w: audience %v", ErrInvalidIDToken, []string(claims.Audience))
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return IDToken{}, fmt.Errorf("%w: authorized party %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	case claims.Nonce != nonce:
+		return IDToken{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return IDToken{}, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return IDToken{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// key returns the provider's key with the given kid, fetching the keys again
+// when it is unknown, since the provider may have rotated them.
+func (p *Provider) key(ctx context.Context, kid string) (signing.JWK, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < keysRefreshInterval {
+		return signing.JWK{}, fmt.Errorf("unknown key %q", kid)
+	}
+	var jwks signing.JWKS
+	if err := getJSON(ctx, p.client, p.JWKSURI, &jwks); err != nil {
+		return signing.JWK{}, &KeyFetchError{Err: err}
+	}
+	p.keys = make(map[string]signing.JWK, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		if key.Use == "" || key.Use == "sig" {
+			p.keys[key.KeyID] = key
+		}
+	}
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return signing.JWK{}, fmt.Errorf("unknown key %q", kid)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,116 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Parjun2000/task-manager/oidc"
+	"github.com/Parjun2000/task-manager/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscover(t *testing.T) {
+	fake, err := oidctest.NewProvider("task-manager", "client-secret")
+	require.NoError(t, err)
+	defer fake.Close()
+
+	provider, err := oidc.Discover(context.Background(), fake.Config("http://localhost/callback"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, fake.URL+"/token", provider.TokenEndpoint)
+
+	// The discovered issuer must match the configured one
+	config := fake.Config("http://localhost/callback")
+	config.Issuer = fake.URL + "/other"
+	_, err = oidc.Discover(context.Background(), config, nil)
+	assert.Error(t, err)
+}
+
+func TestLogin(t *testing.T) {
+	ctx := context.Background()
+	fake, err := oidctest.NewProvider("task-manager", "client-secret")
+	require.NoError(t, err)
+	defer fake.Close()
+	provider, err := oidc.Discover(ctx, fake.Config("http://localhost/callback"), nil)
+	require.NoError(t, err)
+
+	// login runs the flow up to the ID token, with the claims overridden
+	login := func(claims map[string]interface{}) (string, string) {
+		verifier, challenge, err := oidc.NewPKCE()
+		require.NoError(t, err)
+		authURL := provider.AuthCodeURL("state", "nonce", challenge)
+		callback, err := fake.Authorize(authURL, "248289761001", claims)
+		require.NoError(t, err)
+		parsed, err := url.Parse(callback)
+		require.NoError(t, err)
+		assert.Equal(t, "state", parsed.Query().Get("state"))
+		return parsed.Query().Get("code"), verifier
+	}
+
+	code, verifier := login(map[string]interface{}{"preferred_username": "janedoe", "email": "jane@example.com", "email_verified": true})
+	rawIDToken, err := provider.Exchange(ctx, code, verifier)
+	require.NoError(t, err)
+	token, err := provider.Verify(ctx, rawIDToken, "nonce")
+	require.NoError(t, err)
+	assert.Equal(t, oidc.IDToken{Issuer: fake.URL, Subject: "248289761001", Email: "jane@example.com", EmailVerified: true, PreferredUsername: "janedoe"}, token)
+
+	// Codes are single use and bound to the PKCE verifier
+	_, err = provider.Exchange(ctx, code, verifier)
+	var providerErr *oidc.Error
+	require.True(t, errors.As(err, &providerErr))
+	assert.Equal(t, "invalid_grant", providerErr.Code)
+	code, _ = login(nil)
+	_, err = provider.Exchange(ctx, code, "wrong-verifier")
+	assert.True(t, errors.As(err, &providerErr))
+
+	_, err = provider.Verify(ctx, rawIDToken, "other-nonce")
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+
+	for name, claims := range map[string]map[string]interface{}{
+		"wrong issuer":     {"iss": "https://evil.example.com"},
+		"wrong audience":   {"aud": "other-client"},
+		"multiple parties": {"aud": []string{"task-manager", "other-client"}},
+		"expired":          {"exp": time.Now().Add(-time.Hour).Unix()},
+		"issued later":     {"iat": time.Now().Add(time.Hour).Unix()},
+		"no subject":       {"sub": ""},
+	} {
+		code, verifier := login(claims)
+		rawIDToken, err := provider.Exchange(ctx, code, verifier)
+		require.NoError(t, err, name)
+		_, err = provider.Verify(ctx, rawIDToken, "nonce")
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken, name)
+	}
+
+	// A list audience works with the authorized party
+	code, verifier = login(map[string]interface{}{"aud": []string{"task-manager", "other-client"}, "azp": "task-manager"})
+	rawIDToken, err = provider.Exchange(ctx, code, verifier)
+	require.NoError(t, err)
+	_, err = provider.Verify(ctx, rawIDToken, "nonce")
+	assert.NoError(t, err)
+
+	// Rotated provider keys are fetched again
+	defer oidc.SetKeysRefreshInterval(0)()
+	require.NoError(t, fake.RotateKey())
+	code, verifier = login(nil)
+	rawIDToken, err = provider.Exchange(ctx, code, verifier)
+	require.NoError(t, err)
+	_, err = provider.Verify(ctx, rawIDToken, "nonce")
+	assert.NoError(t, err)
+
+	// Keys that cannot be fetched do not make the token invalid
+	require.NoError(t, fake.RotateKey())
+	code, verifier = login(nil)
+	rawIDToken, err = provider.Exchange(ctx, code, verifier)
+	require.NoError(t, err)
+	fake.SetKeysDown(true)
+	_, err = provider.Verify(ctx, rawIDToken, "nonce")
+	var fetchErr *oidc.KeyFetchError
+	assert.True(t, errors.As(err, &fetchErr))
+	assert.NotErrorIs(t, err, oidc.ErrInvalidIDToken)
+	fake.SetKeysDown(false)
+	_, err = provider.Verify(ctx, rawIDToken, "nonce")
+	assert.NoError(t, err)
+}
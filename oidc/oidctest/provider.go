@@ -0,0 +1,198 @@
+// Package oidctest runs a fake OpenID Connect provider in process, for
+// testing logins without a real one.
+package oidctest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Parjun2000/task-manager/oidc"
+	"github.com/Parjun2000/task-manager/signing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Provider is a fake provider serving discovery, token and key endpoints.
+// Users log in with Authorize instead of a login page.
+type Provider struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+	mu           sync.Mutex
+	key          signing.Key // signs the ID tokens
+	keysDown     bool        // the key endpoint answers 503
+	codes        map[string]authorization
+}
+
+// authorization is an issued code waiting to be exchanged.
+type authorization struct {
+	redirectURI   string
+	codeChallenge string
+	claims        jwt.MapClaims
+}
+
+// NewProvider starts a fake provider for one client. Close it when done.
+func NewProvider(clientID, clientSecret string) (*Provider, error) {
+	key, err := signing.GenerateKey(signing.RS256)
+	if err != nil {
+		return nil, err
+	}
+	p := &Provider{ClientID: clientID, ClientSecret: clientSecret, key: key, codes: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	return p, nil
+}
+
+// SetKeysDown makes the key endpoint fail, or work again.
+func (p *Provider) SetKeysDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keysDown = down
+}
+
+// RotateKey replaces the key that signs ID tokens.
+func (p *Provider) RotateKey() error {
+	key, err := signing.GenerateKey(signing.RS256)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key = key
+	return nil
+}
+
+// Config returns the client configuration for the provider.
+func (p *Provider) Config(redirectURL string) oidc.Config {
+	return oidc.Config{Issuer: p.URL, ClientID: p.ClientID, ClientSecret: p.ClientSecret, RedirectURL: redirectURL, Scopes: []string{"profile", "email"}}
+}
+
+// Authorize logs the subject in at the authorization URL and returns the URL
+// the provider redirects back to, with a code and the state. The ID token
+// for the code gets the standard claims, overridden and extended by claims.
+func (p *Provider) Authorize(authURL, subject string, claims map[string]interface{}) (string, error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	switch {
+	case query.Get("response_type") != "code":
+		return "", errors.New("unsupported response_type")
+	case query.Get("client_id") != p.ClientID:
+		return "", errors.New("unknown client_id")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return "", errors.New("missing S256 code_challenge")
+	case query.Get("redirect_uri") == "":
+		return "", errors.New("missing redirect_uri")
+	}
+
+	idClaims := jwt.MapClaims{
+		"iss":   p.URL,
+		"sub":   subject,
+		"aud":   p.ClientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{redirectURI: query.Get("redirect_uri"), codeChallenge: query.Get("code_challenge"), claims: idClaims}
+	p.mu.Unlock()
+
+	callback, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return "", err
+	}
+	callbackQuery := callback.Query()
+	callbackQuery.Set("code", code)
+	callbackQuery.Set("state", query.Get("state"))
+	callback.RawQuery = callbackQuery.Encode()
+	return callback.String(), nil
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/jwks",
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keysDown {
+		writeJSON(w, 503, map[string]string{"error": "temporarily_unavailable"})
+		return
+	}
+	writeJSON(w, 200, signing.JWKS{Keys: []signing.JWK{p.key.JWK()}})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if r.Method != "POST" || !ok || clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJSON(w, 401, oidc.Error{Code: "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(w, 400, oidc.Error{Code: "unsupported_grant_type"})
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code := r.PostFormValue("code")
+	auth, ok := p.codes[code]
+	// Codes are single use, whether or not the exchange succeeds
+	delete(p.codes, code)
+	switch {
+	case !ok:
+		writeJSON(w, 400, oidc.Error{Code: "invalid_grant", Description: "unknown code"})
+		return
+	case r.PostFormValue("redirect_uri") != auth.redirectURI:
+		writeJSON(w, 400, oidc.Error{Code: "invalid_grant", Description: "redirect_uri mismatch"})
+		return
+	case oidc.CodeChallenge(r.PostFormValue("code_verifier")) != auth.codeChallenge:
+		writeJSON(w, 400, oidc.Error{Code: "invalid_grant", Description: "PKCE verification failed"})
+		return
+	}
+
+	token := jwt.NewWithClaims(p.key.Method(), auth.claims)
+	token.Header["kid"] = p.key.ID
+	idToken, err := token.SignedString(p.key.Private)
+	if err != nil {
+		writeJSON(w, 500, oidc.Error{Code: "server_error"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"access_token": randomString(), "token_type": "Bearer", "expires_in": 3600, "id_token": idToken})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
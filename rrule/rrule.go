@@ -0,0 +1,470 @@
+// Package rrule parses and expands the RFC 5545 recurrence rules (RRULE)
+// that recurring tasks carry.
+//
+// The DAILY, WEEKLY, MONTHLY and YEARLY frequencies are supported together
+// with the INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH and WKST rule
+// parts. Occurrences keep the wall clock time of DTSTART in its location, so
+// a task due at 09:00 stays due at 09:00 across daylight saving changes.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ rule part.
+type Frequency int
+
+const (
+	Daily Frequency = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencyNames = []string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}
+
+func (f Frequency) String() string {
+	return frequencyNames[f]
+}
+
+var weekdayNames = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// WeekdayNum is a BYDAY entry. A non-zero N limits it to the Nth such weekday
+// of the month, or of the year for YEARLY rules without BYMONTH; negative
+// values count from the end.
+type WeekdayNum struct {
+	Weekday time.Weekday
+	N       int
+}
+
+func (w WeekdayNum) String() string {
+	if w.N == 0 {
+		return weekdayNames[w.Weekday]
+	}
+	return strconv.Itoa(w.N) + weekdayNames[w.Weekday]
+}
+
+// Rule is a parsed recurrence rule.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	// Count is the number of occurrences including DTSTART, zero when unbounded.
+	Count int
+	// Until is the last possible occurrence, zero when unbounded. With
+	// UntilDate set it holds a date at midnight UTC and the rule runs through
+	// the end of that day in the location of DTSTART.
+	Until      time.Time
+	UntilDate  bool
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+	WeekStart  time.Weekday
+}
+
+// horizon is how far past the last occurrence the expander keeps looking
+// before it decides a rule has no further occurrences.
+const horizon = 400
+
+// Parse parses an RRULE value such as "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10".
+// A leading "RRULE:" is accepted.
+func Parse(value string) (*Rule, error) {
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "RRULE:")
+	rule := &Rule{Interval: 1, WeekStart: time.Monday}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ";") {
+		name, val, ok := strings.Cut(part, "=")
+		if !ok || val == "" {
+			return nil, fmt.Errorf("rrule: malformed rule part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("rrule: duplicate rule part %s", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			rule.Freq, err = parseFrequency(val)
+		case "INTERVAL":
+			rule.Interval, err = parseNumber(val, 1, 1000)
+		case "COUNT":
+			rule.Count, err = parseNumber(val, 1, 100000)
+		case "UNTIL":
+			rule.Until, rule.UntilDate, err = parseUntil(val)
+		case "BYDAY":
+			rule.ByDay, err = parseByDay(val)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseNumbers(val, 31)
+		case "BYMONTH":
+			var months []int
+			months, err = parseNumbers(val, 12)
+			for _, month := range months {
+				if month < 0 {
+					err = fmt.Errorf("%d is out of range", month)
+				}
+				rule.ByMonth = append(rule.ByMonth, time.Month(month))
+			}
+		case "WKST":
+			rule.WeekStart, err = parseWeekday(val)
+		default:
+			return nil, fmt.Errorf("rrule: unsupported rule part %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rrule: invalid %s: %w", name, err)
+		}
+	}
+
+	if !seen["FREQ"] {
+		return nil, errors.New("rrule: FREQ is required")
+	}
+	if seen["COUNT"] && seen["UNTIL"] {
+		return nil, errors.New("rrule: COUNT and UNTIL cannot be combined")
+	}
+	if rule.Freq == Weekly && len(rule.ByMonthDay) > 0 {
+		return nil, errors.New("rrule: BYMONTHDAY cannot be used with FREQ=WEEKLY")
+	}
+	for _, day := range rule.ByDay {
+		if day.N == 0 {
+			continue
+		}
+		limit := 5
+		switch {
+		case rule.Freq == Daily || rule.Freq == Weekly:
+			return nil, fmt.Errorf("rrule: BYDAY=%s needs FREQ=MONTHLY or FREQ=YEARLY", day)
+		case rule.Freq == Yearly && len(rule.ByMonth) == 0:
+			limit = 53
+		}
+		if day.N > limit || day.N < -limit {
+			return nil, fmt.Errorf("rrule: invalid BYDAY: %s is out of range", day)
+		}
+	}
+	return rule, nil
+}
+
+func parseFrequency(value string) (Frequency, error) {
+	for i, name := range frequencyNames {
+		if value == name {
+			return Frequency(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported frequency %s", value)
+}
+
+func parseNumber(value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("%d is out of range", n)
+	}
+	return n, nil
+}
+
+// parseNumbers parses a list of non-zero numbers between -max and max.
+func parseNumbers(value string, max int) ([]int, error) {
+	var numbers []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := parseNumber(strings.TrimPrefix(item, "+"), -max, max)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errors.New("0 is out of range")
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, nil
+}
+
+func parseUntil(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse("20060102T150405Z", value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is neither a date nor a UTC date-time", value)
+	}
+	return t, false, nil
+}
+
+func parseWeekday(value string) (time.Weekday, error) {
+	for i, name := range weekdayNames {
+		if value == name {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", value)
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+	var days []WeekdayNum
+	for _, item := range strings.Split(value, ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("unknown weekday %q", item)
+		}
+		weekday, err := parseWeekday(item[len(item)-2:])
+		if err != nil {
+			return nil, err
+		}
+		day := WeekdayNum{Weekday: weekday}
+		if ordinal := item[:len(item)-2]; ordinal != "" {
+			if day.N, err = parseNumber(strings.TrimPrefix(ordinal, "+"), -53, 53); err != nil {
+				return nil, err
+			}
+			if day.N == 0 {
+				return nil, errors.New("0 is out of range")
+			}
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// String formats the rule as an RRULE value, without the "RRULE:" prefix.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq.String()}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.UntilDate {
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(r.ByMonth) > 0 {
+		months := make([]string, len(r.ByMonth))
+		for i, month := range r.ByMonth {
+			months[i] = strconv.Itoa(int(month))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = day.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayNames[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+// Iterator walks the occurrences of a rule in chronological order.
+type Iterator struct {
+	rule    *Rule
+	start   time.Time
+	last    time.Time
+	period  int
+	pending []time.Time
+	emitted int
+	done    bool
+}
+
+// Iterator returns an iterator over the occurrences of the rule starting at
+// dtstart, which always counts as the first occurrence.
+func (r *Rule) Iterator(dtstart time.Time) *Iterator {
+	return &Iterator{rule: r, start: dtstart, last: dtstart, pending: []time.Time{dtstart}}
+}
+
+// Next returns the next occurrence, or false once the rule is exhausted.
+func (it *Iterator) Next() (time.Time, bool) {
+	for !it.done {
+		if len(it.pending) == 0 {
+			first, occurrences := it.rule.expandPeriod(it.start, it.period)
+			it.period++
+			if first.Year() > it.last.Year()+horizon {
+				it.done = true
+				break
+			}
+			it.pending = occurrences
+			continue
+		}
+
+		t := it.pending[0]
+		it.pending = it.pending[1:]
+		if it.rule.afterUntil(t) {
+			it.done = true
+			break
+		}
+		it.last = t
+		it.emitted++
+		if it.rule.Count > 0 && it.emitted >= it.rule.Count {
+			it.done = true
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// All returns up to limit occurrences of the rule starting at dtstart.
+func (r *Rule) All(dtstart time.Time, limit int) []time.Time {
+	occurrences := make([]time.Time, 0)
+	it := r.Iterator(dtstart)
+	for len(occurrences) < limit {
+		t, ok := it.Next()
+		if !ok {
+			break
+		}
+		occurrences = append(occurrences, t)
+	}
+	return occurrences
+}
+
+// After returns the first occurrence of the rule starting at dtstart that
+// is strictly later than t.
+func (r *Rule) After(dtstart, t time.Time) (time.Time, bool) {
+	it := r.Iterator(dtstart)
+	for {
+		next, ok := it.Next()
+		if !ok || next.After(t) {
+			return next, ok
+		}
+	}
+}
+
+func (r *Rule) afterUntil(t time.Time) bool {
+	if r.Until.IsZero() {
+		return false
+	}
+	if r.UntilDate {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).After(r.Until)
+	}
+	return t.After(r.Until)
+}
+
+// expandPeriod returns the first day of the given period after dtstart and
+// the occurrences in it that are later than dtstart.
+func (r *Rule) expandPeriod(dtstart time.Time, period int) (time.Time, []time.Time) {
+	// Days are walked in UTC so daylight saving changes cannot skip or repeat one
+	y, m, d := dtstart.Date()
+	step := period * r.Interval
+	var first, last time.Time
+	switch r.Freq {
+	case Daily:
+		first = time.Date(y, m, d+step, 0, 0, 0, 0, time.UTC)
+		last = first
+	case Weekly:
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		first = time.Date(y, m, d-offset+7*step, 0, 0, 0, 0, time.UTC)
+		last = first.AddDate(0, 0, 6)
+	case Monthly:
+		first = time.Date(y, m+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		last = first.AddDate(0, 1, -1)
+	case Yearly:
+		first = time.Date(y+step, 1, 1, 0, 0, 0, 0, time.UTC)
+		last = first.AddDate(1, 0, -1)
+	}
+
+	hour, min, sec := dtstart.Clock()
+	occurrences := make([]time.Time, 0)
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if !r.matches(day, dtstart) {
+			continue
+		}
+		t := localTime(day.Year(), day.Month(), day.Day(), hour, min, sec, dtstart.Nanosecond(), dtstart.Location())
+		if t.After(dtstart) {
+			occurrences = append(occurrences, t)
+		}
+	}
+	return first, occurrences
+}
+
+// matches reports whether a day is part of the recurrence set. Rule parts
+// left out fall back to the matching part of dtstart as RFC 5545 specifies.
+func (r *Rule) matches(day, dtstart time.Time) bool {
+	if len(r.ByMonth) > 0 && !containsMonth(r.ByMonth, day.Month()) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 && !r.matchesMonthDay(day) {
+		return false
+	}
+	if len(r.ByDay) > 0 && !r.matchesByDay(day) {
+		return false
+	}
+
+	switch r.Freq {
+	case Weekly:
+		return len(r.ByDay) > 0 || day.Weekday() == dtstart.Weekday()
+	case Monthly:
+		return len(r.ByDay) > 0 || len(r.ByMonthDay) > 0 || day.Day() == dtstart.Day()
+	case Yearly:
+		if len(r.ByDay) > 0 || len(r.ByMonthDay) > 0 {
+			return true
+		}
+		return day.Day() == dtstart.Day() && (len(r.ByMonth) > 0 || day.Month() == dtstart.Month())
+	}
+	return true
+}
+
+func containsMonth(months []time.Month, month time.Month) bool {
+	for _, m := range months {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMonthDay(day time.Time) bool {
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, monthDay := range r.ByMonthDay {
+		if monthDay == day.Day() || daysInMonth+monthDay+1 == day.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesByDay(day time.Time) bool {
+	// Ordinals count within the month, or within the year for YEARLY rules
+	// without BYMONTH
+	first := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1)
+	if r.Freq == Yearly && len(r.ByMonth) == 0 {
+		first = time.Date(day.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		last = first.AddDate(1, 0, -1)
+	}
+	fromStart := int(day.Sub(first).Hours()/24)/7 + 1
+	fromEnd := -(int(last.Sub(day).Hours()/24)/7 + 1)
+
+	for _, weekday := range r.ByDay {
+		if weekday.Weekday == day.Weekday() && (weekday.N == 0 || weekday.N == fromStart || weekday.N == fromEnd) {
+			return true
+		}
+	}
+	return false
+}
+
+// localTime returns a wall clock time in loc. A time skipped by a daylight
+// saving change is moved forward by the length of the gap, as RFC 5545
+// requires, so 02:30 on a night that jumps from 02:00 to 03:00 becomes 03:30.
+func localTime(year int, month time.Month, day, hour, min, sec, nsec int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, min, sec, nsec, loc)
+	if t.Hour() == hour && t.Minute() == min && t.Second() == sec {
+		return t
+	}
+	// Read the wall clock with the UTC offset in effect before the gap
+	_, offset := t.Add(-12 * time.Hour).Zone()
+	wall := time.Date(year, month, day, hour, min, sec, nsec, time.UTC)
+	return wall.Add(-time.Duration(offset) * time.Second).In(loc)
+}
@@ -0,0 +1,195 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	require.NoError(t, err)
+	return loc
+}
+
+func mustParse(t *testing.T, value string) *Rule {
+	rule, err := Parse(value)
+	require.NoError(t, err)
+	return rule
+}
+
+func formatAll(times []time.Time) []string {
+	formatted := make([]string, len(times))
+	for i, t := range times {
+		formatted[i] = t.Format(time.RFC3339)
+	}
+	return formatted
+}
+
+func TestParse(t *testing.T) {
+	rule := mustParse(t, "RRULE:freq=monthly;interval=2;byday=-1FR,+2MO;wkst=SU;count=6")
+	assert.Equal(t, Monthly, rule.Freq)
+	assert.Equal(t, 2, rule.Interval)
+	assert.Equal(t, 6, rule.Count)
+	assert.Equal(t, []WeekdayNum{{time.Friday, -1}, {time.Monday, 2}}, rule.ByDay)
+	assert.Equal(t, time.Sunday, rule.WeekStart)
+	assert.Equal(t, "FREQ=MONTHLY;INTERVAL=2;COUNT=6;BYDAY=-1FR,2MO;WKST=SU", rule.String())
+
+	for _, value := range []string{
+		"FREQ=WEEKLY;BYDAY=MO,WE",
+		"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1",
+		"FREQ=DAILY;UNTIL=20240131T235959Z",
+		"FREQ=DAILY;UNTIL=20240131",
+	} {
+		assert.Equal(t, value, mustParse(t, value).String())
+	}
+
+	for _, value := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;INTERVAL=-1",
+		"FREQ=DAILY;COUNT=3;UNTIL=20240101",
+		"FREQ=DAILY;UNTIL=2024-01-01",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=DAILY;",
+	} {
+		_, err := Parse(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     string
+		dtstart  time.Time
+		expected []string
+	}{
+		{
+			name:     "daily count includes dtstart",
+			rule:     "FREQ=DAILY;COUNT=3",
+			dtstart:  time.Date(2024, 1, 30, 9, 0, 0, 0, time.UTC),
+			expected: []string{"2024-01-30T09:00:00Z", "2024-01-31T09:00:00Z", "2024-02-01T09:00:00Z"},
+		},
+		{
+			name:     "every other week on monday and wednesday",
+			rule:     "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=5",
+			dtstart:  time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC),
+			expected: []string{"2024-01-01T08:00:00Z", "2024-01-03T08:00:00Z", "2024-01-15T08:00:00Z", "2024-01-17T08:00:00Z", "2024-01-29T08:00:00Z"},
+		},
+		{
+			name:     "monthly on the 31st skips shorter months",
+			rule:     "FREQ=MONTHLY;COUNT=4",
+			dtstart:  time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+			expected: []string{"2024-01-31T00:00:00Z", "2024-03-31T00:00:00Z", "2024-05-31T00:00:00Z", "2024-07-31T00:00:00Z"},
+		},
+		{
+			name:     "last friday of the month",
+			rule:     "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			dtstart:  time.Date(2024, 1, 26, 17, 0, 0, 0, time.UTC),
+			expected: []string{"2024-01-26T17:00:00Z", "2024-02-23T17:00:00Z", "2024-03-29T17:00:00Z"},
+		},
+		{
+			name:     "last day of the month",
+			rule:     "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3",
+			dtstart:  time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+			expected: []string{"2024-01-31T12:00:00Z", "2024-02-29T12:00:00Z", "2024-03-31T12:00:00Z"},
+		},
+		{
+			name:     "leap day only in leap years",
+			rule:     "FREQ=YEARLY;COUNT=3",
+			dtstart:  time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			expected: []string{"2024-02-29T00:00:00Z", "2028-02-29T00:00:00Z", "2032-02-29T00:00:00Z"},
+		},
+		{
+			name:     "thanksgiving",
+			rule:     "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH;COUNT=2",
+			dtstart:  time.Date(2024, 11, 28, 0, 0, 0, 0, time.UTC),
+			expected: []string{"2024-11-28T00:00:00Z", "2025-11-27T00:00:00Z"},
+		},
+		{
+			name:     "until is inclusive",
+			rule:     "FREQ=WEEKLY;UNTIL=20240115T090000Z",
+			dtstart:  time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+			expected: []string{"2024-01-01T09:00:00Z", "2024-01-08T09:00:00Z", "2024-01-15T09:00:00Z"},
+		},
+		{
+			name:     "until before a later occurrence",
+			rule:     "FREQ=WEEKLY;UNTIL=20240115T085959Z",
+			dtstart:  time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+			expected: []string{"2024-01-01T09:00:00Z", "2024-01-08T09:00:00Z"},
+		},
+		{
+			name:     "until date covers the whole local day",
+			rule:     "FREQ=DAILY;UNTIL=20240102",
+			dtstart:  time.Date(2024, 1, 1, 23, 0, 0, 0, mustLoad(t, "America/New_York")),
+			expected: []string{"2024-01-01T23:00:00-05:00", "2024-01-02T23:00:00-05:00"},
+		},
+		{
+			name:     "impossible rule",
+			rule:     "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30",
+			dtstart:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected: []string{"2024-01-01T00:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, formatAll(mustParse(t, tt.rule).All(tt.dtstart, 10)))
+		})
+	}
+}
+
+func TestExpandAcrossDST(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+
+	// The wall clock time is kept while the UTC offset changes
+	weekly := mustParse(t, "FREQ=WEEKLY;COUNT=3")
+	assert.Equal(t, []string{"2021-03-07T09:00:00-05:00", "2021-03-14T09:00:00-04:00", "2021-03-21T09:00:00-04:00"},
+		formatAll(weekly.All(time.Date(2021, 3, 7, 9, 0, 0, 0, newYork), 10)))
+	assert.Equal(t, []string{"2021-10-31T09:00:00-04:00", "2021-11-07T09:00:00-05:00", "2021-11-14T09:00:00-05:00"},
+		formatAll(weekly.All(time.Date(2021, 10, 31, 9, 0, 0, 0, newYork), 10)))
+
+	// 02:30 does not exist on the night clocks spring forward and moves to 03:30
+	daily := mustParse(t, "FREQ=DAILY;COUNT=3")
+	assert.Equal(t, []string{"2021-03-13T02:30:00-05:00", "2021-03-14T03:30:00-04:00", "2021-03-15T02:30:00-04:00"},
+		formatAll(daily.All(time.Date(2021, 3, 13, 2, 30, 0, 0, newYork), 10)))
+
+	// 01:30 happens twice on the night clocks fall back, the first one is used
+	assert.Equal(t, []string{"2021-11-06T01:30:00-04:00", "2021-11-07T01:30:00-04:00", "2021-11-08T01:30:00-05:00"},
+		formatAll(daily.All(time.Date(2021, 11, 6, 1, 30, 0, 0, newYork), 10)))
+
+	// A daily task stays 24 hours of wall clock apart, not 24 hours of elapsed time
+	next, ok := mustParse(t, "FREQ=DAILY").After(time.Date(2021, 3, 13, 9, 0, 0, 0, newYork), time.Date(2021, 3, 13, 9, 0, 0, 0, newYork))
+	require.True(t, ok)
+	assert.Equal(t, "2021-03-14T09:00:00-04:00", next.Format(time.RFC3339))
+	assert.Equal(t, 23*time.Hour, next.Sub(time.Date(2021, 3, 13, 9, 0, 0, 0, newYork)))
+}
+
+func TestAfter(t *testing.T) {
+	dtstart := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	rule := mustParse(t, "FREQ=WEEKLY;COUNT=3")
+
+	next, ok := rule.After(dtstart, dtstart)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC), next)
+
+	next, ok = rule.After(dtstart, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC))
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC), next)
+
+	// COUNT is used up by the third occurrence
+	_, ok = rule.After(dtstart, time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC))
+	assert.False(t, ok)
+}
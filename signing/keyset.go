@@ -0,0 +1,75 @@
+package signing
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ActivationDelay is how long a new key is published before it signs, so
+// that every instance and every verifier caching the key set knows it by
+// the time the first token signed with it arrives.
+const ActivationDelay = 5 * time.Minute
+
+// Keys holds the keys of this instance. Rotator keeps it in sync with
+// storage.
+var Keys = &KeySet{}
+
+// KeySet is a set of signing keys, safe for concurrent use.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []Key // newest first
+}
+
+// Set replaces the keys in the set.
+func (s *KeySet) Set(keys ...Key) {
+	keys = append([]Key(nil), keys...)
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+// Current returns the key that signs new tokens: the newest key published
+// for at least ActivationDelay, or the newest key when none is that old.
+func (s *KeySet) Current() (Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.keys) == 0 {
+		return Key{}, false
+	}
+	activeBefore := time.Now().Add(-ActivationDelay)
+	for _, key := range s.keys {
+		if !key.CreatedAt.After(activeBefore) {
+			return key, true
+		}
+	}
+	return s.keys[0], true
+}
+
+// Lookup returns the key with the given kid.
+func (s *KeySet) Lookup(id string) (Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// JWKS returns the public keys of the set.
+func (s *KeySet) JWKS() JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
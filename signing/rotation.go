@@ -0,0 +1,93 @@
+package signing
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Parjun2000/task-manager/utils"
+)
+
+// SyncInterval is how often Run syncs the keys with storage.
+const SyncInterval = time.Minute
+
+// Rotator creates, loads and retires the signing keys in storage, which all
+// instances of the API share.
+type Rotator struct {
+	Store utils.Storage
+	Keys  *KeySet
+	// Secret encrypts the private keys in storage.
+	Secret []byte
+	// Algorithm of new keys. Changing it rotates the key on the next sync.
+	Algorithm string
+	// Interval is how long a key signs before it is replaced.
+	Interval time.Duration
+	// TokenTTL is how long tokens stay valid, and so how long a replaced key
+	// must still verify them.
+	TokenTTL time.Duration
+}
+
+// Sync creates a new key when the newest one is due for rotation, deletes
+// keys whose tokens have all expired and loads the rest into Keys.
+func (r *Rotator) Sync(ctx context.Context) error {
+	stored, err := r.Store.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if len(stored) == 0 || stored[0].Algorithm != r.Algorithm || !now.Before(stored[0].CreatedAt.Add(r.Interval)) {
+		key, err := GenerateKey(r.Algorithm)
+		if err != nil {
+			return err
+		}
+		sealed, err := Seal(key.Private, r.Secret)
+		if err != nil {
+			return err
+		}
+		next := utils.SigningKey{ID: key.ID, Algorithm: key.Algorithm, PrivateKey: sealed, CreatedAt: key.CreatedAt}
+		if err := r.Store.CreateSigningKey(ctx, next); err != nil {
+			return err
+		}
+		stored = append([]utils.SigningKey{next}, stored...)
+	}
+
+	// A replaced key may sign until its successor activates on every
+	// instance, and its tokens are valid for TokenTTL after that
+	retireBefore := now.Add(-(ActivationDelay + SyncInterval + r.TokenTTL))
+	keys := make([]Key, 0, len(stored))
+	for i, key := range stored {
+		if i > 0 && stored[i-1].CreatedAt.Before(retireBefore) {
+			if err := r.Store.DeleteSigningKey(ctx, key.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		private, err := Open(key.PrivateKey, r.Secret)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, Key{ID: key.ID, Algorithm: key.Algorithm, Private: private, CreatedAt: key.CreatedAt})
+	}
+	r.Keys.Set(keys...)
+	return nil
+}
+
+// Run syncs the keys every SyncInterval until ctx is done, which rotates
+// them on schedule and picks up keys created by other instances.
+func (r *Rotator) Run(ctx context.Context) {
+	ticker := time.NewTicker(SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			syncCtx, cancel := context.WithTimeout(ctx, SyncInterval/2)
+			if err := r.Sync(syncCtx); err != nil {
+				log.Println("Signing key sync failed:", err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Package signing manages the asymmetric keys that sign access tokens. Every
+// key has a kid, several keys are valid at once so tokens keep working across
+// a rotation, and the public halves are published as a JSON Web Key Set.
+package signing
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Supported signing algorithms, as named in the JWT alg header.
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// rsaKeyBits is the size of generated RSA keys.
+const rsaKeyBits = 2048
+
+// Key is a private key for signing access tokens.
+type Key struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+// GenerateKey returns a new key for the algorithm with a random kid.
+func GenerateKey(algorithm string) (Key, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return Key{}, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, err
+	}
+	return Key{ID: base64.RawURLEncoding.EncodeToString(id), Algorithm: algorithm, Private: private, CreatedAt: time.Now()}, nil
+}
+
+// Method returns the JWT signing method of the key.
+func (k Key) Method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// Public returns the public half of the key, which verifies its tokens.
+func (k Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	// Modulus and exponent of RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and public key of EdDSA keys (RFC 8037), and of EC keys
+	// together with Y
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// PublicKey decodes an RSA, P-256 or Ed25519 public key, as published by
+// identity providers.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(value string) ([]byte, error) {
+		if value == "" {
+			return nil, fmt.Errorf("key %q is missing a parameter", j.KeyID)
+		}
+		return base64.RawURLEncoding.DecodeString(value)
+	}
+	switch {
+	case j.KeyType == "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("key %q has an invalid exponent", j.KeyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case j.KeyType == "EC" && j.Curve == "P-256":
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("key %q is not on its curve", j.KeyID)
+		}
+		return public, nil
+	case j.KeyType == "OKP" && j.Curve == "Ed25519":
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("key %q has an invalid size", j.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("key %q has unsupported type %s %s", j.KeyID, j.KeyType, j.Curve)
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK returns the public half of the key in JSON Web Key format.
+func (k Key) JWK() JWK {
+	jwk := JWK{Use: "sig", Algorithm: k.Algorithm, KeyID: k.ID}
+	switch public := k.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// Seal encrypts the private key with AES-GCM under a key derived from secret,
+// for storing it.
+func Seal(private crypto.Signer, secret []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return SealBytes(der, secret)
+}
+
+// Open decrypts a private key encrypted by Seal.
+func Open(sealed, secret []byte) (crypto.Signer, error) {
+	der, err := OpenBytes(sealed, secret)
+	if err != nil {
+		return nil, errors.New("cannot decrypt signing key, was JWT_KEY changed?")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+// SealBytes encrypts data with AES-GCM under a key derived from secret, for
+// storing other secrets the way Seal stores private keys.
+func SealBytes(data, secret []byte) ([]byte, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// OpenBytes decrypts data encrypted by SealBytes.
+func OpenBytes(sealed, secret []byte) ([]byte, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty key encryption secret")
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,210 @@
+package signing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/Parjun2000/task-manager/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	for _, algorithm := range []string{RS256, EdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateKey(algorithm)
+			require.NoError(t, err)
+			assert.NotEmpty(t, key.ID)
+
+			signed, err := jwt.NewWithClaims(key.Method(), jwt.StandardClaims{Subject: "user1"}).SignedString(key.Private)
+			require.NoError(t, err)
+			claims := &jwt.StandardClaims{}
+			_, err = jwt.ParseWithClaims(signed, claims, func(*jwt.Token) (interface{}, error) {
+				return key.Public(), nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "user1", claims.Subject)
+
+			other, err := GenerateKey(algorithm)
+			require.NoError(t, err)
+			_, err = jwt.ParseWithClaims(signed, &jwt.StandardClaims{}, func(*jwt.Token) (interface{}, error) {
+				return other.Public(), nil
+			})
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := GenerateKey("HS256")
+	assert.Error(t, err)
+}
+
+func TestJWK(t *testing.T) {
+	key, err := GenerateKey(EdDSA)
+	require.NoError(t, err)
+	jwk := key.JWK()
+	assert.Equal(t, JWK{KeyType: "OKP", Use: "sig", Algorithm: EdDSA, KeyID: key.ID, Curve: "Ed25519", X: jwk.X}, jwk)
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	require.NoError(t, err)
+	assert.Equal(t, key.Public(), ed25519.PublicKey(x))
+
+	key, err = GenerateKey(RS256)
+	require.NoError(t, err)
+	jwk = key.JWK()
+	assert.Equal(t, "RSA", jwk.KeyType)
+	assert.Equal(t, "AQAB", jwk.E)
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	require.NoError(t, err)
+	assert.Equal(t, key.Public().(*rsa.PublicKey).N.Bytes(), n)
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	for _, algorithm := range []string{RS256, EdDSA} {
+		key, err := GenerateKey(algorithm)
+		require.NoError(t, err)
+		public, err := key.JWK().PublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, key.Public(), public)
+	}
+
+	// RFC 7517 appendix A.1
+	ec := JWK{KeyType: "EC", Curve: "P-256", X: "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4", Y: "4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM"}
+	public, err := ec.PublicKey()
+	require.NoError(t, err)
+	assert.IsType(t, &ecdsa.PublicKey{}, public)
+	ec.Y = "MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4"
+	_, err = ec.PublicKey()
+	assert.Error(t, err)
+
+	_, err = JWK{KeyType: "oct", KeyID: "hmac"}.PublicKey()
+	assert.Error(t, err)
+	_, err = JWK{KeyType: "RSA", KeyID: "rsa", N: "AQAB"}.PublicKey()
+	assert.Error(t, err)
+}
+
+func TestSealAndOpen(t *testing.T) {
+	key, err := GenerateKey(EdDSA)
+	require.NoError(t, err)
+	sealed, err := Seal(key.Private, []byte("secret"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), string(key.Private.(ed25519.PrivateKey).Seed()))
+
+	private, err := Open(sealed, []byte("secret"))
+	require.NoError(t, err)
+	assert.Equal(t, key.Private, private)
+
+	_, err = Open(sealed, []byte("other secret"))
+	assert.Error(t, err)
+	_, err = Seal(key.Private, nil)
+	assert.Error(t, err)
+
+	sealed, err = SealBytes([]byte("data"), []byte("secret"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "data")
+	data, err := OpenBytes(sealed, []byte("secret"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	_, err = OpenBytes(sealed, []byte("other secret"))
+	assert.Error(t, err)
+	_, err = OpenBytes(sealed[:4], []byte("secret"))
+	assert.Error(t, err)
+}
+
+func TestKeySetCurrent(t *testing.T) {
+	set := &KeySet{}
+	_, ok := set.Current()
+	assert.False(t, ok)
+
+	old, err := GenerateKey(EdDSA)
+	require.NoError(t, err)
+	old.CreatedAt = time.Now().Add(-time.Hour)
+	next, err := GenerateKey(EdDSA)
+	require.NoError(t, err)
+
+	// A new key is published before it signs
+	set.Set(next, old)
+	current, ok := set.Current()
+	require.True(t, ok)
+	assert.Equal(t, old.ID, current.ID)
+	_, ok = set.Lookup(next.ID)
+	assert.True(t, ok)
+	assert.Len(t, set.JWKS().Keys, 2)
+
+	// Unless there is no other key
+	set.Set(next)
+	current, ok = set.Current()
+	require.True(t, ok)
+	assert.Equal(t, next.ID, current.ID)
+	_, ok = set.Lookup(old.ID)
+	assert.False(t, ok)
+}
+
+func TestRotator(t *testing.T) {
+	ctx := context.Background()
+	store := utils.NewMemoryDB()
+	rotator := &Rotator{Store: store, Keys: &KeySet{}, Secret: []byte("secret"), Algorithm: EdDSA, Interval: 24 * time.Hour, TokenTTL: 15 * time.Minute}
+	stored := func() []utils.SigningKey {
+		keys, err := store.GetSigningKeys(ctx)
+		require.NoError(t, err)
+		return keys
+	}
+
+	// The first sync creates a key, later ones reuse it
+	require.NoError(t, rotator.Sync(ctx))
+	require.NoError(t, rotator.Sync(ctx))
+	keys := stored()
+	require.Len(t, keys, 1)
+	first, ok := rotator.Keys.Current()
+	require.True(t, ok)
+	assert.Equal(t, keys[0].ID, first.ID)
+
+	// Keys created by another instance are picked up
+	other, err := GenerateKey(EdDSA)
+	require.NoError(t, err)
+	other.CreatedAt = time.Now().Add(-2 * time.Hour)
+	sealed, err := Seal(other.Private, []byte("secret"))
+	require.NoError(t, err)
+	require.NoError(t, store.CreateSigningKey(ctx, utils.SigningKey{ID: other.ID, Algorithm: EdDSA, PrivateKey: sealed, CreatedAt: other.CreatedAt}))
+	require.NoError(t, rotator.Sync(ctx))
+	_, ok = rotator.Keys.Lookup(other.ID)
+	assert.True(t, ok)
+
+	// A key past its interval is replaced, and keys replaced long enough
+	// ago for their tokens to have expired are retired
+	require.NoError(t, store.DeleteSigningKey(ctx, first.ID))
+	old := first
+	old.CreatedAt = time.Now().Add(-25 * time.Hour)
+	sealed, err = Seal(old.Private, []byte("secret"))
+	require.NoError(t, err)
+	require.NoError(t, store.CreateSigningKey(ctx, utils.SigningKey{ID: old.ID, Algorithm: EdDSA, PrivateKey: sealed, CreatedAt: old.CreatedAt}))
+	require.NoError(t, store.DeleteSigningKey(ctx, other.ID))
+	other.CreatedAt = time.Now().Add(-26 * time.Hour)
+	sealed, err = Seal(other.Private, []byte("secret"))
+	require.NoError(t, err)
+	require.NoError(t, store.CreateSigningKey(ctx, utils.SigningKey{ID: other.ID, Algorithm: EdDSA, PrivateKey: sealed, CreatedAt: other.CreatedAt}))
+
+	require.NoError(t, rotator.Sync(ctx))
+	keys = stored()
+	require.Len(t, keys, 2)
+	assert.NotEqual(t, old.ID, keys[0].ID)
+	assert.Equal(t, old.ID, keys[1].ID, "the replaced key still verifies")
+	current, ok := rotator.Keys.Current()
+	require.True(t, ok)
+	assert.Equal(t, old.ID, current.ID, "the new key is not active yet")
+
+	// Changing the algorithm rotates right away
+	rotator.Algorithm = RS256
+	require.NoError(t, rotator.Sync(ctx))
+	keys = stored()
+	require.Len(t, keys, 3)
+	assert.Equal(t, RS256, keys[0].Algorithm)
+
+	// Keys cannot be read without the secret
+	rotator.Secret = []byte("other secret")
+	assert.Error(t, rotator.Sync(ctx))
+}
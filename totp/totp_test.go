@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRFC6238Vectors(t *testing.T) {
+	// Appendix B of RFC 6238, SHA-1 with 8 digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, hotp(key, Counter(time.Unix(tt.unix, 0)), 8), tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	code, err := Code(secret, now)
+	require.NoError(t, err)
+	assert.Equal(t, "050471", code)
+
+	counter, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Counter(now), counter)
+
+	// One time step of drift either way is accepted
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.True(t, ok)
+	_, ok = Validate(secret, code, now.Add(-Period))
+	assert.True(t, ok)
+	_, ok = Validate(secret, code, now.Add(2*Period))
+	assert.False(t, ok)
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		_, ok = Validate(secret, code, now)
+		assert.False(t, ok, code)
+	}
+	_, ok = Validate("not base32!", "050471", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+	other, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	// Lower case secrets with spaces, as some apps show them, still work
+	code, err := Code(secret, time.Now())
+	require.NoError(t, err)
+	_, ok := Validate(strings.ToLower(secret[:4]+" "+secret[4:]), code, time.Now())
+	assert.True(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	assert.Equal(t, "otpauth://totp/Task%20Manager:user1?algorithm=SHA1&digits=6&issuer=Task+Manager&period=30&secret=JBSWY3DPEHPK3PXP",
+		URI("Task Manager", "user1", "JBSWY3DPEHPK3PXP"))
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Scopes of a personal access token. Write access includes read access.
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+)
+
+// PersonalAccessToken is a long-lived token a user creates for scripts and CI.
+// Only the hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// personalAccessTokenColumns lists the columns scanPersonalAccessToken reads, in order.
+const personalAccessTokenColumns = "id, user_id, name, scope, token_hash, expires_at, last_used_at, created_at"
+
+// scanPersonalAccessToken reads a row selected with personalAccessTokenColumns.
+func scanPersonalAccessToken(row interface{ Scan(...interface{}) error }) (PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Scope, &token.TokenHash, &expiresAt, &lastUsedAt, &token.CreatedAt)
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return token, nil
+}
+
+// CreatePersonalAccessToken stores a new personal access token in the
+// database and returns it as stored.
+func (s *PostgresDB) CreatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) (PersonalAccessToken, error) {
+	var expiresAt interface{}
+	if token.ExpiresAt != nil {
+		expiresAt = token.ExpiresAt.UTC()
+	}
+	stored, err := scanPersonalAccessToken(s.DB.QueryRowContext(ctx, "INSERT INTO personal_access_tokens (user_id, name, scope, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING "+personalAccessTokenColumns,
+		token.UserID, token.Name, token.Scope, token.TokenHash, expiresAt))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return PersonalAccessToken{}, ErrPersonalAccessTokenExists
+		}
+		return PersonalAccessToken{}, err
+	}
+	return stored, nil
+}
+
+// GetPersonalAccessTokens retrieves the user's personal access tokens from the database.
+func (s *PostgresDB) GetPersonalAccessTokens(ctx context.Context, userID int) ([]PersonalAccessToken, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+personalAccessTokenColumns+" FROM personal_access_tokens WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]PersonalAccessToken, 0)
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// DeletePersonalAccessToken revokes one of the user's personal access tokens in the database.
+func (s *PostgresDB) DeletePersonalAccessToken(ctx context.Context, userID, id int) error {
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM personal_access_tokens WHERE id = $1 and user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+	return nil
+}
+
+// UsePersonalAccessToken looks up the personal access token with the given
+// hash in the database and records that it was used. It returns
+// ErrPersonalAccessTokenInvalid when the token is unknown or expired.
+func (s *PostgresDB) UsePersonalAccessToken(ctx context.Context, tokenHash string) (PersonalAccessToken, error) {
+	token, err := scanPersonalAccessToken(s.DB.QueryRowContext(ctx, "UPDATE personal_access_tokens SET last_used_at = NOW() WHERE token_hash = $1 and (expires_at IS NULL or expires_at > NOW()) RETURNING "+personalAccessTokenColumns,
+		tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PersonalAccessToken{}, ErrPersonalAccessTokenInvalid
+		}
+		return PersonalAccessToken{}, err
+	}
+	return token, nil
+}
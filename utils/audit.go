@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// AuditEntry records a request to the admin API.
+type AuditEntry struct {
+	ID int `json:"id"`
+	// ActorID is the admin who made the request.
+	ActorID int `json:"actor_id"`
+	// Action is the method and route of the request, e.g. "PUT /api/v1/admin/users/:id/disable".
+	Action string `json:"action"`
+	// TargetUserID is the user the request was about, if any.
+	TargetUserID *int      `json:"target_user_id"`
+	StatusCode   int       `json:"status_code"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateAuditEntry appends an entry to the audit log in the database.
+func (s *PostgresDB) CreateAuditEntry(ctx context.Context, entry AuditEntry) error {
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO audit_log (actor_id, action, target_user_id, status_code) VALUES ($1, $2, $3, $4)",
+		entry.ActorID, entry.Action, entry.TargetUserID, entry.StatusCode)
+	return err
+}
+
+// GetAuditLog retrieves a page of the audit log from the database, newest first.
+func (s *PostgresDB) GetAuditLog(ctx context.Context, page, limit int) ([]AuditEntry, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, actor_id, action, target_user_id, status_code, created_at FROM audit_log ORDER BY id DESC LIMIT $1 OFFSET $2",
+		limit, (page-1)*limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEntries(rows)
+}
+
+// GetAuditLogForUser retrieves every audit log entry about the user from the
+// database, newest first.
+func (s *PostgresDB) GetAuditLogForUser(ctx context.Context, userID int) ([]AuditEntry, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, actor_id, action, target_user_id, status_code, created_at FROM audit_log WHERE target_user_id = $1 ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAuditEntries(rows)
+}
+
+// scanAuditEntries reads the audit log entries of a query and closes its rows.
+func scanAuditEntries(rows *sql.Rows) ([]AuditEntry, error) {
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var targetUserID sql.NullInt64
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &targetUserID, &entry.StatusCode, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if targetUserID.Valid {
+			id := int(targetUserID.Int64)
+			entry.TargetUserID = &id
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Statuses of a data export.
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is an archive of everything stored about a user. It is built
+// in the background and can be downloaded with its token once ready, until
+// it expires.
+type DataExport struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status" enums:"pending,ready,failed"`
+	TokenHash   string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// dataExportColumns lists the columns scanDataExport reads, in order.
+const dataExportColumns = "id, user_id, status, token_hash, created_at, completed_at, expires_at"
+
+// scanDataExport reads a row selected with dataExportColumns, followed by
+// any extra columns into extra.
+func scanDataExport(row interface{ Scan(...interface{}) error }, extra ...interface{}) (DataExport, error) {
+	var export DataExport
+	var completedAt, expiresAt sql.NullTime
+	dest := []interface{}{&export.ID, &export.UserID, &export.Status, &export.TokenHash, &export.CreatedAt, &completedAt, &expiresAt}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return DataExport{}, err
+	}
+	if completedAt.Valid {
+		export.CompletedAt = &completedAt.Time
+	}
+	if expiresAt.Valid {
+		export.ExpiresAt = &expiresAt.Time
+	}
+	return export, nil
+}
+
+// CreateDataExport stores a pending export in the database and returns it.
+// Expired exports are dropped on the way, and pending ones started before
+// staleBefore are marked failed as their builder is gone. It returns
+// ErrDataExportInProgress while another export of the user is pending.
+func (s *PostgresDB) CreateDataExport(ctx context.Context, export DataExport, staleBefore time.Time) (DataExport, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return DataExport{}, err
+	}
+	defer tx.Rollback()
+
+	// Locking the user serializes concurrent requests for an export
+	var id int
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", export.UserID).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DataExport{}, ErrUserNotFound
+		}
+		return DataExport{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM data_exports WHERE expires_at < NOW()"); err != nil {
+		return DataExport{}, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE data_exports SET status = 'failed', completed_at = NOW() WHERE user_id = $1 AND status = 'pending' AND created_at < $2",
+		export.UserID, staleBefore.UTC())
+	if err != nil {
+		return DataExport{}, err
+	}
+	var pending bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM data_exports WHERE user_id = $1 AND status = 'pending')", export.UserID).Scan(&pending); err != nil {
+		return DataExport{}, err
+	}
+	if pending {
+		return DataExport{}, ErrDataExportInProgress
+	}
+
+	created, err := scanDataExport(tx.QueryRowContext(ctx, "INSERT INTO data_exports (user_id, token_hash) VALUES ($1, $2) RETURNING "+dataExportColumns,
+		export.UserID, export.TokenHash))
+	if err != nil {
+		return DataExport{}, err
+	}
+	return created, tx.Commit()
+}
+
+// GetDataExport retrieves one of the user's exports from the database,
+// without its archive.
+func (s *PostgresDB) GetDataExport(ctx context.Context, userID, id int) (DataExport, error) {
+	export, err := scanDataExport(s.DB.QueryRowContext(ctx, "SELECT "+dataExportColumns+" FROM data_exports WHERE id = $1 AND user_id = $2", id, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DataExport{}, ErrDataExportNotFound
+		}
+		return DataExport{}, err
+	}
+	return export, nil
+}
+
+// CompleteDataExport stores the archive of a pending export in the database
+// and makes it downloadable until expiresAt.
+func (s *PostgresDB) CompleteDataExport(ctx context.Context, id int, archive []byte, expiresAt time.Time) error {
+	result, err := s.DB.ExecContext(ctx, "UPDATE data_exports SET status = 'ready', archive = $2, completed_at = NOW(), expires_at = $3 WHERE id = $1 AND status = 'pending'",
+		id, archive, expiresAt.UTC())
+	if err != nil {
+		return err
+	}
+	return dataExportAffected(result)
+}
+
+// FailDataExport marks a pending export failed in the database.
+func (s *PostgresDB) FailDataExport(ctx context.Context, id int) error {
+	result, err := s.DB.ExecContext(ctx, "UPDATE data_exports SET status = 'failed', completed_at = NOW() WHERE id = $1 AND status = 'pending'", id)
+	if err != nil {
+		return err
+	}
+	return dataExportAffected(result)
+}
+
+// GetDataExportArchive retrieves a ready export and its archive by the hash
+// of its download token from the database. It returns ErrDataExportNotFound
+// for unknown tokens and for exports that are not ready or have expired.
+func (s *PostgresDB) GetDataExportArchive(ctx context.Context, tokenHash string) (DataExport, []byte, error) {
+	var archive []byte
+	export, err := scanDataExport(s.DB.QueryRowContext(ctx, "SELECT "+dataExportColumns+", archive FROM data_exports WHERE token_hash = $1 AND status = 'ready' AND expires_at > NOW()", tokenHash), &archive)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DataExport{}, nil, ErrDataExportNotFound
+		}
+		return DataExport{}, nil, err
+	}
+	return export, archive, nil
+}
+
+// dataExportAffected returns ErrDataExportNotFound when an update of a
+// pending export matched no row.
+func dataExportAffected(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrDataExportNotFound
+	}
+	return nil
+}
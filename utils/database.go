@@ -1,26 +1,169 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/Parjun2000/task-manager/filterexpr"
+	"github.com/lib/pq"
 )
 
+// Errors returned by Storage implementations when a lookup matches no row.
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTagNotFound  = errors.New("tag not found")
+)
+
+// ErrVersionMismatch is returned by conditional task changes when the task is
+// no longer at the version they were made against.
+var ErrVersionMismatch = errors.New("task was changed since it was read")
+
+// TaskErrors holds, by task ID, why each task of a batch could not be changed.
+type TaskErrors map[int]error
+
+func (e TaskErrors) Error() string {
+	return fmt.Sprintf("%d tasks could not be changed", len(e))
+}
+
+// Errors returned when a change would break the task hierarchy.
+var (
+	ErrTaskCycle       = errors.New("task cannot be moved under itself or its subtasks")
+	ErrTaskHasChildren = errors.New("task has subtasks")
+)
+
+// ErrDependencyCycle is returned when a blocker would end up, directly or
+// through other blockers, waiting on the task it blocks.
+var ErrDependencyCycle = errors.New("task cannot be blocked by itself or a task it blocks")
+
+// ErrDependencyNotFound is returned when a task is not blocked by the given task.
+var ErrDependencyNotFound = errors.New("dependency not found")
+
+// Errors returned when a refresh token cannot be exchanged for new tokens.
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+)
+
+// ErrResetTokenInvalid is returned when a password reset token is unknown,
+// was already used or has expired.
+var ErrResetTokenInvalid = errors.New("password reset token is invalid or expired")
+
+// Errors returned for personal access tokens.
+var (
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	ErrPersonalAccessTokenExists   = errors.New("personal access token with this name already exists")
+	ErrPersonalAccessTokenInvalid  = errors.New("personal access token is invalid or expired")
+)
+
+// Errors returned for two-factor authentication.
+var (
+	ErrTOTPNotFound        = errors.New("two-factor authentication is not set up")
+	ErrTOTPEnabled         = errors.New("two-factor authentication is already enabled")
+	ErrTOTPCodeReused      = errors.New("code was already used")
+	ErrRecoveryCodeInvalid = errors.New("recovery code is invalid or used")
+	ErrMFAChallengeInvalid = errors.New("login challenge is invalid or expired")
+)
+
+// ErrUsernameExists is returned when creating a user whose username is taken.
+var ErrUsernameExists = errors.New("username already exists")
+
+// Errors returned for users signing in through an OpenID Connect provider.
+var (
+	ErrExternalIdentityExists = errors.New("external identity is already linked to a user")
+	ErrOIDCLoginInvalid       = errors.New("login state is invalid or expired")
+)
+
+// Errors returned for data exports.
+var (
+	ErrDataExportNotFound   = errors.New("data export not found")
+	ErrDataExportInProgress = errors.New("a data export is already in progress")
+)
+
+// ErrInvalidRole is returned when a user would get a role other than RoleUser or RoleAdmin.
+var ErrInvalidRole = errors.New("invalid role")
+
+// ErrTagExists is returned when a user already has a tag with the same name.
+var ErrTagExists = errors.New("tag already exists")
+
+// Storage is implemented by every database backend. All methods honour the
+// cancellation and deadline of the given context.
 type Storage interface {
-	GetUserByID(int) (User, error)
-	GetUserByUsername(string) (User, error)
-	CreateUser(User) (int, error)
-	GetTasksWithParams(userId, page, limit int, sortBy, order, status string) ([]Task, error)
-	GetTaskByID(userId, id int) (Task, error)
-	CreateTask(newTask Task) (int, error)
-	UpdateTaskStatusDone(userID, taskID int) error
-	UpdateTaskByID(userID, taskID int, updatedTask Task) error
-	DeleteTask(userID, id int) error
+	GetUserByID(ctx context.Context, id int) (User, error)
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+	CreateUser(ctx context.Context, newUser User) (int, error)
+	GetTasksWithParams(ctx context.Context, userId, page, limit int, sortBy, order string, filter TaskFilter) ([]Task, error)
+	GetTasksByCursor(ctx context.Context, userId int, cursor *TaskCursor, limit int, sortBy, order string, filter TaskFilter) ([]Task, error)
+	CountTasks(ctx context.Context, userId int, filter TaskFilter) (int, error)
+	GetTaskByID(ctx context.Context, userId, id int) (Task, error)
+	CreateTask(ctx context.Context, newTask Task) (int, error)
+	UpdateTaskStatusDone(ctx context.Context, userID, taskID int) error
+	MarkTasksDone(ctx context.Context, userID int, taskIDs []int) error
+	RunTaskBatch(ctx context.Context, userID int, operations []TaskOperation, dryRun bool) ([]int, error)
+	UpdateTaskByID(ctx context.Context, userID, taskID int, updatedTask Task) error
+	PatchTask(ctx context.Context, userID, taskID int, patch Task, fields []string) error
+	DeleteTask(ctx context.Context, userID, id, version int, children ChildDeletePolicy) error
+	GetTaskChildren(ctx context.Context, userID, id int) ([]Task, error)
+	MoveTask(ctx context.Context, userID, id int, parentID *int) error
+	GetTags(ctx context.Context, userID int) ([]Tag, error)
+	GetTagByID(ctx context.Context, userID, id int) (Tag, error)
+	CreateTag(ctx context.Context, newTag Tag) (int, error)
+	RenameTag(ctx context.Context, userID, id int, name string) error
+	DeleteTag(ctx context.Context, userID, id int) error
+	AddTaskBlocker(ctx context.Context, userID, taskID, blockerID int) error
+	RemoveTaskBlocker(ctx context.Context, userID, taskID, blockerID int) error
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	RotateRefreshToken(ctx context.Context, tokenHash string, next RefreshToken) (RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, tokenHash string) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+	GetRefreshTokens(ctx context.Context, userID int) ([]RefreshToken, error)
+	UpdateUserPassword(ctx context.Context, userID int, passwordHash, keepJTI string) error
+	CreatePasswordReset(ctx context.Context, reset PasswordResetToken) error
+	ResetPassword(ctx context.Context, tokenHash, passwordHash string) (int, error)
+	GetUsers(ctx context.Context, page, limit int) ([]User, error)
+	SetUserRole(ctx context.Context, id int, role string) error
+	SetUserDisabled(ctx context.Context, id int, disabled bool) error
+	CreateAuditEntry(ctx context.Context, entry AuditEntry) error
+	GetAuditLog(ctx context.Context, page, limit int) ([]AuditEntry, error)
+	GetAuditLogForUser(ctx context.Context, userID int) ([]AuditEntry, error)
+	CreatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) (PersonalAccessToken, error)
+	GetPersonalAccessTokens(ctx context.Context, userID int) ([]PersonalAccessToken, error)
+	DeletePersonalAccessToken(ctx context.Context, userID, id int) error
+	UsePersonalAccessToken(ctx context.Context, tokenHash string) (PersonalAccessToken, error)
+	GetTOTP(ctx context.Context, userID int) (TOTPCredential, error)
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int, counter int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int) error
+	UseTOTPCounter(ctx context.Context, userID int, counter int64) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	CreateMFAChallenge(ctx context.Context, challenge MFAChallenge) error
+	AttemptMFAChallenge(ctx context.Context, tokenHash string) (MFAChallenge, error)
+	CompleteMFAChallenge(ctx context.Context, id int) error
+	GetLoginFailures(ctx context.Context, keys []string, since time.Time) ([]LoginFailure, error)
+	RecordLoginFailure(ctx context.Context, key string, since time.Time) (LoginFailure, error)
+	ClearLoginFailures(ctx context.Context, key string) error
+	GetSigningKeys(ctx context.Context) ([]SigningKey, error)
+	CreateSigningKey(ctx context.Context, key SigningKey) error
+	DeleteSigningKey(ctx context.Context, id string) error
+	GetUserByExternalIdentity(ctx context.Context, issuer, subject string) (User, error)
+	CreateExternalUser(ctx context.Context, newUser User, identity ExternalIdentity) (User, error)
+	CreateOIDCLogin(ctx context.Context, login OIDCLogin) error
+	ConsumeOIDCLogin(ctx context.Context, stateHash string) (OIDCLogin, error)
+	GetProfile(ctx context.Context, userID int) (Profile, error)
+	UpdateProfile(ctx context.Context, userID int, profile Profile) error
+	DeleteUser(ctx context.Context, id int) error
+	GetExternalIdentities(ctx context.Context, userID int) ([]ExternalIdentity, error)
+	CreateDataExport(ctx context.Context, export DataExport, staleBefore time.Time) (DataExport, error)
+	GetDataExport(ctx context.Context, userID, id int) (DataExport, error)
+	CompleteDataExport(ctx context.Context, id int, archive []byte, expiresAt time.Time) error
+	FailDataExport(ctx context.Context, id int) error
+	GetDataExportArchive(ctx context.Context, tokenHash string) (DataExport, []byte, error)
 }
 type PostgresDB struct {
 	DB *sql.DB
@@ -70,29 +213,193 @@ func CloseDB(db *sql.DB) {
 
 // Task represents the task structure.
 type Task struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
-	UserID      int       `json:"user_id"`
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserID      int        `json:"user_id"`
+	DueAt       *time.Time `json:"due_at"`
+	DueTimezone string     `json:"due_timezone,omitempty"`
+	Priority    string     `json:"priority"`
+	// Tags holds the names of the task's tags. On update a nil slice leaves
+	// the tags unchanged while an empty one removes them all.
+	Tags     []string `json:"tags"`
+	ParentID *int     `json:"parent_id"`
+	// Recurrence is an RFC 5545 RRULE. Marking a recurring task done creates
+	// its next occurrence, with COUNT lowered to the occurrences left.
+	Recurrence string `json:"recurrence,omitempty"`
+	// BlockedBy and Blocks hold the IDs of the tasks this task waits on and
+	// of the tasks waiting on it.
+	BlockedBy []int `json:"blocked_by"`
+	Blocks    []int `json:"blocks"`
+	// Progress rolls up the subtasks of a task; only GetTaskByID fills it in.
+	Progress *TaskProgress `json:"progress,omitempty"`
+	// Version counts the changes to the task, starting at 1. On update a
+	// non-zero Version makes the change fail with ErrVersionMismatch unless
+	// the task is still at that version.
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Task priorities from least to most important. Tasks created without a
+// priority get DefaultTaskPriority.
+var TaskPriorities = []string{"low", "medium", "high", "urgent"}
+
+const DefaultTaskPriority = "medium"
+
+// priorityRank orders priorities by importance rather than alphabetically.
+func priorityRank(priority string) int {
+	for i, p := range TaskPriorities {
+		if p == priority {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// priorityOrDefault returns the priority to store for a task.
+func priorityOrDefault(priority string) string {
+	if priority == "" {
+		return DefaultTaskPriority
+	}
+	return priority
+}
+
+// TaskFilter narrows the tasks returned by GetTasksWithParams. Zero values do not filter.
+type TaskFilter struct {
+	Status   string
+	Priority string
+	// Overdue selects tasks past their due date that are not done, or with
+	// false every other task.
+	Overdue   *bool
+	DueBefore *time.Time
+	DueAfter  *time.Time
+	// Tags selects tasks carrying any of the named tags, or all of them
+	// when AllTags is set.
+	Tags    []string
+	AllTags bool
+	// Expression selects the tasks matching a filter expression using
+	// TaskFilterFields.
+	Expression filterexpr.Expr
+}
+
+// localizeDueAt renders a stored due date in the task's own timezone, or in
+// UTC when the task has none.
+func localizeDueAt(task *Task) {
+	if task.DueAt == nil {
+		return
+	}
+	loc := time.UTC
+	if task.DueTimezone != "" {
+		if l, err := time.LoadLocation(task.DueTimezone); err == nil {
+			loc = l
+		}
+	}
+	dueAt := task.DueAt.In(loc)
+	task.DueAt = &dueAt
+}
+
+// taskColumns embeds each task's tag names and dependencies, so listing tasks
+// takes a single query.
+const taskColumns = "id, title, description, status, created_at, user_id, due_at, due_timezone, priority, parent_id, recurrence, version, updated_at, " +
+	"COALESCE((SELECT array_agg(g.name ORDER BY g.name) FROM task_tags tt JOIN tags g ON g.id = tt.tag_id WHERE tt.task_id = tasks.id), '{}'), " +
+	"COALESCE((SELECT array_agg(d.blocker_id ORDER BY d.blocker_id) FROM task_dependencies d WHERE d.task_id = tasks.id), '{}'), " +
+	"COALESCE((SELECT array_agg(d.task_id ORDER BY d.task_id) FROM task_dependencies d WHERE d.blocker_id = tasks.id), '{}')"
+
+// taskSortExpressions maps sort options that are not plain columns to the
+// SQL expression they order by.
+var taskSortExpressions = map[string]string{
+	"priority": "CASE priority WHEN 'low' THEN 1 WHEN 'medium' THEN 2 WHEN 'high' THEN 3 WHEN 'urgent' THEN 4 END",
+}
+
+// scanTask reads a row selected with taskColumns.
+func scanTask(row interface{ Scan(...interface{}) error }) (Task, error) {
+	var task Task
+	var dueAt sql.NullTime
+	var dueTimezone sql.NullString
+	var parentID sql.NullInt64
+	var recurrence sql.NullString
+	var blockedBy, blocks pq.Int64Array
+	err := row.Scan(&task.ID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UserID, &dueAt, &dueTimezone, &task.Priority, &parentID, &recurrence, &task.Version, &task.UpdatedAt, pq.Array(&task.Tags), &blockedBy, &blocks)
+	if err != nil {
+		return Task{}, err
+	}
+	if dueAt.Valid {
+		task.DueAt = &dueAt.Time
+	}
+	task.DueTimezone = dueTimezone.String
+	task.Recurrence = recurrence.String
+	if task.Tags == nil {
+		task.Tags = make([]string, 0)
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		task.ParentID = &id
+	}
+	task.BlockedBy = intSlice(blockedBy)
+	task.Blocks = intSlice(blocks)
+	localizeDueAt(&task)
+	return task, nil
+}
+
+// nullableTime converts an optional time into a query argument.
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// nullableString converts an optional string into a query argument.
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
 }
 
+// Roles a user can have. Admins can use the /api/v1/admin endpoints.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User represents the user structure.
 type User struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
-	Password string `json:"password"`
+	Password string `json:"-"`
+	Role     string `json:"role"`
+	// DisabledAt is set while an admin has disabled the account.
+	DisabledAt *time.Time `json:"disabled_at"`
 }
 
-// GetUserByID retrieves a user by ID from the database.
-func (s *PostgresDB) GetUserByID(id int) (User, error) {
+// userColumns lists the columns scanUser reads, in order.
+const userColumns = "id, username, password, role, disabled_at"
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row interface{ Scan(...interface{}) error }) (User, error) {
 	var user User
-	row := s.DB.QueryRow("SELECT id, username, password FROM users WHERE id = $1", id)
-	err := row.Scan(&user.ID, &user.Username, &user.Password)
+	var password sql.NullString
+	var disabledAt sql.NullTime
+	if err := row.Scan(&user.ID, &user.Username, &password, &user.Role, &disabledAt); err != nil {
+		return User{}, err
+	}
+	// Users of an OpenID Connect provider have no password
+	user.Password = password.String
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+	return user, nil
+}
+
+// GetUserByID retrieves a user by ID from the database.
+func (s *PostgresDB) GetUserByID(ctx context.Context, id int) (User, error) {
+	user, err := scanUser(s.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, errors.New("user not found")
+			return User{}, ErrUserNotFound
 		}
 		return User{}, err
 	}
@@ -100,51 +407,137 @@ func (s *PostgresDB) GetUserByID(id int) (User, error) {
 }
 
 // GetUserByUsername retrieves a user by username from the database.
-func (s *PostgresDB) GetUserByUsername(username string) (User, error) {
-	var user User
-	row := s.DB.QueryRow("SELECT id, username, password FROM users WHERE username = $1", username)
-	err := row.Scan(&user.ID, &user.Username, &user.Password)
+func (s *PostgresDB) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	user, err := scanUser(s.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, errors.New("user not found")
+			return User{}, ErrUserNotFound
 		}
 		return User{}, err
 	}
 	return user, nil
 }
 
-// CreateUser creates a new user in the database.
-func (s *PostgresDB) CreateUser(newUser User) (int, error) {
+// CreateUser creates a new user in the database. It returns
+// ErrUsernameExists when the username is taken.
+func (s *PostgresDB) CreateUser(ctx context.Context, newUser User) (int, error) {
 	var id int
-	err := s.DB.QueryRow("INSERT INTO users (username, password) VALUES ($1, $2) RETURNING id",
-		newUser.Username, newUser.Password).Scan(&id)
+	if newUser.Role == "" {
+		newUser.Role = RoleUser
+	}
+	err := s.DB.QueryRowContext(ctx, "INSERT INTO users (username, password, role) VALUES ($1, $2, $3) RETURNING id",
+		newUser.Username, newUser.Password, newUser.Role).Scan(&id)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrUsernameExists
+		}
 		return 0, err
 	}
 	return id, nil
 }
 
-// GetTasksWithParmas retrieves all tasks from the database with offset,limit,sort and order.
-func (s *PostgresDB) GetTasksWithParams(userId, page, limit int, sortBy, order, status string) ([]Task, error) {
+// GetTasksWithParmas retrieves all tasks from the database with offset,limit,sort,order and filters.
+func (s *PostgresDB) GetTasksWithParams(ctx context.Context, userId, page, limit int, sortBy, order string, filter TaskFilter) ([]Task, error) {
 
 	offset := (page - 1) * limit
 
-	query := "SELECT id, title, description, status, created_at,user_id FROM tasks"
-	query += " Where user_id = $1"
-	if status != "" {
-		query += " and status = $4"
+	q := newTaskQuery("SELECT "+taskColumns, userId, filter)
+	q.query += taskOrderBy(sortBy, order, false)
+	q.query += " LIMIT " + q.arg(limit) + " OFFSET " + q.arg(offset)
+	return s.queryTasks(ctx, q)
+}
+
+// taskQuery builds a query for the tasks of a user, with its arguments.
+type taskQuery struct {
+	query string
+	args  []interface{}
+}
+
+// arg adds an argument and returns its placeholder.
+func (q *taskQuery) arg(value interface{}) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// newTaskQuery selects from the user's tasks passing filter. Further
+// conditions can be added with " and ".
+func newTaskQuery(selection string, userId int, filter TaskFilter) *taskQuery {
+	q := &taskQuery{args: []interface{}{userId}}
+	q.query = selection + " FROM tasks"
+	q.query += " Where user_id = $1"
+	if filter.Status != "" {
+		q.query += " and status = " + q.arg(filter.Status)
+	}
+	if filter.Priority != "" {
+		q.query += " and priority = " + q.arg(filter.Priority)
+	}
+	if filter.Overdue != nil {
+		if *filter.Overdue {
+			q.query += " and due_at < NOW() and status <> 'done'"
+		} else {
+			q.query += " and (due_at IS NULL or due_at >= NOW() or status = 'done')"
+		}
+	}
+	if filter.DueBefore != nil {
+		q.query += " and due_at < " + q.arg(filter.DueBefore.UTC())
+	}
+	if filter.DueAfter != nil {
+		q.query += " and due_at > " + q.arg(filter.DueAfter.UTC())
+	}
+	if len(filter.Tags) > 0 {
+		tagged := "SELECT tt.task_id FROM task_tags tt JOIN tags g ON g.id = tt.tag_id" +
+			" WHERE g.user_id = $1 and g.name = ANY(" + q.arg(pq.Array(filter.Tags)) + ")"
+		if filter.AllTags {
+			tagged += " GROUP BY tt.task_id HAVING COUNT(DISTINCT g.name) = " + q.arg(len(uniqueStrings(filter.Tags)))
+		}
+		q.query += " and id IN (" + tagged + ")"
 	}
-	query += " ORDER BY " + sortBy + " " + order
-	query += " LIMIT $2 OFFSET $3"
+	if filter.Expression != nil {
+		q.query += " and " + q.where(filter.Expression)
+	}
+	return q
+}
 
-	var rows *sql.Rows
-	var err error
+// CountTasks returns how many of the user's tasks pass filter.
+func (s *PostgresDB) CountTasks(ctx context.Context, userId int, filter TaskFilter) (int, error) {
+	q := newTaskQuery("SELECT COUNT(*)", userId, filter)
+	var count int
+	if err := s.DB.QueryRowContext(ctx, q.query, q.args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// taskSortExpression returns the SQL expression tasks are sorted by.
+func taskSortExpression(sortBy string) string {
+	if expression, ok := taskSortExpressions[sortBy]; ok {
+		return expression
+	}
+	return sortBy
+}
 
-	if status != "" {
-		rows, err = s.DB.Query(query, userId, limit, offset, status)
-	} else {
-		rows, err = s.DB.Query(query, userId, limit, offset)
+// taskOrderBy returns the ORDER BY clause of a task list, or with reverse of
+// the list read from its end. Ties are broken by ID.
+func taskOrderBy(sortBy, order string, reverse bool) string {
+	nulls, tie := " NULLS LAST", " ASC"
+	if reverse {
+		if strings.EqualFold(order, "desc") {
+			order = "asc"
+		} else {
+			order = "desc"
+		}
+		nulls, tie = " NULLS FIRST", " DESC"
 	}
+	clause := " ORDER BY " + taskSortExpression(sortBy) + " " + order
+	if sortBy == "due_at" {
+		clause += nulls
+	}
+	return clause + ", id" + tie
+}
+
+// queryTasks runs a task query.
+func (s *PostgresDB) queryTasks(ctx context.Context, q *taskQuery) ([]Task, error) {
+	rows, err := s.DB.QueryContext(ctx, q.query, q.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -152,71 +545,433 @@ func (s *PostgresDB) GetTasksWithParams(userId, page, limit int, sortBy, order,
 
 	tasks := make([]Task, 0)
 	for rows.Next() {
-		var task Task
-		if err := rows.Scan(&task.ID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UserID); err != nil {
+		task, err := scanTask(rows)
+		if err != nil {
 			return nil, err
 		}
 		tasks = append(tasks, task)
 	}
 
-	return tasks, nil
+	return tasks, rows.Err()
 }
 
 // GetTaskByID retrieves a task by its ID from the database.
-func (s *PostgresDB) GetTaskByID(userId, id int) (Task, error) {
-	var task Task
-	row := s.DB.QueryRow("SELECT id, title, description, status, created_at, user_id FROM tasks WHERE id = $1 and user_id = $2", id, userId)
-	err := row.Scan(&task.ID, &task.Title, &task.Description, &task.Status, &task.CreatedAt, &task.UserID)
+func (s *PostgresDB) GetTaskByID(ctx context.Context, userId, id int) (Task, error) {
+	row := s.DB.QueryRowContext(ctx, "SELECT "+taskColumns+" FROM tasks WHERE id = $1 and user_id = $2", id, userId)
+	task, err := scanTask(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Task{}, errors.New("task not found")
+			return Task{}, ErrTaskNotFound
 		}
 		return Task{}, err
 	}
+
+	var progress TaskProgress
+	err = s.DB.QueryRowContext(ctx, "SELECT COUNT(*) FILTER (WHERE status = 'done'), COUNT(*) FROM tasks WHERE parent_id = $1", id).
+		Scan(&progress.Done, &progress.Total)
+	if err != nil {
+		return Task{}, err
+	}
+	if progress.Total > 0 {
+		task.Progress = &progress
+	}
 	return task, nil
 }
 
-// CreateTask creates a new task in the database.
-func (s *PostgresDB) CreateTask(newTask Task) (int, error) {
+// CreateTask creates a new task and attaches its tags in the database.
+func (s *PostgresDB) CreateTask(ctx context.Context, newTask Task) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := createTaskTx(ctx, tx, newTask)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// createTaskTx creates a task under its parent, if any, inside a transaction.
+func createTaskTx(ctx context.Context, tx *sql.Tx, newTask Task) (int, error) {
+	if newTask.ParentID != nil {
+		if err := lockTask(ctx, tx, newTask.UserID, *newTask.ParentID); err != nil {
+			return 0, err
+		}
+	}
+	return insertTask(ctx, tx, newTask)
+}
+
+// insertTask inserts a task with its tags inside a transaction.
+func insertTask(ctx context.Context, tx *sql.Tx, newTask Task) (int, error) {
 	var id int
-	err := s.DB.QueryRow("INSERT INTO tasks (title, description, status, created_at, user_id) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		newTask.Title, newTask.Description, newTask.Status, time.Now(), newTask.UserID).Scan(&id)
+	err := tx.QueryRowContext(ctx, "INSERT INTO tasks (title, description, status, created_at, updated_at, user_id, due_at, due_timezone, priority, parent_id, recurrence) VALUES ($1, $2, $3, $4, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
+		newTask.Title, newTask.Description, newTask.Status, time.Now(), newTask.UserID, nullableTime(newTask.DueAt), nullableString(newTask.DueTimezone), priorityOrDefault(newTask.Priority), newTask.ParentID, nullableString(newTask.Recurrence)).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
+	if len(newTask.Tags) > 0 {
+		if err := setTaskTags(ctx, tx, newTask.UserID, id, newTask.Tags); err != nil {
+			return 0, err
+		}
+	}
 	return id, nil
 }
 
+// selectTaskForUpdate reads a task of the user and locks it until the transaction ends.
+func selectTaskForUpdate(ctx context.Context, tx *sql.Tx, userID, id int) (Task, error) {
+	row := tx.QueryRowContext(ctx, "SELECT "+taskColumns+" FROM tasks WHERE id = $1 and user_id = $2 FOR UPDATE", id, userID)
+	task, err := scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Task{}, ErrTaskNotFound
+	}
+	return task, err
+}
+
 // UpdateTaskStatusDone updates the status of an existing task in the database with 'done'.
-func (s *PostgresDB) UpdateTaskStatusDone(userID, taskID int) error {
-	_, err := s.DB.Exec("UPDATE tasks SET status = $1 WHERE id = $2 and user_id = $3", "done", taskID, userID)
+// It returns ErrTaskNotFound unless the user has the task, a *BlockedError
+// while any of the task's blockers is not done, and creates the next
+// occurrence of a recurring task.
+func (s *PostgresDB) UpdateTaskStatusDone(ctx context.Context, userID, taskID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	if err := markTaskDone(ctx, tx, userID, taskID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// markTaskDone marks a task done inside a transaction, unless it already is.
+// A task that is not found or blocked is left as it was, so the transaction
+// can go on.
+func markTaskDone(ctx context.Context, tx *sql.Tx, userID, taskID int) error {
+	task, err := selectTaskForUpdate(ctx, tx, userID, taskID)
+	if err != nil {
+		return err
+	}
+	if task.Status == "done" {
+		return nil
+	}
+	if err := checkBlockers(ctx, tx, taskID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE tasks SET status = $1, version = version + 1, updated_at = $2 WHERE id = $3 and user_id = $4", "done", time.Now(), taskID, userID)
+	if err != nil {
+		return err
+	}
+	return createNextOccurrence(ctx, tx, task)
+}
+
+// MarkTasksDone marks tasks of the user done in one transaction, all or none.
+// Tasks may wait on blockers of the same batch. When any task cannot be
+// marked done, none is and the TaskErrors returned tells why for each.
+func (s *PostgresDB) MarkTasksDone(ctx context.Context, userID int, taskIDs []int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	failed := make(TaskErrors)
+	pending := uniqueInts(taskIDs)
+	// Blocked tasks are retried for as long as others of the batch get done
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+		var blocked []int
+		for _, id := range pending {
+			err := markTaskDone(ctx, tx, userID, id)
+			var blockedErr *BlockedError
+			switch {
+			case err == nil:
+				delete(failed, id)
+				progress = true
+			case errors.As(err, &blockedErr):
+				failed[id] = err
+				blocked = append(blocked, id)
+			case errors.Is(err, ErrTaskNotFound):
+				failed[id] = err
+			default:
+				return err
+			}
+		}
+		pending = blocked
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return tx.Commit()
 }
 
 // UpdateTaskStatus updates the status of an existing task in the database by its ID.
-func (s *PostgresDB) UpdateTaskByID(userID, taskID int, updatedTask Task) error {
-	_, err := s.DB.Exec("UPDATE tasks SET title=$1, description=$2, status=$3 WHERE id=$4 and user_id=$5", updatedTask.Title, updatedTask.Description, updatedTask.Status, taskID, userID)
+// Marking it done returns a *BlockedError while any of its blockers is not
+// done, and creates the next occurrence of a recurring task.
+func (s *PostgresDB) UpdateTaskByID(ctx context.Context, userID, taskID int, updatedTask Task) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := selectTaskForUpdate(ctx, tx, userID, taskID)
+	if err != nil {
+		return err
+	}
+	if updatedTask.Version != 0 && updatedTask.Version != current.Version {
+		return ErrVersionMismatch
+	}
+	completing := updatedTask.Status == "done" && current.Status != "done"
+	if completing {
+		if err := checkBlockers(ctx, tx, taskID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE tasks SET title=$1, description=$2, status=$3, due_at=$4, due_timezone=$5, priority=$6, recurrence=$7, version=version+1, updated_at=$8 WHERE id=$9 and user_id=$10",
+		updatedTask.Title, updatedTask.Description, updatedTask.Status, nullableTime(updatedTask.DueAt), nullableString(updatedTask.DueTimezone), priorityOrDefault(updatedTask.Priority), nullableString(updatedTask.Recurrence), time.Now(), taskID, userID)
+	if err != nil {
+		return err
+	}
+	if updatedTask.Tags != nil {
+		if err := setTaskTags(ctx, tx, userID, taskID, updatedTask.Tags); err != nil {
+			return err
+		}
+	}
+	if completing {
+		if err := createNextOccurrence(ctx, tx, completedTask(current, updatedTask)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// taskFieldColumns maps the JSON names of the task fields PatchTask can
+// change to their columns. Tags live in their own table.
+var taskFieldColumns = map[string]string{
+	"title":        "title",
+	"description":  "description",
+	"status":       "status",
+	"due_at":       "due_at",
+	"due_timezone": "due_timezone",
+	"priority":     "priority",
+	"parent_id":    "parent_id",
+	"recurrence":   "recurrence",
+	"tags":         "",
+}
+
+// patchTask returns the task with the named fields taken from patch.
+func patchTask(task, patch Task, fields []string) (Task, error) {
+	for _, field := range fields {
+		switch field {
+		case "title":
+			task.Title = patch.Title
+		case "description":
+			task.Description = patch.Description
+		case "status":
+			task.Status = patch.Status
+		case "due_at":
+			task.DueAt = patch.DueAt
+		case "due_timezone":
+			task.DueTimezone = patch.DueTimezone
+		case "priority":
+			task.Priority = priorityOrDefault(patch.Priority)
+		case "parent_id":
+			task.ParentID = patch.ParentID
+		case "recurrence":
+			task.Recurrence = patch.Recurrence
+		case "tags":
+			task.Tags = patch.Tags
+			if task.Tags == nil {
+				task.Tags = []string{}
+			}
+		default:
+			return Task{}, fmt.Errorf("unknown task field %q", field)
+		}
+	}
+	return task, nil
+}
+
+// taskFieldValue returns the query argument storing a field of the task.
+func taskFieldValue(task Task, field string) interface{} {
+	switch field {
+	case "title":
+		return task.Title
+	case "description":
+		return task.Description
+	case "status":
+		return task.Status
+	case "due_at":
+		return nullableTime(task.DueAt)
+	case "due_timezone":
+		return nullableString(task.DueTimezone)
+	case "priority":
+		return task.Priority
+	case "parent_id":
+		return task.ParentID
+	default:
+		return nullableString(task.Recurrence)
+	}
+}
+
+// PatchTask changes the named fields of an existing task in the database,
+// fields being the JSON names of the task's editable fields. Only their
+// columns are written. Moving the task under one of its own subtasks returns
+// ErrTaskCycle, marking it done returns a *BlockedError while any of its
+// blockers is not done and creates the next occurrence of a recurring task.
+func (s *PostgresDB) PatchTask(ctx context.Context, userID, taskID int, patch Task, fields []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if err := patchTaskTx(ctx, tx, userID, taskID, patch, fields); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// patchTaskTx changes the named fields of a task inside a transaction.
+func patchTaskTx(ctx context.Context, tx *sql.Tx, userID, taskID int, patch Task, fields []string) error {
+	moving := false
+	for _, field := range fields {
+		moving = moving || field == "parent_id"
+	}
+	if moving {
+		if err := lockHierarchy(ctx, tx, userID); err != nil {
+			return err
+		}
+	}
+	current, err := selectTaskForUpdate(ctx, tx, userID, taskID)
+	if err != nil {
+		return err
+	}
+	if patch.Version != 0 && patch.Version != current.Version {
+		return ErrVersionMismatch
+	}
+	task, err := patchTask(current, patch, fields)
+	if err != nil {
+		return err
+	}
+	if moving && task.ParentID != nil {
+		if err := lockTask(ctx, tx, userID, *task.ParentID); err != nil {
+			return err
+		}
+		// The new parent must not be the task itself or one of its descendants
+		var cycle bool
+		err := tx.QueryRowContext(ctx, "WITH RECURSIVE ancestors AS ("+
+			"SELECT id, parent_id FROM tasks WHERE id = $1"+
+			" UNION SELECT t.id, t.parent_id FROM tasks t JOIN ancestors a ON t.id = a.parent_id"+
+			") SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)", *task.ParentID, taskID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrTaskCycle
+		}
+	}
+	completing := task.Status == "done" && current.Status != "done"
+	if completing {
+		if err := checkBlockers(ctx, tx, taskID); err != nil {
+			return err
+		}
+	}
+
+	set := []string{"version = version + 1", "updated_at = $3"}
+	args := []interface{}{taskID, userID, time.Now()}
+	for _, field := range fields {
+		column := taskFieldColumns[field]
+		if column == "" {
+			continue
+		}
+		args = append(args, taskFieldValue(task, field))
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE tasks SET "+strings.Join(set, ", ")+" WHERE id = $1 and user_id = $2", args...); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if field == "tags" {
+			if err := setTaskTags(ctx, tx, userID, taskID, task.Tags); err != nil {
+				return err
+			}
+		}
+	}
+	if completing {
+		if err := createNextOccurrence(ctx, tx, task); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// DeleteTask deletes a task by its ID from the database.
-func (s *PostgresDB) DeleteTask(userID, id int) error {
-	result, err := s.DB.Exec("DELETE FROM tasks WHERE id = $1 and user_id= $2", id, userID)
+// DeleteTask deletes a task by its ID from the database, handling its
+// subtasks according to the given policy. A non-zero version makes it fail
+// with ErrVersionMismatch unless the task is still at that version.
+func (s *PostgresDB) DeleteTask(ctx context.Context, userID, id, version int, children ChildDeletePolicy) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteTaskTx(ctx, tx, userID, id, version, children); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteTaskTx deletes a task inside a transaction, handling its subtasks
+// according to the given policy.
+func deleteTaskTx(ctx context.Context, tx *sql.Tx, userID, id, version int, children ChildDeletePolicy) error {
+	if err := lockHierarchy(ctx, tx, userID); err != nil {
+		return err
+	}
+	var parentID sql.NullInt64
+	var current int
+	err := tx.QueryRowContext(ctx, "SELECT parent_id, version FROM tasks WHERE id = $1 and user_id = $2 FOR UPDATE", id, userID).Scan(&parentID, &current)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTaskNotFound
+		}
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+	if version != 0 && version != current {
+		return ErrVersionMismatch
+	}
+
+	switch children {
+	case RestrictChildren:
+		var hasChildren bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM tasks WHERE parent_id = $1)", id).Scan(&hasChildren); err != nil {
+			return err
+		}
+		if hasChildren {
+			return ErrTaskHasChildren
+		}
+	case CascadeChildren:
+		_, err = tx.ExecContext(ctx, "WITH RECURSIVE descendants AS ("+
+			"SELECT id FROM tasks WHERE parent_id = $1"+
+			" UNION SELECT t.id FROM tasks t JOIN descendants d ON t.parent_id = d.id"+
+			") DELETE FROM tasks WHERE id IN (SELECT id FROM descendants)", id)
+	case ReparentChildren:
+		_, err = tx.ExecContext(ctx, "UPDATE tasks SET parent_id = $1, version = version + 1, updated_at = $2 WHERE parent_id = $3", parentID, time.Now(), id)
+	default:
+		_, err = tx.ExecContext(ctx, "UPDATE tasks SET parent_id = NULL, version = version + 1, updated_at = $1 WHERE parent_id = $2", time.Now(), id)
+	}
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return errors.New("task not found")
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tasks WHERE id = $1 and user_id= $2", id, userID); err != nil {
+		return err
 	}
 	return nil
 }
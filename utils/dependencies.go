@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// BlockedError is returned when a task cannot be marked done because some of
+// its blockers are not done yet.
+type BlockedError struct {
+	// Blockers holds the IDs of the blockers that are not done, in ascending order.
+	Blockers []int
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("task is blocked by %v", e.Blockers)
+}
+
+// uniqueInts returns the distinct values in ascending order.
+func uniqueInts(values []int) []int {
+	seen := make(map[int]bool, len(values))
+	unique := make([]int, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Ints(unique)
+	return unique
+}
+
+// intSlice converts a scanned integer array.
+func intSlice(values pq.Int64Array) []int {
+	ints := make([]int, len(values))
+	for i, value := range values {
+		ints[i] = int(value)
+	}
+	return ints
+}
+
+// dependencyLockClass namespaces the advisory locks taken on a user's task dependencies.
+const dependencyLockClass = 2
+
+// lockDependencies serialises changes to the dependencies of a user's tasks
+// until the transaction ends, so concurrent additions cannot form a cycle.
+func lockDependencies(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2)", dependencyLockClass, userID)
+	return err
+}
+
+// checkBlockers returns a *BlockedError while a task has open blockers. The
+// blockers stay locked until the transaction ends, so none of them can be
+// reopened before the task is marked done.
+func checkBlockers(ctx context.Context, tx *sql.Tx, taskID int) error {
+	rows, err := tx.QueryContext(ctx, "SELECT b.id FROM task_dependencies d JOIN tasks b ON b.id = d.blocker_id"+
+		" WHERE d.task_id = $1 and b.status <> 'done' ORDER BY b.id FOR SHARE OF b", taskID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	blockers := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		blockers = append(blockers, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(blockers) > 0 {
+		return &BlockedError{Blockers: blockers}
+	}
+	return nil
+}
+
+// AddTaskBlocker records in the database that a task is blocked by another
+// task of the same user. Adding an existing dependency is a no-op.
+func (s *PostgresDB) AddTaskBlocker(ctx context.Context, userID, taskID, blockerID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockDependencies(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := lockTask(ctx, tx, userID, taskID); err != nil {
+		return err
+	}
+	if err := lockTask(ctx, tx, userID, blockerID); err != nil {
+		return err
+	}
+	if taskID == blockerID {
+		return ErrDependencyCycle
+	}
+	// The blocker must not already wait on the task, directly or transitively
+	var cycle bool
+	err = tx.QueryRowContext(ctx, "WITH RECURSIVE blockers AS ("+
+		"SELECT blocker_id FROM task_dependencies WHERE task_id = $1"+
+		" UNION SELECT d.blocker_id FROM task_dependencies d JOIN blockers b ON d.task_id = b.blocker_id"+
+		") SELECT EXISTS (SELECT 1 FROM blockers WHERE blocker_id = $2)", blockerID, taskID).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrDependencyCycle
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO task_dependencies (task_id, blocker_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", taskID, blockerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveTaskBlocker deletes a dependency between two tasks from the database.
+func (s *PostgresDB) RemoveTaskBlocker(ctx context.Context, userID, taskID, blockerID int) error {
+	if _, err := s.GetTaskByID(ctx, userID, taskID); err != nil {
+		return err
+	}
+
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM task_dependencies WHERE task_id = $1 and blocker_id = $2", taskID, blockerID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrDependencyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ExternalIdentity links a user to the subject of an OpenID Connect provider.
+type ExternalIdentity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLogin is a login waiting for the OpenID Connect provider to redirect
+// back with the state it was started with.
+type OIDCLogin struct {
+	ID           int       `json:"id"`
+	StateHash    string    `json:"-"`
+	Nonce        string    `json:"-"`
+	CodeVerifier string    `json:"-"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// GetUserByExternalIdentity retrieves the user linked to an issuer and
+// subject from the database.
+func (s *PostgresDB) GetUserByExternalIdentity(ctx context.Context, issuer, subject string) (User, error) {
+	user, err := scanUser(s.DB.QueryRowContext(ctx, "SELECT u."+userColumns+` FROM users u
+		JOIN external_identities e ON e.user_id = u.id WHERE e.issuer = $1 and e.subject = $2`, issuer, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+	return user, nil
+}
+
+// CreateExternalUser stores a new user without a password in the database,
+// linked to the identity. It returns ErrUsernameExists when the username is
+// taken and ErrExternalIdentityExists when the identity is already linked.
+func (s *PostgresDB) CreateExternalUser(ctx context.Context, newUser User, identity ExternalIdentity) (User, error) {
+	if newUser.Role == "" {
+		newUser.Role = RoleUser
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, "INSERT INTO users (username, role) VALUES ($1, $2) RETURNING id",
+		newUser.Username, newUser.Role).Scan(&newUser.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return User{}, ErrUsernameExists
+		}
+		return User{}, err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO external_identities (user_id, issuer, subject) VALUES ($1, $2, $3)",
+		newUser.ID, identity.Issuer, identity.Subject)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return User{}, ErrExternalIdentityExists
+		}
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	newUser.Password = ""
+	return newUser, nil
+}
+
+// CreateOIDCLogin stores a pending login in the database, dropping expired
+// ones on the way.
+func (s *PostgresDB) CreateOIDCLogin(ctx context.Context, login OIDCLogin) error {
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM oidc_logins WHERE expires_at < NOW()"); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO oidc_logins (state_hash, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4)",
+		login.StateHash, login.Nonce, login.CodeVerifier, login.ExpiresAt.UTC())
+	return err
+}
+
+// ConsumeOIDCLogin removes a pending login from the database and returns it.
+// It returns ErrOIDCLoginInvalid when the state is unknown, was already used
+// or has expired.
+func (s *PostgresDB) ConsumeOIDCLogin(ctx context.Context, stateHash string) (OIDCLogin, error) {
+	var login OIDCLogin
+	err := s.DB.QueryRowContext(ctx, `DELETE FROM oidc_logins WHERE state_hash = $1
+		RETURNING id, state_hash, nonce, code_verifier, expires_at`, stateHash).
+		Scan(&login.ID, &login.StateHash, &login.Nonce, &login.CodeVerifier, &login.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return OIDCLogin{}, ErrOIDCLoginInvalid
+		}
+		return OIDCLogin{}, err
+	}
+	if !login.ExpiresAt.After(time.Now()) {
+		return OIDCLogin{}, ErrOIDCLoginInvalid
+	}
+	return login, nil
+}
+
+// GetExternalIdentities retrieves the identities linked to a user from the
+// database, oldest first.
+func (s *PostgresDB) GetExternalIdentities(ctx context.Context, userID int) ([]ExternalIdentity, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, user_id, issuer, subject, created_at FROM external_identities WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]ExternalIdentity, 0)
+	for rows.Next() {
+		var identity ExternalIdentity
+		if err := rows.Scan(&identity.ID, &identity.UserID, &identity.Issuer, &identity.Subject, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
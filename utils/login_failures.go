@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LoginFailure counts the consecutive failed logins for a username or a
+// client IP.
+type LoginFailure struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+}
+
+// GetLoginFailures retrieves the failed logins for the given keys from the
+// database, leaving out keys whose last failure was before since.
+func (s *PostgresDB) GetLoginFailures(ctx context.Context, keys []string, since time.Time) ([]LoginFailure, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT key, failures, last_failure_at FROM login_failures WHERE key = ANY($1) and last_failure_at >= $2",
+		pq.Array(keys), since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []LoginFailure{}
+	for rows.Next() {
+		var failure LoginFailure
+		if err := rows.Scan(&failure.Key, &failure.Failures, &failure.LastFailureAt); err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+	return failures, rows.Err()
+}
+
+// RecordLoginFailure counts a failed login for the key in the database and
+// returns the new count. Failures before since are forgotten, and so are
+// other keys whose last failure was before since.
+func (s *PostgresDB) RecordLoginFailure(ctx context.Context, key string, since time.Time) (LoginFailure, error) {
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM login_failures WHERE last_failure_at < $1 and key <> $2", since.UTC(), key); err != nil {
+		return LoginFailure{}, err
+	}
+	failure := LoginFailure{Key: key}
+	err := s.DB.QueryRowContext(ctx, `INSERT INTO login_failures (key, failures, last_failure_at) VALUES ($1, 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET last_failure_at = NOW(),
+			failures = CASE WHEN login_failures.last_failure_at < $2 THEN 1 ELSE login_failures.failures + 1 END
+		RETURNING failures, last_failure_at`, key, since.UTC()).Scan(&failure.Failures, &failure.LastFailureAt)
+	if err != nil {
+		return LoginFailure{}, err
+	}
+	return failure, nil
+}
+
+// ClearLoginFailures forgets the failed logins for the key in the database.
+func (s *PostgresDB) ClearLoginFailures(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	return err
+}
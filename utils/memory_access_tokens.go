@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// CreatePersonalAccessToken stores a new personal access token in memory and
+// returns it as stored.
+func (m *MemoryDB) CreatePersonalAccessToken(ctx context.Context, token PersonalAccessToken) (PersonalAccessToken, error) {
+	if err := ctx.Err(); err != nil {
+		return PersonalAccessToken{}, err
+	}
+	if token.Name == "" || len(token.Name) > 50 {
+		return PersonalAccessToken{}, errors.New("invalid token name")
+	}
+	if token.Scope != ScopeTasksRead && token.Scope != ScopeTasksWrite {
+		return PersonalAccessToken{}, errors.New("invalid token scope")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[token.UserID]; !ok {
+		return PersonalAccessToken{}, errors.New("token owner does not exist")
+	}
+	for _, existing := range m.accessTokens {
+		if existing.TokenHash == token.TokenHash {
+			return PersonalAccessToken{}, errors.New("token already exists")
+		}
+		if existing.UserID == token.UserID && existing.Name == token.Name {
+			return PersonalAccessToken{}, ErrPersonalAccessTokenExists
+		}
+	}
+	token.ID = m.nextAccessTokenID
+	token.CreatedAt = time.Now()
+	token.LastUsedAt = nil
+	if token.ExpiresAt != nil {
+		expiresAt := token.ExpiresAt.UTC()
+		token.ExpiresAt = &expiresAt
+	}
+	m.nextAccessTokenID++
+	m.accessTokens[token.ID] = token
+	return token, nil
+}
+
+// GetPersonalAccessTokens retrieves the user's personal access tokens from memory.
+func (m *MemoryDB) GetPersonalAccessTokens(ctx context.Context, userID int) ([]PersonalAccessToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tokens := make([]PersonalAccessToken, 0)
+	for _, token := range m.accessTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// DeletePersonalAccessToken revokes one of the user's personal access tokens in memory.
+func (m *MemoryDB) DeletePersonalAccessToken(ctx context.Context, userID, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.accessTokens[id]
+	if !ok || token.UserID != userID {
+		return ErrPersonalAccessTokenNotFound
+	}
+	delete(m.accessTokens, id)
+	return nil
+}
+
+// UsePersonalAccessToken looks up the personal access token with the given
+// hash in memory and records that it was used. It returns
+// ErrPersonalAccessTokenInvalid when the token is unknown or expired.
+func (m *MemoryDB) UsePersonalAccessToken(ctx context.Context, tokenHash string) (PersonalAccessToken, error) {
+	if err := ctx.Err(); err != nil {
+		return PersonalAccessToken{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, token := range m.accessTokens {
+		if token.TokenHash != tokenHash {
+			continue
+		}
+		if token.ExpiresAt != nil && !token.ExpiresAt.After(now) {
+			return PersonalAccessToken{}, ErrPersonalAccessTokenInvalid
+		}
+		token.LastUsedAt = &now
+		m.accessTokens[id] = token
+		return token, nil
+	}
+	return PersonalAccessToken{}, ErrPersonalAccessTokenInvalid
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// CreateAuditEntry appends an entry to the audit log in memory.
+func (m *MemoryDB) CreateAuditEntry(ctx context.Context, entry AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(entry.Action) > 100 {
+		return errors.New("action too long")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.ID = len(m.auditLog) + 1
+	entry.CreatedAt = time.Now()
+	m.auditLog = append(m.auditLog, entry)
+	return nil
+}
+
+// GetAuditLog retrieves a page of the audit log from memory, newest first.
+func (m *MemoryDB) GetAuditLog(ctx context.Context, page, limit int) ([]AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]AuditEntry, 0, limit)
+	for i := len(m.auditLog) - 1 - (page-1)*limit; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, m.auditLog[i])
+	}
+	return entries, nil
+}
+
+// GetAuditLogForUser retrieves every audit log entry about the user from
+// memory, newest first.
+func (m *MemoryDB) GetAuditLogForUser(ctx context.Context, userID int) ([]AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]AuditEntry, 0)
+	for i := len(m.auditLog) - 1; i >= 0; i-- {
+		if target := m.auditLog[i].TargetUserID; target != nil && *target == userID {
+			entries = append(entries, m.auditLog[i])
+		}
+	}
+	return entries, nil
+}
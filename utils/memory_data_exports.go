@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// memoryDataExport is an export with its archive, as kept in memory.
+type memoryDataExport struct {
+	DataExport
+	archive []byte
+}
+
+// CreateDataExport stores a pending export in memory and returns it.
+// Expired exports are dropped on the way, and pending ones started before
+// staleBefore are marked failed as their builder is gone. It returns
+// ErrDataExportInProgress while another export of the user is pending.
+func (m *MemoryDB) CreateDataExport(ctx context.Context, export DataExport, staleBefore time.Time) (DataExport, error) {
+	if err := ctx.Err(); err != nil {
+		return DataExport{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[export.UserID]; !ok {
+		return DataExport{}, ErrUserNotFound
+	}
+	now := time.Now()
+	pending := false
+	for id, existing := range m.dataExports {
+		switch {
+		case existing.ExpiresAt != nil && existing.ExpiresAt.Before(now):
+			delete(m.dataExports, id)
+		case existing.UserID != export.UserID || existing.Status != DataExportPending:
+		case existing.CreatedAt.Before(staleBefore):
+			existing.Status = DataExportFailed
+			existing.CompletedAt = &now
+		default:
+			pending = true
+		}
+	}
+	if pending {
+		return DataExport{}, ErrDataExportInProgress
+	}
+	for _, existing := range m.dataExports {
+		if existing.TokenHash == export.TokenHash {
+			return DataExport{}, errors.New("data export token already exists")
+		}
+	}
+
+	export.ID = m.nextDataExportID
+	m.nextDataExportID++
+	export.Status = DataExportPending
+	export.CreatedAt = now
+	export.CompletedAt = nil
+	export.ExpiresAt = nil
+	m.dataExports[export.ID] = &memoryDataExport{DataExport: export}
+	return export, nil
+}
+
+// GetDataExport retrieves one of the user's exports from memory, without its
+// archive.
+func (m *MemoryDB) GetDataExport(ctx context.Context, userID, id int) (DataExport, error) {
+	if err := ctx.Err(); err != nil {
+		return DataExport{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	export, ok := m.dataExports[id]
+	if !ok || export.UserID != userID {
+		return DataExport{}, ErrDataExportNotFound
+	}
+	return export.DataExport, nil
+}
+
+// CompleteDataExport stores the archive of a pending export in memory and
+// makes it downloadable until expiresAt.
+func (m *MemoryDB) CompleteDataExport(ctx context.Context, id int, archive []byte, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	export, ok := m.dataExports[id]
+	if !ok || export.Status != DataExportPending {
+		return ErrDataExportNotFound
+	}
+	now := time.Now()
+	export.Status = DataExportReady
+	export.CompletedAt = &now
+	export.ExpiresAt = &expiresAt
+	export.archive = append([]byte(nil), archive...)
+	return nil
+}
+
+// FailDataExport marks a pending export failed in memory.
+func (m *MemoryDB) FailDataExport(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	export, ok := m.dataExports[id]
+	if !ok || export.Status != DataExportPending {
+		return ErrDataExportNotFound
+	}
+	now := time.Now()
+	export.Status = DataExportFailed
+	export.CompletedAt = &now
+	return nil
+}
+
+// GetDataExportArchive retrieves a ready export and its archive by the hash
+// of its download token from memory. It returns ErrDataExportNotFound for
+// unknown tokens and for exports that are not ready or have expired.
+func (m *MemoryDB) GetDataExportArchive(ctx context.Context, tokenHash string) (DataExport, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return DataExport{}, nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, export := range m.dataExports {
+		if export.TokenHash != tokenHash {
+			continue
+		}
+		if export.Status != DataExportReady || !export.ExpiresAt.After(time.Now()) {
+			break
+		}
+		return export.DataExport, append([]byte(nil), export.archive...), nil
+	}
+	return DataExport{}, nil, ErrDataExportNotFound
+}
@@ -0,0 +1,696 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDB is an in-memory Storage implementation. It mirrors the behaviour
+// and constraints of PostgresDB so it can back handler tests and local
+// development without a database server.
+type MemoryDB struct {
+	mu           sync.RWMutex
+	users        map[int]User
+	tasks        map[int]Task
+	tags         map[int]Tag
+	taskTags     map[int]map[int]bool // task ID -> set of tag IDs
+	dependencies map[int]map[int]bool // task ID -> set of blocker task IDs
+	// refreshTokens holds every issued refresh token by ID
+	refreshTokens       map[int]RefreshToken
+	passwordResets      map[int]PasswordResetToken
+	auditLog            []AuditEntry
+	accessTokens        map[int]PersonalAccessToken
+	totpCredentials     map[int]TOTPCredential  // user ID -> credential
+	recoveryCodes       map[int]map[string]bool // user ID -> code hash -> used
+	mfaChallenges       map[int]MFAChallenge
+	loginFailures       map[string]LoginFailure // key -> failures
+	signingKeys         map[string]SigningKey   // kid -> key
+	externalIdentities  []ExternalIdentity
+	oidcLogins          map[string]OIDCLogin // state hash -> login
+	profiles            map[int]Profile      // user ID -> profile, if changed
+	dataExports         map[int]*memoryDataExport
+	nextUserID          int
+	nextTaskID          int
+	nextTagID           int
+	nextRefreshTokenID  int
+	nextPasswordResetID int
+	nextAccessTokenID   int
+	nextMFAChallengeID  int
+	nextOIDCLoginID     int
+	nextDataExportID    int
+}
+
+// Create MemoryDB
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		users:               make(map[int]User),
+		tasks:               make(map[int]Task),
+		tags:                make(map[int]Tag),
+		taskTags:            make(map[int]map[int]bool),
+		dependencies:        make(map[int]map[int]bool),
+		refreshTokens:       make(map[int]RefreshToken),
+		passwordResets:      make(map[int]PasswordResetToken),
+		accessTokens:        make(map[int]PersonalAccessToken),
+		totpCredentials:     make(map[int]TOTPCredential),
+		recoveryCodes:       make(map[int]map[string]bool),
+		mfaChallenges:       make(map[int]MFAChallenge),
+		loginFailures:       make(map[string]LoginFailure),
+		signingKeys:         make(map[string]SigningKey),
+		oidcLogins:          make(map[string]OIDCLogin),
+		profiles:            make(map[int]Profile),
+		dataExports:         make(map[int]*memoryDataExport),
+		nextUserID:          1,
+		nextTaskID:          1,
+		nextTagID:           1,
+		nextRefreshTokenID:  1,
+		nextPasswordResetID: 1,
+		nextAccessTokenID:   1,
+		nextMFAChallengeID:  1,
+		nextOIDCLoginID:     1,
+		nextDataExportID:    1,
+	}
+}
+
+var validRoles = map[string]bool{
+	RoleUser:  true,
+	RoleAdmin: true,
+}
+
+var validTaskStatuses = map[string]bool{
+	"todo":        true,
+	"in progress": true,
+	"done":        true,
+}
+
+// checkTask enforces the constraints of the tasks table.
+func checkTask(task Task) error {
+	if len(task.Title) > 100 {
+		return errors.New("title too long")
+	}
+	if !validTaskStatuses[task.Status] {
+		return errors.New("invalid task status")
+	}
+	if len(task.DueTimezone) > 64 {
+		return errors.New("due timezone too long")
+	}
+	if priorityRank(task.Priority) == 0 {
+		return errors.New("invalid task priority")
+	}
+	if len(task.Recurrence) > 255 {
+		return errors.New("recurrence too long")
+	}
+	return nil
+}
+
+// GetUserByID retrieves a user by ID from memory.
+func (m *MemoryDB) GetUserByID(ctx context.Context, id int) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetUserByUsername retrieves a user by username from memory.
+func (m *MemoryDB) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+// CreateUser creates a new user in memory. It returns ErrUsernameExists
+// when the username is taken.
+func (m *MemoryDB) CreateUser(ctx context.Context, newUser User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if newUser.Username == "" || len(newUser.Username) > 50 {
+		return 0, errors.New("invalid username")
+	}
+	if len(newUser.Password) < 8 || len(newUser.Password) > 100 {
+		return 0, errors.New("invalid password")
+	}
+	if newUser.Role == "" {
+		newUser.Role = RoleUser
+	}
+	if !validRoles[newUser.Role] {
+		return 0, ErrInvalidRole
+	}
+	for _, user := range m.users {
+		if user.Username == newUser.Username {
+			return 0, ErrUsernameExists
+		}
+	}
+
+	newUser.ID = m.nextUserID
+	m.nextUserID++
+	m.users[newUser.ID] = newUser
+	return newUser.ID, nil
+}
+
+// GetTasksWithParams retrieves the user's tasks from memory with offset,limit,sort,order and filters.
+func (m *MemoryDB) GetTasksWithParams(ctx context.Context, userId, page, limit int, sortBy, order string, filter TaskFilter) ([]Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tasks, _, err := m.sortedTasks(userId, sortBy, order, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := (page - 1) * limit
+	if offset >= len(tasks) {
+		return make([]Task, 0), nil
+	}
+	end := offset + limit
+	if end > len(tasks) {
+		end = len(tasks)
+	}
+	return tasks[offset:end], nil
+}
+
+// sortedTasks returns the user's tasks passing filter in list order, with
+// the ordering they are sorted by.
+func (m *MemoryDB) sortedTasks(userId int, sortBy, order string, filter TaskFilter) ([]Task, func(a, b Task) bool, error) {
+	less, err := taskOrder(sortBy, order)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.mu.RLock()
+	tasks := m.filteredTasks(userId, filter)
+	m.mu.RUnlock()
+
+	sort.Slice(tasks, func(i, j int) bool { return less(tasks[i], tasks[j]) })
+	return tasks, less, nil
+}
+
+// CountTasks returns how many of the user's tasks pass filter.
+func (m *MemoryDB) CountTasks(ctx context.Context, userId int, filter TaskFilter) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.filteredTasks(userId, filter)), nil
+}
+
+// filteredTasks returns the user's tasks passing filter, in no order.
+// Callers hold m.mu.
+func (m *MemoryDB) filteredTasks(userId int, filter TaskFilter) []Task {
+	now := time.Now()
+	matchesExpression := taskPredicate(filter.Expression)
+	tasks := make([]Task, 0)
+	for _, task := range m.tasks {
+		if task.UserID != userId {
+			continue
+		}
+		task.Tags = m.taskTagNames(task.ID)
+		task.BlockedBy = m.blockerIDs(task.ID)
+		task.Blocks = m.blockedIDs(task.ID)
+		if !filter.matches(task, now) || !matchesExpression(task) {
+			continue
+		}
+		localizeDueAt(&task)
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// taskOrder returns the ordering of a task list, with ties broken by ID.
+func taskOrder(sortBy, order string) (func(a, b Task) bool, error) {
+	less, err := taskLess(sortBy)
+	if err != nil {
+		return nil, err
+	}
+	var desc bool
+	switch strings.ToLower(order) {
+	case "asc":
+	case "desc":
+		desc = true
+	default:
+		return nil, errors.New("invalid sort order")
+	}
+	return func(a, b Task) bool {
+		// Tasks without a due date come last in either order
+		if sortBy == "due_at" && (a.DueAt == nil) != (b.DueAt == nil) {
+			return b.DueAt == nil
+		}
+		x, y := a, b
+		if desc {
+			x, y = b, a
+		}
+		if less(x, y) {
+			return true
+		}
+		if less(y, x) {
+			return false
+		}
+		return a.ID < b.ID
+	}, nil
+}
+
+// taskLess returns the ascending ordering for a sortable task column.
+func taskLess(sortBy string) (func(a, b Task) bool, error) {
+	switch sortBy {
+	case "title":
+		return func(a, b Task) bool { return a.Title < b.Title }, nil
+	case "description":
+		return func(a, b Task) bool { return a.Description < b.Description }, nil
+	case "status":
+		return func(a, b Task) bool { return a.Status < b.Status }, nil
+	case "created_at":
+		return func(a, b Task) bool { return a.CreatedAt.Before(b.CreatedAt) }, nil
+	case "due_at":
+		return func(a, b Task) bool { return a.DueAt != nil && b.DueAt != nil && a.DueAt.Before(*b.DueAt) }, nil
+	case "priority":
+		return func(a, b Task) bool { return priorityRank(a.Priority) < priorityRank(b.Priority) }, nil
+	}
+	return nil, errors.New("invalid sort option")
+}
+
+// matches reports whether a task passes the filter at the given time.
+func (f TaskFilter) matches(task Task, now time.Time) bool {
+	if f.Status != "" && task.Status != f.Status {
+		return false
+	}
+	if f.Priority != "" && task.Priority != f.Priority {
+		return false
+	}
+	if f.Overdue != nil {
+		overdue := task.DueAt != nil && task.DueAt.Before(now) && task.Status != "done"
+		if overdue != *f.Overdue {
+			return false
+		}
+	}
+	if f.DueBefore != nil && (task.DueAt == nil || !task.DueAt.Before(*f.DueBefore)) {
+		return false
+	}
+	if f.DueAfter != nil && (task.DueAt == nil || !task.DueAt.After(*f.DueAfter)) {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		matched := 0
+		wanted := uniqueStrings(f.Tags)
+		for _, name := range wanted {
+			for _, tag := range task.Tags {
+				if tag == name {
+					matched++
+					break
+				}
+			}
+		}
+		if matched == 0 || (f.AllTags && matched < len(wanted)) {
+			return false
+		}
+	}
+	return true
+}
+
+// utcCopy returns a UTC copy of an optional time, so stored tasks never share
+// a pointer with their callers.
+func utcCopy(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// GetTaskByID retrieves a task by its ID from memory.
+func (m *MemoryDB) GetTaskByID(ctx context.Context, userId, id int) (Task, error) {
+	if err := ctx.Err(); err != nil {
+		return Task{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	task, ok := m.tasks[id]
+	if !ok || task.UserID != userId {
+		return Task{}, ErrTaskNotFound
+	}
+	task.Tags = m.taskTagNames(task.ID)
+	task.BlockedBy = m.blockerIDs(task.ID)
+	task.Blocks = m.blockedIDs(task.ID)
+	var progress TaskProgress
+	for _, child := range m.tasks {
+		if child.ParentID != nil && *child.ParentID == id {
+			progress.Total++
+			if child.Status == "done" {
+				progress.Done++
+			}
+		}
+	}
+	if progress.Total > 0 {
+		task.Progress = &progress
+	}
+	localizeDueAt(&task)
+	return task, nil
+}
+
+// CreateTask creates a new task in memory.
+func (m *MemoryDB) CreateTask(ctx context.Context, newTask Task) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.createTask(newTask)
+}
+
+// createTask checks and stores a new task. Callers hold m.mu for writing.
+func (m *MemoryDB) createTask(newTask Task) (int, error) {
+	newTask.Priority = priorityOrDefault(newTask.Priority)
+	if err := checkTask(newTask); err != nil {
+		return 0, err
+	}
+	if _, ok := m.users[newTask.UserID]; !ok {
+		return 0, errors.New("task owner does not exist")
+	}
+	if err := checkTagNames(newTask.Tags); err != nil {
+		return 0, err
+	}
+	if newTask.ParentID != nil {
+		if parent, ok := m.tasks[*newTask.ParentID]; !ok || parent.UserID != newTask.UserID {
+			return 0, ErrTaskNotFound
+		}
+		parentID := *newTask.ParentID
+		newTask.ParentID = &parentID
+	}
+	return m.insertTask(newTask), nil
+}
+
+// insertTask stores a checked task with its tags and returns its ID. Callers
+// hold m.mu for writing.
+func (m *MemoryDB) insertTask(newTask Task) int {
+	newTask.Progress = nil
+	newTask.ID = m.nextTaskID
+	newTask.CreatedAt = time.Now()
+	newTask.UpdatedAt = newTask.CreatedAt
+	newTask.Version = 1
+	newTask.DueAt = utcCopy(newTask.DueAt)
+	m.nextTaskID++
+	if len(newTask.Tags) > 0 {
+		m.setTaskTags(newTask.UserID, newTask.ID, newTask.Tags)
+	}
+	newTask.Tags = nil
+	newTask.BlockedBy = nil
+	newTask.Blocks = nil
+	m.tasks[newTask.ID] = newTask
+	return newTask.ID
+}
+
+// UpdateTaskStatusDone updates the status of an existing task in memory with 'done'.
+// It returns ErrTaskNotFound unless the user has the task, a *BlockedError
+// while any of the task's blockers is not done, and creates the next
+// occurrence of a recurring task.
+func (m *MemoryDB) UpdateTaskStatusDone(ctx context.Context, userID, taskID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, ok := m.tasks[taskID]
+	if !ok || task.UserID != userID {
+		return ErrTaskNotFound
+	}
+	if err := m.checkBlockers(task); err != nil {
+		return err
+	}
+	m.markTaskDone(taskID)
+	return nil
+}
+
+// markTaskDone marks a checked task done, unless it already is. Callers hold
+// m.mu for writing.
+func (m *MemoryDB) markTaskDone(taskID int) {
+	task := m.tasks[taskID]
+	if task.Status == "done" {
+		return
+	}
+	task.Status = "done"
+	task.Version++
+	task.UpdatedAt = time.Now()
+	m.tasks[taskID] = task
+	m.createNextOccurrence(task)
+}
+
+// MarkTasksDone marks tasks of the user done in memory, all or none. Tasks
+// may wait on blockers of the same batch. When any task cannot be marked
+// done, none is and the TaskErrors returned tells why for each.
+func (m *MemoryDB) MarkTasksDone(ctx context.Context, userID int, taskIDs []int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	done := make(map[int]bool)
+	failed := make(TaskErrors)
+	pending := uniqueInts(taskIDs)
+	// Blocked tasks are retried for as long as others of the batch get done
+	for progress := true; progress && len(pending) > 0; {
+		progress = false
+		var blocked []int
+		for _, id := range pending {
+			task, ok := m.tasks[id]
+			if !ok || task.UserID != userID {
+				failed[id] = ErrTaskNotFound
+				continue
+			}
+			if err := m.checkBlockersWith(task, done); err != nil {
+				failed[id] = err
+				blocked = append(blocked, id)
+				continue
+			}
+			delete(failed, id)
+			done[id] = true
+			progress = true
+		}
+		pending = blocked
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	for _, id := range uniqueInts(taskIDs) {
+		m.markTaskDone(id)
+	}
+	return nil
+}
+
+// UpdateTaskByID updates the title, description and status of an existing task in memory.
+// Marking it done returns a *BlockedError while any of its blockers is not
+// done, and creates the next occurrence of a recurring task.
+func (m *MemoryDB) UpdateTaskByID(ctx context.Context, userID, taskID int, updatedTask Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, ok := m.tasks[taskID]
+	if !ok || task.UserID != userID {
+		return ErrTaskNotFound
+	}
+	if updatedTask.Version != 0 && updatedTask.Version != task.Version {
+		return ErrVersionMismatch
+	}
+	completing := updatedTask.Status == "done" && task.Status != "done"
+	if completing {
+		if err := m.checkBlockers(task); err != nil {
+			return err
+		}
+	}
+	task.Title = updatedTask.Title
+	task.Description = updatedTask.Description
+	task.Status = updatedTask.Status
+	task.DueAt = utcCopy(updatedTask.DueAt)
+	task.DueTimezone = updatedTask.DueTimezone
+	task.Priority = priorityOrDefault(updatedTask.Priority)
+	task.Recurrence = updatedTask.Recurrence
+	task.Version++
+	task.UpdatedAt = time.Now()
+	if err := checkTask(task); err != nil {
+		return err
+	}
+	if err := checkTagNames(updatedTask.Tags); err != nil {
+		return err
+	}
+	if updatedTask.Tags != nil {
+		m.setTaskTags(userID, taskID, updatedTask.Tags)
+	}
+	m.tasks[taskID] = task
+	if completing {
+		m.createNextOccurrence(task)
+	}
+	return nil
+}
+
+// PatchTask changes the named fields of an existing task in memory. Moving
+// the task under one of its own subtasks returns ErrTaskCycle, marking it
+// done returns a *BlockedError while any of its blockers is not done and
+// creates the next occurrence of a recurring task.
+func (m *MemoryDB) PatchTask(ctx context.Context, userID, taskID int, patch Task, fields []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.changeTask(userID, taskID, patch, fields)
+}
+
+// changeTask changes the named fields of a task. Callers hold m.mu for
+// writing.
+func (m *MemoryDB) changeTask(userID, taskID int, patch Task, fields []string) error {
+	current, ok := m.tasks[taskID]
+	if !ok || current.UserID != userID {
+		return ErrTaskNotFound
+	}
+	if patch.Version != 0 && patch.Version != current.Version {
+		return ErrVersionMismatch
+	}
+	current.Tags = m.taskTagNames(taskID)
+	task, err := patchTask(current, patch, fields)
+	if err != nil {
+		return err
+	}
+	if err := checkTask(task); err != nil {
+		return err
+	}
+	if err := checkTagNames(task.Tags); err != nil {
+		return err
+	}
+	if task.ParentID != nil && (current.ParentID == nil || *task.ParentID != *current.ParentID) {
+		parent, ok := m.tasks[*task.ParentID]
+		if !ok || parent.UserID != userID {
+			return ErrTaskNotFound
+		}
+		// The new parent must not be the task itself or one of its descendants
+		for ancestor := &parent; ; {
+			if ancestor.ID == taskID {
+				return ErrTaskCycle
+			}
+			if ancestor.ParentID == nil {
+				break
+			}
+			next := m.tasks[*ancestor.ParentID]
+			ancestor = &next
+		}
+		parentID := *task.ParentID
+		task.ParentID = &parentID
+	}
+	completing := task.Status == "done" && current.Status != "done"
+	if completing {
+		if err := m.checkBlockers(current); err != nil {
+			return err
+		}
+	}
+
+	m.setTaskTags(userID, taskID, task.Tags)
+	task.DueAt = utcCopy(task.DueAt)
+	task.Tags = nil
+	task.Version++
+	task.UpdatedAt = time.Now()
+	m.tasks[taskID] = task
+	if completing {
+		m.createNextOccurrence(task)
+	}
+	return nil
+}
+
+// DeleteTask deletes a task by its ID from memory, handling its subtasks
+// according to the given policy. A non-zero version makes it fail with
+// ErrVersionMismatch unless the task is still at that version.
+func (m *MemoryDB) DeleteTask(ctx context.Context, userID, id, version int, children ChildDeletePolicy) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.removeTask(userID, id, version, children)
+}
+
+// removeTask deletes a task, handling its subtasks according to the given
+// policy. Callers hold m.mu for writing.
+func (m *MemoryDB) removeTask(userID, id, version int, children ChildDeletePolicy) error {
+	task, ok := m.tasks[id]
+	if !ok || task.UserID != userID {
+		return ErrTaskNotFound
+	}
+	if version != 0 && version != task.Version {
+		return ErrVersionMismatch
+	}
+
+	childIDs := m.childIDs(id)
+	switch children {
+	case RestrictChildren:
+		if len(childIDs) > 0 {
+			return ErrTaskHasChildren
+		}
+	case CascadeChildren:
+		for len(childIDs) > 0 {
+			childID := childIDs[0]
+			childIDs = append(childIDs[1:], m.childIDs(childID)...)
+			m.deleteTask(childID)
+		}
+	default:
+		var newParent *int
+		if children == ReparentChildren {
+			newParent = task.ParentID
+		}
+		for _, childID := range childIDs {
+			child := m.tasks[childID]
+			child.ParentID = newParent
+			child.Version++
+			child.UpdatedAt = time.Now()
+			m.tasks[childID] = child
+		}
+	}
+	m.deleteTask(id)
+	return nil
+}
+
+// deleteTask removes a task with its tag links and dependencies. Callers hold
+// m.mu for writing.
+func (m *MemoryDB) deleteTask(id int) {
+	delete(m.tasks, id)
+	delete(m.taskTags, id)
+	delete(m.dependencies, id)
+	for _, blockers := range m.dependencies {
+		delete(blockers, id)
+	}
+}
+
+// createNextOccurrence stores the next occurrence of a task that was just
+// marked done. Callers hold m.mu for writing.
+func (m *MemoryDB) createNextOccurrence(done Task) {
+	done.Tags = m.taskTagNames(done.ID)
+	if next, ok := nextOccurrence(done); ok {
+		m.insertTask(next)
+	}
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"sort"
+)
+
+// blockerIDs returns the sorted IDs of the tasks blocking a task. Callers hold m.mu.
+func (m *MemoryDB) blockerIDs(taskID int) []int {
+	ids := make([]int, 0, len(m.dependencies[taskID]))
+	for id := range m.dependencies[taskID] {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// blockedIDs returns the sorted IDs of the tasks a task blocks. Callers hold m.mu.
+func (m *MemoryDB) blockedIDs(blockerID int) []int {
+	ids := make([]int, 0)
+	for taskID, blockers := range m.dependencies {
+		if blockers[blockerID] {
+			ids = append(ids, taskID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// checkBlockers returns a *BlockedError when a task that is not done yet
+// still has open blockers. Callers hold m.mu.
+func (m *MemoryDB) checkBlockers(task Task) error {
+	return m.checkBlockersWith(task, nil)
+}
+
+// checkBlockersWith is checkBlockers taking the tasks in done as done
+// already. Callers hold m.mu.
+func (m *MemoryDB) checkBlockersWith(task Task, done map[int]bool) error {
+	if task.Status == "done" {
+		return nil
+	}
+	open := make([]int, 0)
+	for _, id := range m.blockerIDs(task.ID) {
+		if m.tasks[id].Status != "done" && !done[id] {
+			open = append(open, id)
+		}
+	}
+	if len(open) > 0 {
+		return &BlockedError{Blockers: open}
+	}
+	return nil
+}
+
+// AddTaskBlocker records in memory that a task is blocked by another task of
+// the same user. Adding an existing dependency is a no-op.
+func (m *MemoryDB) AddTaskBlocker(ctx context.Context, userID, taskID, blockerID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if task, ok := m.tasks[taskID]; !ok || task.UserID != userID {
+		return ErrTaskNotFound
+	}
+	if blocker, ok := m.tasks[blockerID]; !ok || blocker.UserID != userID {
+		return ErrTaskNotFound
+	}
+	// The blocker must not already wait on the task, directly or transitively
+	seen := map[int]bool{blockerID: true}
+	for pending := []int{blockerID}; len(pending) > 0; pending = pending[1:] {
+		if pending[0] == taskID {
+			return ErrDependencyCycle
+		}
+		for id := range m.dependencies[pending[0]] {
+			if !seen[id] {
+				seen[id] = true
+				pending = append(pending, id)
+			}
+		}
+	}
+
+	if m.dependencies[taskID] == nil {
+		m.dependencies[taskID] = make(map[int]bool)
+	}
+	m.dependencies[taskID][blockerID] = true
+	return nil
+}
+
+// RemoveTaskBlocker deletes a dependency between two tasks from memory.
+func (m *MemoryDB) RemoveTaskBlocker(ctx context.Context, userID, taskID, blockerID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if task, ok := m.tasks[taskID]; !ok || task.UserID != userID {
+		return ErrTaskNotFound
+	}
+	if !m.dependencies[taskID][blockerID] {
+		return ErrDependencyNotFound
+	}
+	delete(m.dependencies[taskID], blockerID)
+	return nil
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// GetUserByExternalIdentity retrieves the user linked to an issuer and
+// subject from memory.
+func (m *MemoryDB) GetUserByExternalIdentity(ctx context.Context, issuer, subject string) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, identity := range m.externalIdentities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return m.users[identity.UserID], nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+// CreateExternalUser stores a new user without a password in memory, linked
+// to the identity. It returns ErrUsernameExists when the username is taken
+// and ErrExternalIdentityExists when the identity is already linked.
+func (m *MemoryDB) CreateExternalUser(ctx context.Context, newUser User, identity ExternalIdentity) (User, error) {
+	if err := ctx.Err(); err != nil {
+		return User{}, err
+	}
+	if newUser.Username == "" || len(newUser.Username) > 50 {
+		return User{}, errors.New("invalid username")
+	}
+	if identity.Issuer == "" || identity.Subject == "" {
+		return User{}, errors.New("invalid external identity")
+	}
+	if newUser.Role == "" {
+		newUser.Role = RoleUser
+	}
+	if !validRoles[newUser.Role] {
+		return User{}, ErrInvalidRole
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Username == newUser.Username {
+			return User{}, ErrUsernameExists
+		}
+	}
+	for _, existing := range m.externalIdentities {
+		if existing.Issuer == identity.Issuer && existing.Subject == identity.Subject {
+			return User{}, ErrExternalIdentityExists
+		}
+	}
+
+	newUser.ID = m.nextUserID
+	newUser.Password = ""
+	m.nextUserID++
+	m.users[newUser.ID] = newUser
+	identity.ID = len(m.externalIdentities) + 1
+	identity.UserID = newUser.ID
+	identity.CreatedAt = time.Now()
+	m.externalIdentities = append(m.externalIdentities, identity)
+	return newUser, nil
+}
+
+// CreateOIDCLogin stores a pending login in memory, dropping expired ones on
+// the way.
+func (m *MemoryDB) CreateOIDCLogin(ctx context.Context, login OIDCLogin) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for stateHash, pending := range m.oidcLogins {
+		if pending.ExpiresAt.Before(now) {
+			delete(m.oidcLogins, stateHash)
+		}
+	}
+	if _, ok := m.oidcLogins[login.StateHash]; ok {
+		return errors.New("login state already exists")
+	}
+	login.ID = m.nextOIDCLoginID
+	m.nextOIDCLoginID++
+	m.oidcLogins[login.StateHash] = login
+	return nil
+}
+
+// ConsumeOIDCLogin removes a pending login from memory and returns it. It
+// returns ErrOIDCLoginInvalid when the state is unknown, was already used or
+// has expired.
+func (m *MemoryDB) ConsumeOIDCLogin(ctx context.Context, stateHash string) (OIDCLogin, error) {
+	if err := ctx.Err(); err != nil {
+		return OIDCLogin{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	login, ok := m.oidcLogins[stateHash]
+	if !ok {
+		return OIDCLogin{}, ErrOIDCLoginInvalid
+	}
+	delete(m.oidcLogins, stateHash)
+	if !login.ExpiresAt.After(time.Now()) {
+		return OIDCLogin{}, ErrOIDCLoginInvalid
+	}
+	return login, nil
+}
+
+// GetExternalIdentities retrieves the identities linked to a user from
+// memory, oldest first.
+func (m *MemoryDB) GetExternalIdentities(ctx context.Context, userID int) ([]ExternalIdentity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	identities := make([]ExternalIdentity, 0)
+	for _, identity := range m.externalIdentities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// GetLoginFailures retrieves the failed logins for the given keys from
+// memory, leaving out keys whose last failure was before since.
+func (m *MemoryDB) GetLoginFailures(ctx context.Context, keys []string, since time.Time) ([]LoginFailure, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	failures := []LoginFailure{}
+	for _, key := range keys {
+		if failure, ok := m.loginFailures[key]; ok && !failure.LastFailureAt.Before(since) {
+			failures = append(failures, failure)
+		}
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Key < failures[j].Key })
+	return failures, nil
+}
+
+// RecordLoginFailure counts a failed login for the key in memory and returns
+// the new count. Failures before since are forgotten, and so are other keys
+// whose last failure was before since.
+func (m *MemoryDB) RecordLoginFailure(ctx context.Context, key string, since time.Time) (LoginFailure, error) {
+	if err := ctx.Err(); err != nil {
+		return LoginFailure{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, failure := range m.loginFailures {
+		if k != key && failure.LastFailureAt.Before(since) {
+			delete(m.loginFailures, k)
+		}
+	}
+	failure, ok := m.loginFailures[key]
+	if !ok || failure.LastFailureAt.Before(since) {
+		failure = LoginFailure{Key: key}
+	}
+	failure.Failures++
+	failure.LastFailureAt = time.Now()
+	m.loginFailures[key] = failure
+	return failure, nil
+}
+
+// ClearLoginFailures forgets the failed logins for the key in memory.
+func (m *MemoryDB) ClearLoginFailures(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.loginFailures, key)
+	return nil
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// revokeUserRefreshTokens revokes every live refresh token of a user except
+// for the family the access token with keepJTI was issued from. Callers hold
+// m.mu for writing.
+func (m *MemoryDB) revokeUserRefreshTokens(userID int, keepJTI string, now time.Time) {
+	keepFamily := ""
+	for _, token := range m.refreshTokens {
+		if keepJTI != "" && token.AccessJTI == keepJTI {
+			keepFamily = token.FamilyID
+		}
+	}
+	for id, token := range m.refreshTokens {
+		if token.UserID == userID && token.RevokedAt == nil && (keepFamily == "" || token.FamilyID != keepFamily) {
+			token.RevokedAt = &now
+			m.refreshTokens[id] = token
+		}
+	}
+}
+
+// UpdateUserPassword stores a new password hash for a user in memory and
+// signs the user out everywhere except the session of the access token with
+// keepJTI.
+func (m *MemoryDB) UpdateUserPassword(ctx context.Context, userID int, passwordHash, keepJTI string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Password = passwordHash
+	m.users[userID] = user
+	m.revokeUserRefreshTokens(userID, keepJTI, time.Now())
+	return nil
+}
+
+// CreatePasswordReset stores a password reset token in memory, dropping the
+// user's expired and used tokens on the way.
+func (m *MemoryDB) CreatePasswordReset(ctx context.Context, reset PasswordResetToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[reset.UserID]; !ok {
+		return errors.New("token owner does not exist")
+	}
+	now := time.Now()
+	for id, existing := range m.passwordResets {
+		if existing.UserID == reset.UserID && (existing.ExpiresAt.Before(now) || existing.UsedAt != nil) {
+			delete(m.passwordResets, id)
+		}
+	}
+	for _, existing := range m.passwordResets {
+		if existing.TokenHash == reset.TokenHash {
+			return errors.New("token already exists")
+		}
+	}
+	reset.ID = m.nextPasswordResetID
+	reset.ExpiresAt = reset.ExpiresAt.UTC()
+	reset.UsedAt = nil
+	m.nextPasswordResetID++
+	m.passwordResets[reset.ID] = reset
+	return nil
+}
+
+// ResetPassword uses up a password reset token in memory, stores the new
+// password hash of its user and revokes all of the user's sessions. It returns
+// the user's ID, or ErrResetTokenInvalid when the token is unknown, used or expired.
+func (m *MemoryDB) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var reset PasswordResetToken
+	found := false
+	for _, existing := range m.passwordResets {
+		if existing.TokenHash == tokenHash {
+			reset, found = existing, true
+			break
+		}
+	}
+	if !found || reset.UsedAt != nil || now.After(reset.ExpiresAt) {
+		return 0, ErrResetTokenInvalid
+	}
+
+	// Every outstanding token of the user is used up, not only this one
+	for id, existing := range m.passwordResets {
+		if existing.UserID == reset.UserID && existing.UsedAt == nil {
+			existing.UsedAt = &now
+			m.passwordResets[id] = existing
+		}
+	}
+	user := m.users[reset.UserID]
+	user.Password = passwordHash
+	m.users[reset.UserID] = user
+	m.revokeUserRefreshTokens(reset.UserID, "", now)
+	return reset.UserID, nil
+}
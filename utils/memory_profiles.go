@@ -0,0 +1,35 @@
+package utils
+
+import "context"
+
+// GetProfile retrieves a user's profile from memory.
+func (m *MemoryDB) GetProfile(ctx context.Context, userID int) (Profile, error) {
+	if err := ctx.Err(); err != nil {
+		return Profile{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return Profile{}, ErrUserNotFound
+	}
+	if profile, ok := m.profiles[userID]; ok {
+		return profile, nil
+	}
+	return DefaultProfile, nil
+}
+
+// UpdateProfile replaces a user's profile in memory.
+func (m *MemoryDB) UpdateProfile(ctx context.Context, userID int, profile Profile) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	m.profiles[userID] = profile
+	return nil
+}
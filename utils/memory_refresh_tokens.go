@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// refreshTokenByHash returns the ID of the refresh token with the given hash.
+// Callers hold m.mu.
+func (m *MemoryDB) refreshTokenByHash(tokenHash string) (int, bool) {
+	for id, token := range m.refreshTokens {
+		if token.TokenHash == tokenHash {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// insertRefreshToken stores a refresh token after enforcing the constraints
+// of the refresh_tokens table. Callers hold m.mu for writing.
+func (m *MemoryDB) insertRefreshToken(token RefreshToken) (RefreshToken, error) {
+	if _, ok := m.users[token.UserID]; !ok {
+		return RefreshToken{}, errors.New("token owner does not exist")
+	}
+	for _, existing := range m.refreshTokens {
+		if existing.TokenHash == token.TokenHash || existing.AccessJTI == token.AccessJTI {
+			return RefreshToken{}, errors.New("token already exists")
+		}
+	}
+	token.ID = m.nextRefreshTokenID
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	token.CreatedAt = time.Now()
+	token.UsedAt = nil
+	token.RevokedAt = nil
+	m.nextRefreshTokenID++
+	m.refreshTokens[token.ID] = token
+	return token, nil
+}
+
+// revokeFamily revokes every live token of a family. Callers hold m.mu for writing.
+func (m *MemoryDB) revokeFamily(familyID string, now time.Time) {
+	for id, token := range m.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			m.refreshTokens[id] = token
+		}
+	}
+}
+
+// CreateRefreshToken stores the first refresh token of a new family in
+// memory, dropping the user's expired tokens on the way.
+func (m *MemoryDB) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, existing := range m.refreshTokens {
+		if existing.UserID == token.UserID && existing.ExpiresAt.Before(now) {
+			delete(m.refreshTokens, id)
+		}
+	}
+	_, err := m.insertRefreshToken(token)
+	return err
+}
+
+// RotateRefreshToken exchanges a refresh token for the next one of its family
+// in memory and returns the stored next token. Presenting a token that was
+// already rotated revokes the whole family and returns ErrRefreshTokenReused.
+func (m *MemoryDB) RotateRefreshToken(ctx context.Context, tokenHash string, next RefreshToken) (RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return RefreshToken{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	id, ok := m.refreshTokenByHash(tokenHash)
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	current := m.refreshTokens[id]
+	if current.RevokedAt != nil || now.After(current.ExpiresAt) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	if current.UsedAt != nil {
+		m.revokeFamily(current.FamilyID, now)
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	stored, err := m.insertRefreshToken(next)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	current.UsedAt = &now
+	m.refreshTokens[id] = current
+	return stored, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token of the family a refresh token
+// belongs to in memory, together with their access tokens.
+func (m *MemoryDB) RevokeRefreshTokenFamily(ctx context.Context, tokenHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, ok := m.refreshTokenByHash(tokenHash)
+	if !ok {
+		return ErrRefreshTokenInvalid
+	}
+	m.revokeFamily(m.refreshTokens[id].FamilyID, time.Now())
+	return nil
+}
+
+// IsAccessTokenRevoked reports whether the family of the access token with
+// the given jti was revoked. Unknown jtis count as revoked.
+func (m *MemoryDB) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, token := range m.refreshTokens {
+		if token.AccessJTI == jti {
+			return token.RevokedAt != nil, nil
+		}
+	}
+	return true, nil
+}
+
+// GetRefreshTokens retrieves every refresh token of the user from memory,
+// oldest first, without their hashes.
+func (m *MemoryDB) GetRefreshTokens(ctx context.Context, userID int) ([]RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tokens := make([]RefreshToken, 0)
+	for _, token := range m.refreshTokens {
+		if token.UserID == userID {
+			token.TokenHash = ""
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
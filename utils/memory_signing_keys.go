@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// GetSigningKeys retrieves every signing key from memory, newest first.
+func (m *MemoryDB) GetSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]SigningKey, 0, len(m.signingKeys))
+	for _, key := range m.signingKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// CreateSigningKey stores a new signing key in memory.
+func (m *MemoryDB) CreateSigningKey(ctx context.Context, key SigningKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if key.Algorithm != "RS256" && key.Algorithm != "EdDSA" {
+		return errors.New("invalid signing algorithm")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.signingKeys[key.ID]; ok {
+		return errors.New("signing key already exists")
+	}
+	m.signingKeys[key.ID] = key
+	return nil
+}
+
+// DeleteSigningKey removes a signing key from memory. Removing a key that
+// does not exist is not an error, since every instance retires keys.
+func (m *MemoryDB) DeleteSigningKey(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.signingKeys, id)
+	return nil
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// childIDs returns the IDs of the direct subtasks of a task. Callers hold m.mu.
+func (m *MemoryDB) childIDs(id int) []int {
+	ids := make([]int, 0)
+	for _, task := range m.tasks {
+		if task.ParentID != nil && *task.ParentID == id {
+			ids = append(ids, task.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// GetTaskChildren retrieves the direct subtasks of a task from memory.
+func (m *MemoryDB) GetTaskChildren(ctx context.Context, userID, id int) ([]Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if task, ok := m.tasks[id]; !ok || task.UserID != userID {
+		return nil, ErrTaskNotFound
+	}
+	tasks := make([]Task, 0)
+	for _, childID := range m.childIDs(id) {
+		task := m.tasks[childID]
+		task.Tags = m.taskTagNames(task.ID)
+		task.BlockedBy = m.blockerIDs(task.ID)
+		task.Blocks = m.blockedIDs(task.ID)
+		localizeDueAt(&task)
+		tasks = append(tasks, task)
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].CreatedAt.Before(tasks[j].CreatedAt) })
+	return tasks, nil
+}
+
+// MoveTask puts a task under a new parent in memory, or makes it a top-level
+// task when parentID is nil.
+func (m *MemoryDB) MoveTask(ctx context.Context, userID, id int, parentID *int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, ok := m.tasks[id]
+	if !ok || task.UserID != userID {
+		return ErrTaskNotFound
+	}
+	if parentID != nil {
+		parent, ok := m.tasks[*parentID]
+		if !ok || parent.UserID != userID {
+			return ErrTaskNotFound
+		}
+		// The new parent must not be the task itself or one of its descendants
+		for ancestor := &parent; ; {
+			if ancestor.ID == id {
+				return ErrTaskCycle
+			}
+			if ancestor.ParentID == nil {
+				break
+			}
+			next := m.tasks[*ancestor.ParentID]
+			ancestor = &next
+		}
+		newParent := *parentID
+		parentID = &newParent
+	}
+	task.ParentID = parentID
+	task.Version++
+	task.UpdatedAt = time.Now()
+	m.tasks[id] = task
+	return nil
+}
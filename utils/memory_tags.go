@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// checkTagNames enforces the constraints of the tags table.
+func checkTagNames(names []string) error {
+	for _, name := range names {
+		if name == "" || len(name) > 50 {
+			return errors.New("invalid tag name")
+		}
+	}
+	return nil
+}
+
+// taskTagNames returns the sorted tag names of a task. Callers hold m.mu.
+func (m *MemoryDB) taskTagNames(taskID int) []string {
+	names := make([]string, 0, len(m.taskTags[taskID]))
+	for tagID := range m.taskTags[taskID] {
+		names = append(names, m.tags[tagID].Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setTaskTags replaces the tags of a task with the named ones, creating the
+// user's missing tags on the way. Callers hold m.mu for writing.
+func (m *MemoryDB) setTaskTags(userID, taskID int, names []string) {
+	tagIDs := make(map[int]bool, len(names))
+	for _, name := range uniqueStrings(names) {
+		id, ok := m.tagIDByName(userID, name)
+		if !ok {
+			id = m.nextTagID
+			m.nextTagID++
+			m.tags[id] = Tag{ID: id, UserID: userID, Name: name}
+		}
+		tagIDs[id] = true
+	}
+	m.taskTags[taskID] = tagIDs
+}
+
+// tagIDByName looks up a user's tag by name. Callers hold m.mu.
+func (m *MemoryDB) tagIDByName(userID int, name string) (int, bool) {
+	for _, tag := range m.tags {
+		if tag.UserID == userID && tag.Name == name {
+			return tag.ID, true
+		}
+	}
+	return 0, false
+}
+
+// GetTags retrieves all tags of a user from memory ordered by name.
+func (m *MemoryDB) GetTags(ctx context.Context, userID int) ([]Tag, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tags := make([]Tag, 0)
+	for _, tag := range m.tags {
+		if tag.UserID == userID {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, nil
+}
+
+// GetTagByID retrieves a tag by its ID from memory.
+func (m *MemoryDB) GetTagByID(ctx context.Context, userID, id int) (Tag, error) {
+	if err := ctx.Err(); err != nil {
+		return Tag{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tag, ok := m.tags[id]
+	if !ok || tag.UserID != userID {
+		return Tag{}, ErrTagNotFound
+	}
+	return tag, nil
+}
+
+// CreateTag creates a new tag in memory.
+func (m *MemoryDB) CreateTag(ctx context.Context, newTag Tag) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := checkTagNames([]string{newTag.Name}); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[newTag.UserID]; !ok {
+		return 0, errors.New("tag owner does not exist")
+	}
+	if _, ok := m.tagIDByName(newTag.UserID, newTag.Name); ok {
+		return 0, ErrTagExists
+	}
+	newTag.ID = m.nextTagID
+	m.nextTagID++
+	m.tags[newTag.ID] = newTag
+	return newTag.ID, nil
+}
+
+// RenameTag changes the name of an existing tag in memory.
+func (m *MemoryDB) RenameTag(ctx context.Context, userID, id int, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkTagNames([]string{name}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tag, ok := m.tags[id]
+	if !ok || tag.UserID != userID {
+		return ErrTagNotFound
+	}
+	if other, ok := m.tagIDByName(userID, name); ok && other != id {
+		return ErrTagExists
+	}
+	tag.Name = name
+	m.tags[id] = tag
+	return nil
+}
+
+// DeleteTag deletes a tag by its ID from memory, detaching it from its tasks.
+func (m *MemoryDB) DeleteTag(ctx context.Context, userID, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tag, ok := m.tags[id]
+	if !ok || tag.UserID != userID {
+		return ErrTagNotFound
+	}
+	delete(m.tags, id)
+	for _, tagIDs := range m.taskTags {
+		delete(tagIDs, id)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+)
+
+// memoryTaskState is a copy of the task data of a MemoryDB, for undoing a
+// batch.
+type memoryTaskState struct {
+	tasks        map[int]Task
+	tags         map[int]Tag
+	taskTags     map[int]map[int]bool
+	dependencies map[int]map[int]bool
+	nextTaskID   int
+	nextTagID    int
+}
+
+// copySets copies a map of sets.
+func copySets(sets map[int]map[int]bool) map[int]map[int]bool {
+	copied := make(map[int]map[int]bool, len(sets))
+	for key, set := range sets {
+		copied[key] = make(map[int]bool, len(set))
+		for member := range set {
+			copied[key][member] = true
+		}
+	}
+	return copied
+}
+
+// saveTasks copies the task data. Callers hold m.mu.
+func (m *MemoryDB) saveTasks() memoryTaskState {
+	state := memoryTaskState{
+		tasks:        make(map[int]Task, len(m.tasks)),
+		tags:         make(map[int]Tag, len(m.tags)),
+		taskTags:     copySets(m.taskTags),
+		dependencies: copySets(m.dependencies),
+		nextTaskID:   m.nextTaskID,
+		nextTagID:    m.nextTagID,
+	}
+	for id, task := range m.tasks {
+		state.tasks[id] = task
+	}
+	for id, tag := range m.tags {
+		state.tags[id] = tag
+	}
+	return state
+}
+
+// restoreTasks puts back task data saved by saveTasks. Callers hold m.mu for
+// writing.
+func (m *MemoryDB) restoreTasks(state memoryTaskState) {
+	m.tasks = state.tasks
+	m.tags = state.tags
+	m.taskTags = state.taskTags
+	m.dependencies = state.dependencies
+	m.nextTaskID = state.nextTaskID
+	m.nextTagID = state.nextTagID
+}
+
+// RunTaskBatch applies operations to the user's tasks in memory, in order
+// and all or none. It returns the ID of the task of each operation, created
+// ones included. With dryRun the operations are checked but not kept, and
+// created tasks get no ID.
+func (m *MemoryDB) RunTaskBatch(ctx context.Context, userID int, operations []TaskOperation, dryRun bool) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := m.saveTasks()
+	ids := make([]int, len(operations))
+	for i, op := range operations {
+		var err error
+		switch op.Kind {
+		case TaskCreate:
+			op.Task.UserID = userID
+			ids[i], err = m.createTask(op.Task)
+		case TaskUpdate:
+			ids[i], err = op.ID, m.changeTask(userID, op.ID, op.Task, op.Fields)
+		case TaskDelete:
+			ids[i], err = op.ID, m.removeTask(userID, op.ID, op.Version, op.Children)
+		default:
+			err = fmt.Errorf("unknown operation %q", op.Kind)
+		}
+		if err != nil {
+			m.restoreTasks(saved)
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		if dryRun && op.Kind == TaskCreate {
+			ids[i] = 0
+		}
+	}
+	if dryRun {
+		m.restoreTasks(saved)
+	}
+	return ids, nil
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"context"
+	"sort"
+)
+
+// GetTasksByCursor retrieves a page of the user's tasks from memory,
+// starting at cursor or, without one, at the start of the list.
+func (m *MemoryDB) GetTasksByCursor(ctx context.Context, userId int, cursor *TaskCursor, limit int, sortBy, order string, filter TaskFilter) ([]Task, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tasks, less, err := m.sortedTasks(userId, sortBy, order, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := 0, len(tasks)
+	if cursor != nil {
+		at, err := cursor.task(sortBy)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Backward {
+			end = sort.Search(len(tasks), func(i int) bool { return !less(tasks[i], at) })
+			if end-limit > start {
+				start = end - limit
+			}
+			return tasks[start:end], nil
+		}
+		start = sort.Search(len(tasks), func(i int) bool { return less(at, tasks[i]) })
+	}
+	if start+limit < end {
+		end = start + limit
+	}
+	return tasks[start:end], nil
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Parjun2000/task-manager/filterexpr"
+)
+
+// taskPredicate compiles a filter expression to a function matching the
+// tasks it selects. Without an expression every task matches.
+func taskPredicate(expr filterexpr.Expr) func(Task) bool {
+	switch e := expr.(type) {
+	case filterexpr.And:
+		left, right := taskPredicate(e.Left), taskPredicate(e.Right)
+		return func(task Task) bool { return left(task) && right(task) }
+	case filterexpr.Or:
+		left, right := taskPredicate(e.Left), taskPredicate(e.Right)
+		return func(task Task) bool { return left(task) || right(task) }
+	case filterexpr.Not:
+		match := taskPredicate(e.Expr)
+		return func(task Task) bool { return !match(task) }
+	case filterexpr.Comparison:
+		return comparisonPredicate(e)
+	case nil:
+		return func(Task) bool { return true }
+	}
+	return func(Task) bool { return false }
+}
+
+// comparisonPredicate compiles a comparison of a filter expression.
+func comparisonPredicate(c filterexpr.Comparison) func(Task) bool {
+	switch c.Kind {
+	case filterexpr.List:
+		if c.Op == filterexpr.NotEqual {
+			has := textPredicate(filterexpr.Comparison{Op: filterexpr.Equal, Values: c.Values})
+			return func(task Task) bool { return !anyText(task.Tags, has) }
+		}
+		match := textPredicate(c)
+		return func(task Task) bool { return anyText(task.Tags, match) }
+	case filterexpr.Time:
+		match := timePredicate(c)
+		return func(task Task) bool { return match(taskFilterTime(task, c.Field)) }
+	}
+	match := textPredicate(c)
+	return func(task Task) bool { return match(taskFilterText(task, c.Field)) }
+}
+
+// textPredicate returns whether a text passes a comparison.
+func textPredicate(c filterexpr.Comparison) func(string) bool {
+	value := c.Values[0].Text
+	switch c.Op {
+	case filterexpr.NotEqual:
+		return func(s string) bool { return s != value }
+	case filterexpr.Contains:
+		value = strings.ToLower(value)
+		return func(s string) bool { return strings.Contains(strings.ToLower(s), value) }
+	case filterexpr.In:
+		texts := valueTexts(c.Values)
+		return func(s string) bool {
+			for _, text := range texts {
+				if s == text {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return func(s string) bool { return s == value }
+}
+
+// timePredicate returns whether a time, nil when unset, passes a comparison.
+func timePredicate(c filterexpr.Comparison) func(*time.Time) bool {
+	value := c.Values[0]
+	if value.Null {
+		isNull := c.Op == filterexpr.Equal
+		return func(t *time.Time) bool { return (t == nil) == isNull }
+	}
+	return func(t *time.Time) bool {
+		if t == nil {
+			return c.Op == filterexpr.NotEqual
+		}
+		switch c.Op {
+		case filterexpr.NotEqual:
+			return !t.Equal(value.Time)
+		case filterexpr.Less:
+			return t.Before(value.Time)
+		case filterexpr.LessEqual:
+			return !t.After(value.Time)
+		case filterexpr.Greater:
+			return t.After(value.Time)
+		case filterexpr.GreaterEqual:
+			return !t.Before(value.Time)
+		}
+		return t.Equal(value.Time)
+	}
+}
+
+// anyText reports whether any of texts matches.
+func anyText(texts []string, match func(string) bool) bool {
+	for _, text := range texts {
+		if match(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// taskFilterText returns a text field of TaskFilterFields.
+func taskFilterText(task Task, field string) string {
+	switch field {
+	case "title":
+		return task.Title
+	case "description":
+		return task.Description
+	case "status":
+		return task.Status
+	case "priority":
+		return task.Priority
+	}
+	return ""
+}
+
+// taskFilterTime returns a time field of TaskFilterFields, nil when unset.
+func taskFilterTime(task Task, field string) *time.Time {
+	switch field {
+	case "created_at":
+		return &task.CreatedAt
+	case "updated_at":
+		return &task.UpdatedAt
+	case "due_at":
+		return task.DueAt
+	}
+	return nil
+}
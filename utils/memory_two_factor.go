@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// GetTOTP retrieves the user's TOTP credential from memory.
+func (m *MemoryDB) GetTOTP(ctx context.Context, userID int) (TOTPCredential, error) {
+	if err := ctx.Err(); err != nil {
+		return TOTPCredential{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	credential, ok := m.totpCredentials[userID]
+	if !ok {
+		return TOTPCredential{}, ErrTOTPNotFound
+	}
+	for _, used := range m.recoveryCodes[userID] {
+		if !used {
+			credential.RecoveryCodesLeft++
+		}
+	}
+	return credential, nil
+}
+
+// SetTOTPSecret stores a pending TOTP secret for the user in memory,
+// replacing an earlier pending one. It returns ErrTOTPEnabled when the user
+// already confirmed a secret.
+func (m *MemoryDB) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if secret == "" || len(secret) > 256 {
+		return errors.New("invalid secret")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return errors.New("credential owner does not exist")
+	}
+	if existing, ok := m.totpCredentials[userID]; ok && existing.ConfirmedAt != nil {
+		return ErrTOTPEnabled
+	}
+	m.totpCredentials[userID] = TOTPCredential{UserID: userID, Secret: secret}
+	return nil
+}
+
+// EnableTOTP confirms the user's pending TOTP secret in memory, with counter
+// as the time step of the confirming code, and replaces the user's recovery codes.
+func (m *MemoryDB) EnableTOTP(ctx context.Context, userID int, counter int64, recoveryCodeHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credential, ok := m.totpCredentials[userID]
+	if !ok {
+		return ErrTOTPNotFound
+	}
+	if credential.ConfirmedAt != nil {
+		return ErrTOTPEnabled
+	}
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		if _, ok := codes[hash]; ok {
+			return errors.New("duplicate recovery code")
+		}
+		codes[hash] = false
+	}
+	now := time.Now()
+	credential.ConfirmedAt = &now
+	credential.LastCounter = counter
+	m.totpCredentials[userID] = credential
+	m.recoveryCodes[userID] = codes
+	return nil
+}
+
+// DisableTOTP removes the user's TOTP secret and recovery codes from memory.
+func (m *MemoryDB) DisableTOTP(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.totpCredentials[userID]; !ok {
+		return ErrTOTPNotFound
+	}
+	delete(m.totpCredentials, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+// UseTOTPCounter records in memory that a code from the given time step was
+// accepted. It returns ErrTOTPCodeReused unless the time step is later than
+// that of the last accepted code.
+func (m *MemoryDB) UseTOTPCounter(ctx context.Context, userID int, counter int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	credential, ok := m.totpCredentials[userID]
+	if !ok || credential.ConfirmedAt == nil || credential.LastCounter >= counter {
+		return ErrTOTPCodeReused
+	}
+	credential.LastCounter = counter
+	m.totpCredentials[userID] = credential
+	return nil
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes in memory.
+func (m *MemoryDB) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used, ok := m.recoveryCodes[userID][codeHash]
+	if !ok || used {
+		return ErrRecoveryCodeInvalid
+	}
+	m.recoveryCodes[userID][codeHash] = true
+	return nil
+}
+
+// CreateMFAChallenge stores a login challenge in memory, dropping the user's
+// expired and used challenges on the way.
+func (m *MemoryDB) CreateMFAChallenge(ctx context.Context, challenge MFAChallenge) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[challenge.UserID]; !ok {
+		return errors.New("challenge owner does not exist")
+	}
+	now := time.Now()
+	for id, existing := range m.mfaChallenges {
+		if existing.UserID == challenge.UserID && (existing.ExpiresAt.Before(now) || existing.UsedAt != nil) {
+			delete(m.mfaChallenges, id)
+		}
+	}
+	for _, existing := range m.mfaChallenges {
+		if existing.TokenHash == challenge.TokenHash {
+			return errors.New("challenge already exists")
+		}
+	}
+	challenge.ID = m.nextMFAChallengeID
+	challenge.ExpiresAt = challenge.ExpiresAt.UTC()
+	challenge.Attempts = 0
+	challenge.UsedAt = nil
+	m.nextMFAChallengeID++
+	m.mfaChallenges[challenge.ID] = challenge
+	return nil
+}
+
+// AttemptMFAChallenge counts an attempt to answer a login challenge in memory
+// and returns the challenge. It returns ErrMFAChallengeInvalid when the
+// challenge is unknown, expired, used or out of attempts.
+func (m *MemoryDB) AttemptMFAChallenge(ctx context.Context, tokenHash string) (MFAChallenge, error) {
+	if err := ctx.Err(); err != nil {
+		return MFAChallenge{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, challenge := range m.mfaChallenges {
+		if challenge.TokenHash != tokenHash {
+			continue
+		}
+		if challenge.UsedAt != nil || !challenge.ExpiresAt.After(now) || challenge.Attempts >= MaxMFAChallengeAttempts {
+			return MFAChallenge{}, ErrMFAChallengeInvalid
+		}
+		challenge.Attempts++
+		m.mfaChallenges[id] = challenge
+		return challenge, nil
+	}
+	return MFAChallenge{}, ErrMFAChallengeInvalid
+}
+
+// CompleteMFAChallenge uses up a login challenge in memory. It returns
+// ErrMFAChallengeInvalid when the challenge was already used.
+func (m *MemoryDB) CompleteMFAChallenge(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	challenge, ok := m.mfaChallenges[id]
+	if !ok || challenge.UsedAt != nil {
+		return ErrMFAChallengeInvalid
+	}
+	now := time.Now()
+	challenge.UsedAt = &now
+	m.mfaChallenges[id] = challenge
+	return nil
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// GetUsers retrieves a page of users from memory, ordered by ID.
+func (m *MemoryDB) GetUsers(ctx context.Context, page, limit int) ([]User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]User, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	offset := (page - 1) * limit
+	if offset >= len(users) {
+		return make([]User, 0), nil
+	}
+	end := offset + limit
+	if end > len(users) {
+		end = len(users)
+	}
+	return users[offset:end], nil
+}
+
+// SetUserRole changes the role of a user in memory.
+func (m *MemoryDB) SetUserRole(ctx context.Context, id int, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !validRoles[role] {
+		return ErrInvalidRole
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.Role = role
+	m.users[id] = user
+	return nil
+}
+
+// SetUserDisabled disables or re-enables a user's account in memory.
+// Disabling also revokes all of the user's sessions.
+func (m *MemoryDB) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	now := time.Now()
+	switch {
+	case !disabled:
+		user.DisabledAt = nil
+	case user.DisabledAt == nil:
+		user.DisabledAt = &now
+	}
+	m.users[id] = user
+	if disabled {
+		m.revokeUserRefreshTokens(id, "", now)
+	}
+	return nil
+}
+
+// DeleteUser removes a user from memory, along with everything the user
+// owns: tasks, tags, tokens and linked identities.
+func (m *MemoryDB) DeleteUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	for taskID, task := range m.tasks {
+		if task.UserID == id {
+			m.deleteTask(taskID)
+		}
+	}
+	for tagID, tag := range m.tags {
+		if tag.UserID == id {
+			delete(m.tags, tagID)
+		}
+	}
+	for tokenID, token := range m.refreshTokens {
+		if token.UserID == id {
+			delete(m.refreshTokens, tokenID)
+		}
+	}
+	for resetID, reset := range m.passwordResets {
+		if reset.UserID == id {
+			delete(m.passwordResets, resetID)
+		}
+	}
+	for tokenID, token := range m.accessTokens {
+		if token.UserID == id {
+			delete(m.accessTokens, tokenID)
+		}
+	}
+	for challengeID, challenge := range m.mfaChallenges {
+		if challenge.UserID == id {
+			delete(m.mfaChallenges, challengeID)
+		}
+	}
+	for exportID, export := range m.dataExports {
+		if export.UserID == id {
+			delete(m.dataExports, exportID)
+		}
+	}
+	identities := m.externalIdentities[:0]
+	for _, identity := range m.externalIdentities {
+		if identity.UserID != id {
+			identities = append(identities, identity)
+		}
+	}
+	m.externalIdentities = identities
+	delete(m.totpCredentials, id)
+	delete(m.recoveryCodes, id)
+	delete(m.profiles, id)
+	delete(m.users, id)
+	return nil
+}
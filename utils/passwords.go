@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PasswordResetToken is the server-side record of an issued password reset token.
+type PasswordResetToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// revokeUserRefreshTokens revokes every live refresh token of a user, and with
+// them their access tokens, except for the family the access token with
+// keepJTI was issued from. An empty keepJTI revokes them all.
+func revokeUserRefreshTokens(ctx context.Context, tx *sql.Tx, userID int, keepJTI string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 and revoked_at IS NULL
+		and family_id IS DISTINCT FROM (SELECT family_id FROM refresh_tokens WHERE access_jti = $2)`, userID, keepJTI)
+	return err
+}
+
+// UpdateUserPassword stores a new password hash for a user in the database and
+// signs the user out everywhere except the session of the access token with
+// keepJTI.
+func (s *PostgresDB) UpdateUserPassword(ctx context.Context, userID int, passwordHash, keepJTI string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET password = $2 WHERE id = $1", userID, passwordHash)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrUserNotFound
+	}
+	if err := revokeUserRefreshTokens(ctx, tx, userID, keepJTI); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreatePasswordReset stores a password reset token in the database, dropping
+// the user's expired and used tokens on the way.
+func (s *PostgresDB) CreatePasswordReset(ctx context.Context, reset PasswordResetToken) error {
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = $1 and (expires_at < NOW() or used_at IS NOT NULL)", reset.UserID); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		reset.UserID, reset.TokenHash, reset.ExpiresAt.UTC())
+	return err
+}
+
+// ResetPassword uses up a password reset token in the database, stores the new
+// password hash of its user and revokes all of the user's sessions. It returns
+// the user's ID, or ErrResetTokenInvalid when the token is unknown, used or expired.
+func (s *PostgresDB) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var reset PasswordResetToken
+	var usedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT id, user_id, expires_at, used_at FROM password_resets WHERE token_hash = $1 FOR UPDATE", tokenHash).
+		Scan(&reset.ID, &reset.UserID, &reset.ExpiresAt, &usedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrResetTokenInvalid
+		}
+		return 0, err
+	}
+	if usedAt.Valid || time.Now().After(reset.ExpiresAt) {
+		return 0, ErrResetTokenInvalid
+	}
+
+	// Every outstanding token of the user is used up, not only this one
+	if _, err := tx.ExecContext(ctx, "UPDATE password_resets SET used_at = NOW() WHERE user_id = $1 and used_at IS NULL", reset.UserID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET password = $2 WHERE id = $1", reset.UserID, passwordHash); err != nil {
+		return 0, err
+	}
+	if err := revokeUserRefreshTokens(ctx, tx, reset.UserID, ""); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return reset.UserID, nil
+}
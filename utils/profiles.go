@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Profile holds the details and preferences users manage themselves.
+type Profile struct {
+	DisplayName string `json:"display_name"`
+	Email       string `json:"email"`
+	// Timezone is an IANA timezone name, given to tasks due without one.
+	Timezone string `json:"timezone"`
+	// DefaultSortBy and DefaultSortOrder sort task lists that do not ask for
+	// an order of their own.
+	DefaultSortBy    string `json:"default_sort_by"`
+	DefaultSortOrder string `json:"default_sort_order"`
+}
+
+// DefaultProfile is the profile of users who have not changed theirs.
+var DefaultProfile = Profile{Timezone: "UTC", DefaultSortBy: "created_at", DefaultSortOrder: "desc"}
+
+// GetProfile retrieves a user's profile from the database.
+func (s *PostgresDB) GetProfile(ctx context.Context, userID int) (Profile, error) {
+	var profile Profile
+	err := s.DB.QueryRowContext(ctx, "SELECT display_name, email, timezone, default_sort_by, default_sort_order FROM users WHERE id = $1", userID).
+		Scan(&profile.DisplayName, &profile.Email, &profile.Timezone, &profile.DefaultSortBy, &profile.DefaultSortOrder)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Profile{}, ErrUserNotFound
+		}
+		return Profile{}, err
+	}
+	return profile, nil
+}
+
+// UpdateProfile replaces a user's profile in the database.
+func (s *PostgresDB) UpdateProfile(ctx context.Context, userID int, profile Profile) error {
+	result, err := s.DB.ExecContext(ctx, "UPDATE users SET display_name = $2, email = $3, timezone = $4, default_sort_by = $5, default_sort_order = $6 WHERE id = $1",
+		userID, profile.DisplayName, profile.Email, profile.Timezone, profile.DefaultSortBy, profile.DefaultSortOrder)
+	if err != nil {
+		return err
+	}
+	return userAffected(result)
+}
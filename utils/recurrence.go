@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Parjun2000/task-manager/rrule"
+)
+
+// completedTask returns the state of a task after an update that marks it
+// done, for working out its next occurrence.
+func completedTask(current, updated Task) Task {
+	updated.ID = current.ID
+	updated.UserID = current.UserID
+	updated.ParentID = current.ParentID
+	if updated.Tags == nil {
+		updated.Tags = current.Tags
+	}
+	return updated
+}
+
+// now is the clock next occurrences are scheduled against, replaced in tests.
+var now = time.Now
+
+// nextOccurrence returns the task that follows a recurring task once it is
+// done, or false when the task does not recur or its rule is exhausted. The
+// rule is expanded in the task's timezone, so the due time keeps its wall
+// clock time across daylight saving changes. Occurrences that went by while
+// the task was not done are skipped, so a late completion does not create a
+// task that is already overdue.
+func nextOccurrence(done Task) (Task, bool) {
+	if done.Recurrence == "" || done.DueAt == nil {
+		return Task{}, false
+	}
+	rule, err := rrule.Parse(done.Recurrence)
+	if err != nil {
+		return Task{}, false
+	}
+	localizeDueAt(&done)
+	current := now()
+	it := rule.Iterator(*done.DueAt)
+	// The occurrence just done and every one skipped no longer count
+	// towards COUNT
+	passed := 0
+	var dueAt time.Time
+	for {
+		next, ok := it.Next()
+		if !ok {
+			return Task{}, false
+		}
+		if next.After(*done.DueAt) && next.After(current) {
+			dueAt = next
+			break
+		}
+		passed++
+	}
+	if rule.Count > 0 {
+		rule.Count -= passed
+	}
+
+	return Task{
+		Title:       done.Title,
+		Description: done.Description,
+		Status:      "todo",
+		UserID:      done.UserID,
+		DueAt:       &dueAt,
+		DueTimezone: done.DueTimezone,
+		Priority:    done.Priority,
+		Tags:        done.Tags,
+		ParentID:    done.ParentID,
+		Recurrence:  rule.String(),
+	}, true
+}
+
+// createNextOccurrence inserts the next occurrence of a task that was just
+// marked done inside the same transaction.
+func createNextOccurrence(ctx context.Context, tx *sql.Tx, done Task) error {
+	next, ok := nextOccurrence(done)
+	if !ok {
+		return nil
+	}
+	_, err := insertTask(ctx, tx, next)
+	return err
+}
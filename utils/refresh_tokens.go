@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RefreshToken is the server-side record of an issued refresh token. Login
+// starts a token family and every rotation adds the next token to it.
+type RefreshToken struct {
+	ID        int    `json:"id"`
+	UserID    int    `json:"user_id"`
+	FamilyID  string `json:"family_id"`
+	TokenHash string `json:"-"`
+	// AccessJTI is the jti of the access token issued together with the
+	// refresh token; it stays valid until the family is revoked.
+	AccessJTI string     `json:"access_jti"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// CreateRefreshToken stores the first refresh token of a new family in the
+// database, dropping the user's expired tokens on the way.
+func (s *PostgresDB) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE user_id = $1 and expires_at < NOW()", token.UserID); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO refresh_tokens (user_id, family_id, token_hash, access_jti, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		token.UserID, token.FamilyID, token.TokenHash, token.AccessJTI, token.ExpiresAt.UTC())
+	return err
+}
+
+// RotateRefreshToken exchanges a refresh token for the next one of its family
+// in the database and returns the stored next token. Presenting a token that
+// was already rotated revokes the whole family and returns ErrRefreshTokenReused.
+func (s *PostgresDB) RotateRefreshToken(ctx context.Context, tokenHash string, next RefreshToken) (RefreshToken, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	var current RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT id, user_id, family_id, expires_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE", tokenHash).
+		Scan(&current.ID, &current.UserID, &current.FamilyID, &current.ExpiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, ErrRefreshTokenInvalid
+		}
+		return RefreshToken{}, err
+	}
+	if revokedAt.Valid || time.Now().After(current.ExpiresAt) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	if usedAt.Valid {
+		if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 and revoked_at IS NULL", current.FamilyID); err != nil {
+			return RefreshToken{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1", current.ID); err != nil {
+		return RefreshToken{}, err
+	}
+	next.UserID = current.UserID
+	next.FamilyID = current.FamilyID
+	err = tx.QueryRowContext(ctx, "INSERT INTO refresh_tokens (user_id, family_id, token_hash, access_jti, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at",
+		next.UserID, next.FamilyID, next.TokenHash, next.AccessJTI, next.ExpiresAt.UTC()).Scan(&next.ID, &next.CreatedAt)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+	return next, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token of the family a refresh token
+// belongs to in the database, together with their access tokens.
+func (s *PostgresDB) RevokeRefreshTokenFamily(ctx context.Context, tokenHash string) error {
+	var familyID string
+	err := s.DB.QueryRowContext(ctx, "SELECT family_id FROM refresh_tokens WHERE token_hash = $1", tokenHash).Scan(&familyID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRefreshTokenInvalid
+		}
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 and revoked_at IS NULL", familyID)
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the family of the access token with
+// the given jti was revoked. Unknown jtis count as revoked.
+func (s *PostgresDB) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := s.DB.QueryRowContext(ctx, "SELECT revoked_at IS NOT NULL FROM refresh_tokens WHERE access_jti = $1", jti).Scan(&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	return revoked, err
+}
+
+// GetRefreshTokens retrieves every refresh token of the user from the
+// database, oldest first, without their hashes.
+func (s *PostgresDB) GetRefreshTokens(ctx context.Context, userID int) ([]RefreshToken, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, user_id, family_id, access_jti, expires_at, created_at, used_at, revoked_at
+		FROM refresh_tokens WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]RefreshToken, 0)
+	for rows.Next() {
+		var token RefreshToken
+		var usedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&token.ID, &token.UserID, &token.FamilyID, &token.AccessJTI, &token.ExpiresAt, &token.CreatedAt, &usedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if usedAt.Valid {
+			token.UsedAt = &usedAt.Time
+		}
+		if revokedAt.Valid {
+			token.RevokedAt = &revokedAt.Time
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
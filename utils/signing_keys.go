@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// SigningKey is a stored key for signing access tokens. PrivateKey is
+// encrypted, storage never sees it in the clear.
+type SigningKey struct {
+	ID         string    `json:"kid"`
+	Algorithm  string    `json:"algorithm"`
+	PrivateKey []byte    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// GetSigningKeys retrieves every signing key from the database, newest first.
+func (s *PostgresDB) GetSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT kid, algorithm, private_key, created_at FROM signing_keys ORDER BY created_at DESC, kid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var key SigningKey
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// CreateSigningKey stores a new signing key in the database.
+func (s *PostgresDB) CreateSigningKey(ctx context.Context, key SigningKey) error {
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO signing_keys (kid, algorithm, private_key, created_at) VALUES ($1, $2, $3, $4)",
+		key.ID, key.Algorithm, key.PrivateKey, key.CreatedAt.UTC())
+	return err
+}
+
+// DeleteSigningKey removes a signing key from the database. Removing a key
+// that does not exist is not an error, since every instance retires keys.
+func (s *PostgresDB) DeleteSigningKey(ctx context.Context, id string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM signing_keys WHERE kid = $1", id)
+	return err
+}
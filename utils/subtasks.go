@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TaskProgress counts the done subtasks of a task.
+type TaskProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+// ChildDeletePolicy decides what happens to the subtasks of a deleted task.
+type ChildDeletePolicy string
+
+const (
+	// DetachChildren turns the subtasks into top-level tasks.
+	DetachChildren ChildDeletePolicy = "detach"
+	// ReparentChildren moves the subtasks under the deleted task's parent.
+	ReparentChildren ChildDeletePolicy = "reparent"
+	// CascadeChildren deletes the subtasks and all their descendants.
+	CascadeChildren ChildDeletePolicy = "cascade"
+	// RestrictChildren refuses to delete a task that has subtasks.
+	RestrictChildren ChildDeletePolicy = "restrict"
+)
+
+// ParseChildDeletePolicy validates a policy name, defaulting to DetachChildren.
+func ParseChildDeletePolicy(name string) (ChildDeletePolicy, error) {
+	switch policy := ChildDeletePolicy(name); policy {
+	case "":
+		return DetachChildren, nil
+	case DetachChildren, ReparentChildren, CascadeChildren, RestrictChildren:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown subtask delete policy %q", name)
+}
+
+// hierarchyLockClass namespaces the advisory locks taken on a user's task tree.
+const hierarchyLockClass = 1
+
+// lockHierarchy serialises changes to the parent links of a user's tasks
+// until the transaction ends, so concurrent moves cannot form a cycle.
+func lockHierarchy(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2)", hierarchyLockClass, userID)
+	return err
+}
+
+// lockTask checks that a task belongs to the user and keeps it from being
+// deleted until the transaction ends.
+func lockTask(ctx context.Context, tx *sql.Tx, userID, id int) error {
+	var found int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM tasks WHERE id = $1 and user_id = $2 FOR UPDATE", id, userID).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTaskNotFound
+	}
+	return err
+}
+
+// GetTaskChildren retrieves the direct subtasks of a task from the database.
+func (s *PostgresDB) GetTaskChildren(ctx context.Context, userID, id int) ([]Task, error) {
+	if _, err := s.GetTaskByID(ctx, userID, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+taskColumns+" FROM tasks WHERE parent_id = $1 and user_id = $2 ORDER BY created_at, id", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := make([]Task, 0)
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// MoveTask puts a task under a new parent in the database, or makes it a
+// top-level task when parentID is nil.
+func (s *PostgresDB) MoveTask(ctx context.Context, userID, id int, parentID *int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockHierarchy(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := lockTask(ctx, tx, userID, id); err != nil {
+		return err
+	}
+	if parentID != nil {
+		if err := lockTask(ctx, tx, userID, *parentID); err != nil {
+			return err
+		}
+		// The new parent must not be the task itself or one of its descendants
+		var cycle bool
+		err := tx.QueryRowContext(ctx, "WITH RECURSIVE ancestors AS ("+
+			"SELECT id, parent_id FROM tasks WHERE id = $1"+
+			" UNION SELECT t.id, t.parent_id FROM tasks t JOIN ancestors a ON t.id = a.parent_id"+
+			") SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)", *parentID, id).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return ErrTaskCycle
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE tasks SET parent_id = $1, version = version + 1, updated_at = $2 WHERE id = $3 and user_id = $4", parentID, time.Now(), id, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// Tag represents a label a user can attach to any number of their tasks.
+type Tag struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// isUniqueViolation reports whether err comes from a violated unique constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// uniqueStrings returns the distinct values of a slice in sorted order.
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// setTaskTags replaces the tags of a task with the named ones, creating the
+// user's missing tags on the way.
+func setTaskTags(ctx context.Context, tx *sql.Tx, userID, taskID int, names []string) error {
+	names = uniqueStrings(names)
+	if _, err := tx.ExecContext(ctx, "INSERT INTO tags (user_id, name) SELECT $1, unnest($2::text[]) ON CONFLICT (user_id, name) DO NOTHING",
+		userID, pq.Array(names)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM task_tags WHERE task_id = $1", taskID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO task_tags (task_id, tag_id) SELECT $1, id FROM tags WHERE user_id = $2 and name = ANY($3)",
+		taskID, userID, pq.Array(names))
+	return err
+}
+
+// GetTags retrieves all tags of a user from the database ordered by name.
+func (s *PostgresDB) GetTags(ctx context.Context, userID int) ([]Tag, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, user_id, name FROM tags WHERE user_id = $1 ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]Tag, 0)
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.UserID, &tag.Name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// GetTagByID retrieves a tag by its ID from the database.
+func (s *PostgresDB) GetTagByID(ctx context.Context, userID, id int) (Tag, error) {
+	var tag Tag
+	row := s.DB.QueryRowContext(ctx, "SELECT id, user_id, name FROM tags WHERE id = $1 and user_id = $2", id, userID)
+	err := row.Scan(&tag.ID, &tag.UserID, &tag.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Tag{}, ErrTagNotFound
+		}
+		return Tag{}, err
+	}
+	return tag, nil
+}
+
+// CreateTag creates a new tag in the database.
+func (s *PostgresDB) CreateTag(ctx context.Context, newTag Tag) (int, error) {
+	var id int
+	err := s.DB.QueryRowContext(ctx, "INSERT INTO tags (user_id, name) VALUES ($1, $2) RETURNING id",
+		newTag.UserID, newTag.Name).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return 0, ErrTagExists
+		}
+		return 0, err
+	}
+	return id, nil
+}
+
+// RenameTag changes the name of an existing tag in the database.
+func (s *PostgresDB) RenameTag(ctx context.Context, userID, id int, name string) error {
+	result, err := s.DB.ExecContext(ctx, "UPDATE tags SET name = $1 WHERE id = $2 and user_id = $3", name, id, userID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrTagExists
+		}
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// DeleteTag deletes a tag by its ID from the database, detaching it from its tasks.
+func (s *PostgresDB) DeleteTag(ctx context.Context, userID, id int) error {
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM tags WHERE id = $1 and user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+)
+
+// Kinds of TaskOperation.
+const (
+	TaskCreate = "create"
+	TaskUpdate = "update"
+	TaskDelete = "delete"
+)
+
+// TaskOperation is one change of a batch: creating Task, changing the Fields
+// of task ID to those of Task, or deleting task ID with its subtasks handled
+// according to Children. A non-zero Task.Version for updates, or Version for
+// deletes, needs the task to still be at that version.
+type TaskOperation struct {
+	Kind     string
+	ID       int
+	Version  int
+	Task     Task
+	Fields   []string
+	Children ChildDeletePolicy
+}
+
+// BatchError is returned when an operation of a batch fails, which undoes
+// the whole batch.
+type BatchError struct {
+	// Index is the position of the failed operation in the batch.
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// RunTaskBatch applies operations to the user's tasks in one transaction, in
+// order and all or none. It returns the ID of the task of each operation,
+// created ones included. With dryRun the operations are checked but not
+// written, and created tasks get no ID.
+func (s *PostgresDB) RunTaskBatch(ctx context.Context, userID int, operations []TaskOperation, dryRun bool) ([]int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(operations))
+	for i, op := range operations {
+		switch op.Kind {
+		case TaskCreate:
+			op.Task.UserID = userID
+			ids[i], err = createTaskTx(ctx, tx, op.Task)
+		case TaskUpdate:
+			ids[i], err = op.ID, patchTaskTx(ctx, tx, userID, op.ID, op.Task, op.Fields)
+		case TaskDelete:
+			ids[i], err = op.ID, deleteTaskTx(ctx, tx, userID, op.ID, op.Version, op.Children)
+		default:
+			err = fmt.Errorf("unknown operation %q", op.Kind)
+		}
+		if err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		if dryRun && op.Kind == TaskCreate {
+			ids[i] = 0
+		}
+	}
+	if dryRun {
+		return ids, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrInvalidCursor is returned for cursors that do not fit the sort order
+// of the list they are used with.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TaskCursor is a place in a sorted task list, next to the task with ID
+// whose sort column is Key. Pages read from a cursor hold the tasks after
+// that task or, with Backward, the tasks before it. Unlike pages read by
+// offset, they neither skip nor repeat tasks added or removed meanwhile.
+type TaskCursor struct {
+	ID       int
+	Key      string
+	Backward bool
+}
+
+// TaskCursorAt returns the cursor at task in a list sorted by sortBy.
+func TaskCursorAt(task Task, sortBy string, backward bool) TaskCursor {
+	cursor := TaskCursor{ID: task.ID, Backward: backward}
+	switch sortBy {
+	case "title":
+		cursor.Key = task.Title
+	case "description":
+		cursor.Key = task.Description
+	case "status":
+		cursor.Key = task.Status
+	case "created_at":
+		cursor.Key = task.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "due_at":
+		// Tasks without a due date have an empty key
+		if task.DueAt != nil {
+			cursor.Key = task.DueAt.UTC().Format(time.RFC3339Nano)
+		}
+	case "priority":
+		cursor.Key = task.Priority
+	}
+	return cursor
+}
+
+// task returns the task of the cursor as far as it is known: its ID and
+// sort column.
+func (c TaskCursor) task(sortBy string) (Task, error) {
+	task := Task{ID: c.ID}
+	switch sortBy {
+	case "title":
+		task.Title = c.Key
+	case "description":
+		task.Description = c.Key
+	case "status":
+		task.Status = c.Key
+	case "created_at":
+		createdAt, err := time.Parse(time.RFC3339Nano, c.Key)
+		if err != nil {
+			return Task{}, ErrInvalidCursor
+		}
+		task.CreatedAt = createdAt
+	case "due_at":
+		if c.Key != "" {
+			dueAt, err := time.Parse(time.RFC3339Nano, c.Key)
+			if err != nil {
+				return Task{}, ErrInvalidCursor
+			}
+			task.DueAt = &dueAt
+		}
+	case "priority":
+		if priorityRank(c.Key) == 0 {
+			return Task{}, ErrInvalidCursor
+		}
+		task.Priority = c.Key
+	default:
+		return Task{}, errors.New("invalid sort option")
+	}
+	return task, nil
+}
+
+// GetTasksByCursor retrieves a page of the user's tasks from the database,
+// starting at cursor or, without one, at the start of the list.
+func (s *PostgresDB) GetTasksByCursor(ctx context.Context, userId int, cursor *TaskCursor, limit int, sortBy, order string, filter TaskFilter) ([]Task, error) {
+	q := newTaskQuery("SELECT "+taskColumns, userId, filter)
+	backward := false
+	if cursor != nil {
+		condition, err := q.keyset(*cursor, sortBy, order)
+		if err != nil {
+			return nil, err
+		}
+		q.query += " and " + condition
+		backward = cursor.Backward
+	}
+	// Pages before a cursor are read from their end
+	q.query += taskOrderBy(sortBy, order, backward)
+	q.query += " LIMIT " + q.arg(limit)
+	tasks, err := s.queryTasks(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if backward {
+		for i, j := 0, len(tasks)-1; i < j; i, j = i+1, j-1 {
+			tasks[i], tasks[j] = tasks[j], tasks[i]
+		}
+	}
+	return tasks, nil
+}
+
+// keyset returns the condition selecting the tasks on the side of cursor it
+// reads, in a list ordered by taskOrderBy.
+func (q *taskQuery) keyset(cursor TaskCursor, sortBy, order string) (string, error) {
+	task, err := cursor.task(sortBy)
+	if err != nil {
+		return "", err
+	}
+	var value interface{}
+	switch sortBy {
+	case "title":
+		value = task.Title
+	case "description":
+		value = task.Description
+	case "status":
+		value = task.Status
+	case "created_at":
+		value = task.CreatedAt.UTC()
+	case "due_at":
+		if task.DueAt != nil {
+			value = task.DueAt.UTC()
+		}
+	case "priority":
+		value = priorityRank(task.Priority)
+	}
+
+	id := q.arg(cursor.ID)
+	// Tasks without a due date come last in either order
+	if sortBy == "due_at" && value == nil {
+		if cursor.Backward {
+			return "(due_at IS NOT NULL or id < " + id + ")", nil
+		}
+		return "(due_at IS NULL and id > " + id + ")", nil
+	}
+
+	compare, tie := ">", ">"
+	if (order == "desc") != cursor.Backward {
+		compare = "<"
+	}
+	if cursor.Backward {
+		tie = "<"
+	}
+	expression := taskSortExpression(sortBy)
+	key := q.arg(value)
+	condition := "(" + expression + " " + compare + " " + key + " or (" + expression + " = " + key + " and id " + tie + " " + id + ")"
+	if sortBy == "due_at" && !cursor.Backward {
+		condition += " or due_at IS NULL"
+	}
+	return condition + ")", nil
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/Parjun2000/task-manager/filterexpr"
+	"github.com/lib/pq"
+)
+
+// TaskFilterFields are the fields filter expressions of task lists can use.
+var TaskFilterFields = map[string]filterexpr.Field{
+	"title":       {Kind: filterexpr.Text},
+	"description": {Kind: filterexpr.Text},
+	"status":      {Kind: filterexpr.Text},
+	"priority":    {Kind: filterexpr.Text},
+	"tags":        {Kind: filterexpr.List},
+	"created_at":  {Kind: filterexpr.Time},
+	"updated_at":  {Kind: filterexpr.Time},
+	"due_at":      {Kind: filterexpr.Time, Nullable: true},
+}
+
+// taskFilterColumns maps the text and time fields of TaskFilterFields to the
+// SQL expression holding them.
+var taskFilterColumns = map[string]string{
+	"title":       "title",
+	"description": "COALESCE(description, '')",
+	"status":      "status",
+	"priority":    "priority",
+	"created_at":  "created_at",
+	"updated_at":  "updated_at",
+	"due_at":      "due_at",
+}
+
+// where compiles a filter expression to a condition. Every condition is
+// either true or false, never NULL, so negating it selects the other tasks.
+func (q *taskQuery) where(expr filterexpr.Expr) string {
+	switch e := expr.(type) {
+	case filterexpr.And:
+		return "(" + q.where(e.Left) + " and " + q.where(e.Right) + ")"
+	case filterexpr.Or:
+		return "(" + q.where(e.Left) + " or " + q.where(e.Right) + ")"
+	case filterexpr.Not:
+		return "NOT " + q.where(e.Expr)
+	case filterexpr.Comparison:
+		return q.comparison(e)
+	}
+	return "FALSE"
+}
+
+// comparison compiles a comparison of a filter expression.
+func (q *taskQuery) comparison(c filterexpr.Comparison) string {
+	value := c.Values[0]
+	if c.Kind == filterexpr.List {
+		tagged := "SELECT tt.task_id FROM task_tags tt JOIN tags g ON g.id = tt.tag_id WHERE g.user_id = $1 and "
+		switch c.Op {
+		case filterexpr.Contains:
+			tagged += "g.name ILIKE " + q.arg(likePattern(value.Text))
+		case filterexpr.In:
+			tagged += "g.name = ANY(" + q.arg(pq.Array(valueTexts(c.Values))) + ")"
+		default:
+			tagged += "g.name = " + q.arg(value.Text)
+		}
+		if c.Op == filterexpr.NotEqual {
+			return "id NOT IN (" + tagged + ")"
+		}
+		return "id IN (" + tagged + ")"
+	}
+
+	column := taskFilterColumns[c.Field]
+	switch {
+	case value.Null && c.Op == filterexpr.Equal:
+		return column + " IS NULL"
+	case value.Null:
+		return column + " IS NOT NULL"
+	case c.Op == filterexpr.In:
+		return column + " = ANY(" + q.arg(pq.Array(valueTexts(c.Values))) + ")"
+	case c.Op == filterexpr.Contains:
+		return column + " ILIKE " + q.arg(likePattern(value.Text))
+	}
+	var arg interface{} = value.Text
+	if c.Kind == filterexpr.Time {
+		arg = value.Time.UTC()
+	}
+	placeholder := q.arg(arg)
+	if !TaskFilterFields[c.Field].Nullable {
+		return column + " " + sqlOperator(c.Op) + " " + placeholder
+	}
+	if c.Op == filterexpr.NotEqual {
+		return "(" + column + " IS NULL or " + column + " <> " + placeholder + ")"
+	}
+	return "(" + column + " IS NOT NULL and " + column + " " + sqlOperator(c.Op) + " " + placeholder + ")"
+}
+
+// sqlOperator returns the SQL operator of a comparison.
+func sqlOperator(op filterexpr.Op) string {
+	if op == filterexpr.NotEqual {
+		return "<>"
+	}
+	return string(op)
+}
+
+// likePattern returns the ILIKE pattern matching text containing s.
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// valueTexts returns the texts of the values of a comparison.
+func valueTexts(values []filterexpr.Value) []string {
+	texts := make([]string, len(values))
+	for i, value := range values {
+		texts[i] = value.Text
+	}
+	return texts
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MaxMFAChallengeAttempts is how many codes can be tried with one challenge.
+const MaxMFAChallengeAttempts = 5
+
+// TOTPCredential is a user's TOTP secret. Two-factor authentication is
+// enabled once the secret is confirmed.
+type TOTPCredential struct {
+	UserID      int        `json:"user_id"`
+	Secret      string     `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	// LastCounter is the time step of the last accepted code.
+	LastCounter int64 `json:"-"`
+	// RecoveryCodesLeft counts the unused recovery codes.
+	RecoveryCodesLeft int `json:"recovery_codes_left"`
+}
+
+// MFAChallenge is the second step of a login with two-factor authentication.
+type MFAChallenge struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	Attempts  int        `json:"attempts"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// GetTOTP retrieves the user's TOTP credential from the database.
+func (s *PostgresDB) GetTOTP(ctx context.Context, userID int) (TOTPCredential, error) {
+	credential := TOTPCredential{UserID: userID}
+	var confirmedAt sql.NullTime
+	err := s.DB.QueryRowContext(ctx, `SELECT secret, confirmed_at, last_counter,
+		(SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 and used_at IS NULL)
+		FROM totp_credentials WHERE user_id = $1`, userID).
+		Scan(&credential.Secret, &confirmedAt, &credential.LastCounter, &credential.RecoveryCodesLeft)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return TOTPCredential{}, ErrTOTPNotFound
+		}
+		return TOTPCredential{}, err
+	}
+	if confirmedAt.Valid {
+		credential.ConfirmedAt = &confirmedAt.Time
+	}
+	return credential, nil
+}
+
+// SetTOTPSecret stores a pending TOTP secret for the user in the database,
+// replacing an earlier pending one. It returns ErrTOTPEnabled when the user
+// already confirmed a secret.
+func (s *PostgresDB) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	result, err := s.DB.ExecContext(ctx, `INSERT INTO totp_credentials (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_counter = 0, created_at = NOW()
+		WHERE totp_credentials.confirmed_at IS NULL`, userID, secret)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTOTPEnabled
+	}
+	return nil
+}
+
+// EnableTOTP confirms the user's pending TOTP secret in the database, with
+// counter as the time step of the confirming code, and replaces the user's
+// recovery codes.
+func (s *PostgresDB) EnableTOTP(ctx context.Context, userID int, counter int64, recoveryCodeHashes []string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var confirmedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT confirmed_at FROM totp_credentials WHERE user_id = $1 FOR UPDATE", userID).Scan(&confirmedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTOTPNotFound
+		}
+		return err
+	}
+	if confirmedAt.Valid {
+		return ErrTOTPEnabled
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE totp_credentials SET confirmed_at = NOW(), last_counter = $2 WHERE user_id = $1", userID, counter); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP removes the user's TOTP secret and recovery codes from the database.
+func (s *PostgresDB) DisableTOTP(ctx context.Context, userID int) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM totp_credentials WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrTOTPNotFound
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPCounter records in the database that a code from the given time step
+// was accepted. It returns ErrTOTPCodeReused unless the time step is later
+// than that of the last accepted code.
+func (s *PostgresDB) UseTOTPCounter(ctx context.Context, userID int, counter int64) error {
+	result, err := s.DB.ExecContext(ctx, "UPDATE totp_credentials SET last_counter = $2 WHERE user_id = $1 and confirmed_at IS NOT NULL and last_counter < $2", userID, counter)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes in the database.
+func (s *PostgresDB) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	result, err := s.DB.ExecContext(ctx, "UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 and code_hash = $2 and used_at IS NULL", userID, codeHash)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+// CreateMFAChallenge stores a login challenge in the database, dropping the
+// user's expired and used challenges on the way.
+func (s *PostgresDB) CreateMFAChallenge(ctx context.Context, challenge MFAChallenge) error {
+	if _, err := s.DB.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE user_id = $1 and (expires_at < NOW() or used_at IS NOT NULL)", challenge.UserID); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, "INSERT INTO mfa_challenges (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		challenge.UserID, challenge.TokenHash, challenge.ExpiresAt.UTC())
+	return err
+}
+
+// AttemptMFAChallenge counts an attempt to answer a login challenge in the
+// database and returns the challenge. It returns ErrMFAChallengeInvalid when
+// the challenge is unknown, expired, used or out of attempts.
+func (s *PostgresDB) AttemptMFAChallenge(ctx context.Context, tokenHash string) (MFAChallenge, error) {
+	var challenge MFAChallenge
+	err := s.DB.QueryRowContext(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE token_hash = $1 and used_at IS NULL and expires_at > NOW() and attempts < $2
+		RETURNING id, user_id, expires_at, attempts`, tokenHash, MaxMFAChallengeAttempts).
+		Scan(&challenge.ID, &challenge.UserID, &challenge.ExpiresAt, &challenge.Attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return MFAChallenge{}, ErrMFAChallengeInvalid
+		}
+		return MFAChallenge{}, err
+	}
+	return challenge, nil
+}
+
+// CompleteMFAChallenge uses up a login challenge in the database. It returns
+// ErrMFAChallengeInvalid when the challenge was already used.
+func (s *PostgresDB) CompleteMFAChallenge(ctx context.Context, id int) error {
+	result, err := s.DB.ExecContext(ctx, "UPDATE mfa_challenges SET used_at = NOW() WHERE id = $1 and used_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrMFAChallengeInvalid
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+)
+
+// GetUsers retrieves a page of users from the database, ordered by ID.
+func (s *PostgresDB) GetUsers(ctx context.Context, page, limit int) ([]User, error) {
+	rows, err := s.DB.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY id LIMIT $1 OFFSET $2", limit, (page-1)*limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SetUserRole changes the role of a user in the database.
+func (s *PostgresDB) SetUserRole(ctx context.Context, id int, role string) error {
+	if role != RoleUser && role != RoleAdmin {
+		return ErrInvalidRole
+	}
+	result, err := s.DB.ExecContext(ctx, "UPDATE users SET role = $2 WHERE id = $1", id, role)
+	if err != nil {
+		return err
+	}
+	return userAffected(result)
+}
+
+// SetUserDisabled disables or re-enables a user's account in the database.
+// Disabling also revokes all of the user's sessions.
+func (s *PostgresDB) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END WHERE id = $1", id, disabled)
+	if err != nil {
+		return err
+	}
+	if err := userAffected(result); err != nil {
+		return err
+	}
+	if disabled {
+		if err := revokeUserRefreshTokens(ctx, tx, id, ""); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// userAffected returns ErrUserNotFound when an update of a single user
+// matched no row.
+func userAffected(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes a user from the database, along with everything the
+// user owns: tasks, tags, tokens and linked identities.
+func (s *PostgresDB) DeleteUser(ctx context.Context, id int) error {
+	result, err := s.DB.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return userAffected(result)
+}